@@ -3,28 +3,90 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Servers []Server `yaml:"servers"`
-	Docker  Docker   `yaml:"docker"`
+	Servers    []Server   `yaml:"servers"`
+	HTTP       HTTP       `yaml:"http"`
+	Docker     Docker     `yaml:"docker"`
+	Jobs       Jobs       `yaml:"jobs"`
+	SSH        SSH        `yaml:"ssh"`
+	Auth       Auth       `yaml:"auth"`
+	Audit      Audit      `yaml:"audit"`
+	Encryption Encryption `yaml:"encryption"`
+	Inventory  Inventory  `yaml:"inventory"`
+	Backups    Backups    `yaml:"backups"`
+
+	// secrets are the resolved credentials, kept for redaction from logs
+	secrets []string
+	// store holds the servers added through the API
+	store *ServerStore
+	// schedules holds the backup schedules added through the API
+	schedules *ScheduleStore
 }
 
 // Server represents a server configuration
 type Server struct {
 	ID           string `yaml:"id"`
-	Name         string `yaml:"name"`
-	Host         string `yaml:"host"`
-	Port         int    `yaml:"port"`
-	Username     string `yaml:"username"`
-	PostgresUser string `yaml:"postgres_user"`
-	Password     string `yaml:"password"`
-	PrivateKey   string `yaml:"private_key"`
-	DockerHost   string `yaml:"docker_host"`
-	Description  string `yaml:"description"`
+	Name         string `yaml:"name,omitempty"`
+	Host         string `yaml:"host,omitempty"`
+	Port         int    `yaml:"port,omitempty"`
+	Username     string `yaml:"username,omitempty"`
+	PostgresUser string `yaml:"postgres_user,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	PrivateKey   string `yaml:"private_key,omitempty"`
+	DockerHost   string `yaml:"docker_host,omitempty"`
+	Description  string `yaml:"description,omitempty"`
+	// Environment is the stage the server belongs to, like prod, staging or dev
+	Environment string `yaml:"environment,omitempty"`
+	// Group is a free-form grouping of servers, like a team or product
+	Group string `yaml:"group,omitempty"`
+	// Tags label the server for filtering and bulk operations
+	Tags []string `yaml:"tags,omitempty"`
+	// PrivateKeyPassphrase decrypts an encrypted private key
+	PrivateKeyPassphrase string `yaml:"private_key_passphrase,omitempty"`
+	// ForwardAgent forwards the SSH agent at SSH_AUTH_SOCK to the server
+	ForwardAgent bool `yaml:"forward_agent,omitempty"`
+	// HostKeyFingerprint pins the SHA256 fingerprint of the server's host key
+	HostKeyFingerprint string `yaml:"host_key_fingerprint,omitempty"`
+	// KnownHostsFile verifies the host key against an OpenSSH known_hosts file
+	KnownHostsFile string `yaml:"known_hosts_file,omitempty"`
+	// JumpHosts are the bastions the server is reached through, in order
+	JumpHosts []JumpHost `yaml:"jump_hosts,omitempty"`
+}
+
+// JumpHost represents an SSH bastion on the way to a server
+type JumpHost struct {
+	Host                 string `yaml:"host"`
+	Port                 int    `yaml:"port,omitempty"`
+	Username             string `yaml:"username,omitempty"`
+	Password             string `yaml:"password,omitempty"`
+	PrivateKey           string `yaml:"private_key,omitempty"`
+	PrivateKeyPassphrase string `yaml:"private_key_passphrase,omitempty"`
+	HostKeyFingerprint   string `yaml:"host_key_fingerprint,omitempty"`
+	KnownHostsFile       string `yaml:"known_hosts_file,omitempty"`
+}
+
+// IsLocal reports whether commands for the server run on this machine
+// rather than over SSH. Servers behind jump hosts are always remote, even
+// when their host is localhost as seen from the last hop.
+func (s *Server) IsLocal() bool {
+	if len(s.JumpHosts) > 0 {
+		return false
+	}
+	return s.Host == "localhost" || s.Host == "127.0.0.1" || s.Host == ""
+}
+
+// HTTP represents configuration of the API server
+type HTTP struct {
+	// TrustedProxies are the addresses and CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header is believed for the client address.
+	// Without any, the address of the connection is used.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 // Docker represents Docker configuration
@@ -33,8 +95,193 @@ type Docker struct {
 	TLSVerify   bool   `yaml:"tls_verify"`
 }
 
+// Jobs represents asynchronous dump job configuration
+type Jobs struct {
+	Workers   int           `yaml:"workers"`
+	QueueSize int           `yaml:"queue_size"`
+	SpoolDir  string        `yaml:"spool_dir"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// SSH represents SSH client configuration
+type SSH struct {
+	// KnownHostsFile is the managed store of accepted host keys, used for
+	// servers without a pinned fingerprint or known_hosts file of their own
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	// StrictHostKeyChecking rejects unknown hosts instead of trusting and
+	// recording their key on first use
+	StrictHostKeyChecking bool `yaml:"strict_host_key_checking"`
+}
+
+// Auth represents API authentication configuration
+type Auth struct {
+	// Disabled turns authentication off entirely, for local development only
+	Disabled bool `yaml:"disabled"`
+	// Tokens are static API tokens, sent as "Authorization: Bearer <token>"
+	Tokens []APIToken `yaml:"tokens"`
+	// Users are local users logging in with a password to get a session cookie
+	Users      []User        `yaml:"users"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// CookieSecure restricts the session cookie to HTTPS
+	CookieSecure bool `yaml:"cookie_secure"`
+	// Roles grant permissions to the tokens and users they are assigned to
+	Roles []Role `yaml:"roles"`
+}
+
+// APIToken represents a static API token
+type APIToken struct {
+	Name string `yaml:"name"`
+	// Hash is the hex encoded SHA-256 hash of the token
+	Hash  string   `yaml:"hash"`
+	Roles []string `yaml:"roles"`
+}
+
+// User represents a local user
+type User struct {
+	Username string `yaml:"username"`
+	// PasswordHash is the bcrypt hash of the password
+	PasswordHash string   `yaml:"password_hash"`
+	Roles        []string `yaml:"roles"`
+}
+
+// Role grants permissions on the servers, containers and databases matching
+// its patterns
+type Role struct {
+	Name        string   `yaml:"name"`
+	Permissions []string `yaml:"permissions"`
+	// Servers, Containers and Databases are glob patterns matched against
+	// server IDs, container names and database names. An empty list matches
+	// everything. Host PostgreSQL is matched as the container "@host".
+	Servers    []string `yaml:"servers"`
+	Containers []string `yaml:"containers"`
+	Databases  []string `yaml:"databases"`
+}
+
+// Audit represents audit log configuration
+type Audit struct {
+	// File is the append-only JSON lines file audit events are written to
+	File string `yaml:"file"`
+}
+
+// Encryption represents dump encryption configuration
+type Encryption struct {
+	// Keys are the AES-256 keys dumps can be encrypted with by key ID
+	Keys []EncryptionKey `yaml:"keys"`
+	// AgeIdentityFile holds the age identities that decrypt age encrypted
+	// uploads on restore
+	AgeIdentityFile string `yaml:"age_identity_file"`
+}
+
+// EncryptionKey represents a named AES-256 key
+type EncryptionKey struct {
+	ID string `yaml:"id"`
+	// Key is the base64 encoded 32 byte key
+	Key string `yaml:"key"`
+}
+
+// Inventory represents configuration of the servers managed through the API
+type Inventory struct {
+	// File is the YAML file servers added through the API are stored in
+	File string `yaml:"file"`
+}
+
+// Backups represents scheduled backup configuration
+type Backups struct {
+	// Dir is the directory of the built in "local" storage
+	Dir string `yaml:"dir"`
+	// Storage are further places backups can be written to
+	Storage []Storage `yaml:"storage"`
+	// CatalogFile is the JSON file recording the backups written to storage
+	CatalogFile string `yaml:"catalog_file"`
+	// VerifyImage is the image restore verifications run in, tagged with
+	// the PostgreSQL major version of the backup
+	VerifyImage string `yaml:"verify_image"`
+	// VerifyTimeout bounds a restore verification, including the pull of
+	// the image
+	VerifyTimeout time.Duration `yaml:"verify_timeout"`
+	// SchedulesFile is the YAML file schedules added through the API are
+	// stored in
+	SchedulesFile string `yaml:"schedules_file"`
+	// Schedules are the backup schedules of config.yaml, which are read only
+	// through the API
+	Schedules []Schedule `yaml:"schedules"`
+}
+
+// Storage represents a named place backups are written to
+type Storage struct {
+	Name string `yaml:"name"`
+	// Type is "local", "s3" or "sftp"
+	Type string `yaml:"type"`
+	// Path is the directory of local and SFTP storage
+	Path string `yaml:"path"`
+
+	// Endpoint is the URL of an S3-compatible object store, AWS S3 in
+	// Region by default
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// PathStyle addresses the bucket in the path rather than the host name,
+	// as most S3-compatible stores like MinIO expect
+	PathStyle bool `yaml:"path_style"`
+	// PartSize is the size in MiB of the parts of multipart uploads
+	PartSize int `yaml:"part_size"`
+
+	// Server is the ID of the server SFTP storage connects to, with its SSH
+	// credentials, jump hosts and host key
+	Server string `yaml:"server"`
+}
+
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
+	config, err := parseConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := openServerStore(config.Inventory.File, config.Servers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server store: %w", err)
+	}
+	config.store = store
+
+	schedules, err := openScheduleStore(config.Backups.SchedulesFile, config.Backups.Schedules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schedule store: %w", err)
+	}
+	config.schedules = schedules
+
+	return config, nil
+}
+
+// reloadConfig loads configuration from a YAML file for a running backend.
+// The server store of the current configuration is kept, even if the
+// inventory file changed, and only takes the servers of the new
+// configuration once it has loaded.
+func reloadConfig(path string, current *Config) (*Config, error) {
+	config, err := parseConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := current.schedules.checkStatic(config.Backups.Schedules); err != nil {
+		return nil, err
+	}
+	if err := current.store.setStatic(config.Servers); err != nil {
+		return nil, err
+	}
+	config.store = current.store
+	current.schedules.setStatic(config.Backups.Schedules)
+	config.schedules = current.schedules
+
+	return config, nil
+}
+
+// parseConfig reads a YAML file into a configuration with its secrets
+// resolved and defaults applied
+func parseConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
@@ -45,15 +292,101 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := config.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	config.applyDefaults()
+
+	if err := config.validateServers(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
-// GetServerByID returns a server by its ID
-func (c *Config) GetServerByID(id string) (*Server, error) {
+// validateServers checks the servers of the configuration the same way as
+// servers added through the API, and that no ID is used twice
+func (c *Config) validateServers() error {
+	seen := make(map[string]bool, len(c.Servers))
 	for _, server := range c.Servers {
-		if server.ID == id {
-			return &server, nil
+		if err := server.Validate(); err != nil {
+			return fmt.Errorf("invalid server %q: %w", server.ID, err)
+		}
+		if seen[server.ID] {
+			return fmt.Errorf("server %q is defined more than once: %w", server.ID, ErrServerExists)
 		}
+		seen[server.ID] = true
+	}
+	return nil
+}
+
+// applyDefaults fills in defaults for optional settings
+func (c *Config) applyDefaults() {
+	if c.Jobs.Workers <= 0 {
+		c.Jobs.Workers = 2
+	}
+	if c.Jobs.QueueSize <= 0 {
+		c.Jobs.QueueSize = 100
+	}
+	if c.Jobs.SpoolDir == "" {
+		c.Jobs.SpoolDir = "spool"
 	}
-	return nil, fmt.Errorf("server with ID %s not found", id)
+	if c.Jobs.Retention <= 0 {
+		c.Jobs.Retention = 24 * time.Hour
+	}
+	if c.SSH.KnownHostsFile == "" {
+		c.SSH.KnownHostsFile = "data/known_hosts"
+	}
+	if c.Auth.SessionTTL <= 0 {
+		c.Auth.SessionTTL = 12 * time.Hour
+	}
+	if c.Audit.File == "" {
+		c.Audit.File = "data/audit.log"
+	}
+	if c.Inventory.File == "" {
+		c.Inventory.File = "data/servers.yaml"
+	}
+	if c.Backups.Dir == "" {
+		c.Backups.Dir = "data/backups"
+	}
+	if c.Backups.CatalogFile == "" {
+		c.Backups.CatalogFile = "data/catalog.json"
+	}
+	if c.Backups.VerifyImage == "" {
+		c.Backups.VerifyImage = "postgres"
+	}
+	if c.Backups.VerifyTimeout <= 0 {
+		c.Backups.VerifyTimeout = 2 * time.Hour
+	}
+	if c.Backups.SchedulesFile == "" {
+		c.Backups.SchedulesFile = "data/schedules.yaml"
+	}
+}
+
+// GetServers returns the servers of config.yaml followed by the servers
+// added through the API
+func (c *Config) GetServers() []Server {
+	return c.store.List()
+}
+
+// GetServerByID returns a server by its ID
+func (c *Config) GetServerByID(id string) (*Server, error) {
+	return c.store.Get(id)
+}
+
+// ServerStore returns the store of servers added through the API
+func (c *Config) ServerStore() *ServerStore {
+	return c.store
+}
+
+// GetSchedules returns the backup schedules of config.yaml followed by the
+// schedules added through the API
+func (c *Config) GetSchedules() []Schedule {
+	return c.schedules.List()
+}
+
+// ScheduleStore returns the store of schedules added through the API
+func (c *Config) ScheduleStore() *ScheduleStore {
+	return c.schedules
 }
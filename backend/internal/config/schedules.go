@@ -0,0 +1,327 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"backend/internal/utils"
+)
+
+// hostContainerPattern selects host PostgreSQL in the containers of a
+// schedule
+const hostContainerPattern = "@host"
+
+var (
+	// ErrScheduleNotFound is returned for unknown schedule names
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrScheduleExists is returned when adding a schedule with a name in use
+	ErrScheduleExists = errors.New("schedule already exists")
+	// ErrScheduleReadOnly is returned when changing a schedule of config.yaml
+	ErrScheduleReadOnly = errors.New("schedule is defined in config.yaml and cannot be changed through the API")
+)
+
+// Schedule represents recurring backups of the databases matched by its
+// selectors
+type Schedule struct {
+	Name string `yaml:"name"`
+	// Cron is a five field cron expression or a macro like @daily
+	Cron string `yaml:"cron"`
+	// Timezone is the IANA time zone Cron is evaluated in, local time by default
+	Timezone string         `yaml:"timezone,omitempty"`
+	Servers  ServerSelector `yaml:"servers,omitempty"`
+	// Containers are glob patterns of container names, all containers by
+	// default. Patterns never match host PostgreSQL, which is only backed up
+	// if "@host" itself is listed.
+	Containers []string `yaml:"containers,omitempty"`
+	// Databases are glob patterns of database names, all databases by default
+	Databases []string        `yaml:"databases,omitempty"`
+	Options   ScheduleOptions `yaml:"options,omitempty"`
+	Retention Retention       `yaml:"retention,omitempty"`
+	// Storage names the storage backups are written to, the backup
+	// directory by default
+	Storage  string `yaml:"storage,omitempty"`
+	Disabled bool   `yaml:"disabled,omitempty"`
+}
+
+// ScheduleOptions are the pg_dump, compression and encryption options of
+// scheduled backups. Encrypted backups use configured keys or age
+// recipients, as there is nobody to enter a passphrase.
+type ScheduleOptions struct {
+	Format           string   `yaml:"format,omitempty"`
+	DataOnly         bool     `yaml:"data_only,omitempty"`
+	SchemaOnly       bool     `yaml:"schema_only,omitempty"`
+	Tables           []string `yaml:"tables,omitempty"`
+	ExcludeTables    []string `yaml:"exclude_tables,omitempty"`
+	Schemas          []string `yaml:"schemas,omitempty"`
+	ExcludeSchemas   []string `yaml:"exclude_schemas,omitempty"`
+	ExcludeTableData []string `yaml:"exclude_table_data,omitempty"`
+	Compression      string   `yaml:"compression,omitempty"`
+	CompressionLevel int      `yaml:"compression_level,omitempty"`
+	Encryption       string   `yaml:"encryption,omitempty"`
+	Recipients       []string `yaml:"recipients,omitempty"`
+	KeyID            string   `yaml:"key_id,omitempty"`
+}
+
+// Retention is how many backups of each database a schedule keeps: the
+// newest backup of each of the last Daily days, Weekly weeks and Monthly
+// months. Without any limits every backup is kept.
+type Retention struct {
+	Daily   int `yaml:"daily,omitempty"`
+	Weekly  int `yaml:"weekly,omitempty"`
+	Monthly int `yaml:"monthly,omitempty"`
+}
+
+// IsZero reports whether the retention keeps every backup
+func (r Retention) IsZero() bool {
+	return r.Daily == 0 && r.Weekly == 0 && r.Monthly == 0
+}
+
+// Validate checks the fields of a schedule that do not need parsing of the
+// cron expression
+func (s *Schedule) Validate() error {
+	if !serverIDPattern.MatchString(s.Name) {
+		return fmt.Errorf("name %q must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", s.Name)
+	}
+	if s.Cron == "" {
+		return fmt.Errorf("schedule %q has no cron expression", s.Name)
+	}
+	if _, err := s.Location(); err != nil {
+		return fmt.Errorf("schedule %q: %w", s.Name, err)
+	}
+	for _, pattern := range s.Containers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("schedule %q: invalid container pattern %q: %w", s.Name, pattern, err)
+		}
+		// Container names cannot start with "@", so such a pattern is a
+		// mistyped or wildcarded "@host" that would back up nothing
+		if strings.HasPrefix(pattern, "@") && pattern != hostContainerPattern {
+			return fmt.Errorf("schedule %q: container pattern %q matches nothing, list %q to back up host PostgreSQL", s.Name, pattern, hostContainerPattern)
+		}
+	}
+	if s.Retention.Daily < 0 || s.Retention.Weekly < 0 || s.Retention.Monthly < 0 {
+		return fmt.Errorf("schedule %q: retention counts cannot be negative", s.Name)
+	}
+	return nil
+}
+
+// Location returns the time zone the schedule is evaluated in
+func (s *Schedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	return location, nil
+}
+
+// scheduleFile is the layout of the schedule store file, the same as the
+// schedules of config.yaml
+type scheduleFile struct {
+	Schedules []Schedule `yaml:"schedules"`
+}
+
+// ScheduleStore holds the backup schedules. Schedules of config.yaml are
+// read only, schedules added through the API are kept in a YAML file that
+// is rewritten atomically on every change.
+type ScheduleStore struct {
+	path string
+
+	mu     sync.RWMutex
+	static []Schedule
+	stored []Schedule
+}
+
+// openScheduleStore loads the schedules of the store file next to the
+// schedules of config.yaml. A missing file is an empty store.
+func openScheduleStore(path string, static []Schedule) (*ScheduleStore, error) {
+	s := &ScheduleStore{path: path}
+	if err := s.checkStatic(static); err != nil {
+		return nil, err
+	}
+	s.static = static
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule store: %w", err)
+	}
+
+	var file scheduleFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule store: %w", err)
+	}
+	for _, schedule := range file.Schedules {
+		if s.indexOf(schedule.Name) >= 0 || s.isStatic(schedule.Name) {
+			return nil, fmt.Errorf("schedule %q: %w", schedule.Name, ErrScheduleExists)
+		}
+		s.stored = append(s.stored, schedule)
+	}
+	return s, nil
+}
+
+// checkStatic checks the schedules of config.yaml, which may not share
+// names with each other or with stored schedules
+func (s *ScheduleStore) checkStatic(static []Schedule) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make(map[string]bool)
+	for _, schedule := range static {
+		if err := schedule.Validate(); err != nil {
+			return err
+		}
+		if names[schedule.Name] || s.indexOf(schedule.Name) >= 0 {
+			return fmt.Errorf("schedule %q: %w", schedule.Name, ErrScheduleExists)
+		}
+		names[schedule.Name] = true
+	}
+	return nil
+}
+
+// setStatic replaces the schedules of config.yaml, after checkStatic
+// accepted them
+func (s *ScheduleStore) setStatic(static []Schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.static = static
+}
+
+// List returns all schedules, those of config.yaml first
+func (s *ScheduleStore) List() []Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append(append([]Schedule(nil), s.static...), s.stored...)
+}
+
+// Get returns a schedule by its name
+func (s *ScheduleStore) Get(name string) (*Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, schedule := range s.static {
+		if schedule.Name == name {
+			return &schedule, nil
+		}
+	}
+	if i := s.indexOf(name); i >= 0 {
+		schedule := s.stored[i]
+		return &schedule, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrScheduleNotFound, name)
+}
+
+// ReadOnly reports whether a schedule is defined in config.yaml
+func (s *ScheduleStore) ReadOnly(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isStatic(name)
+}
+
+// Create adds a schedule to the store
+func (s *ScheduleStore) Create(schedule Schedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isStatic(schedule.Name) || s.indexOf(schedule.Name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrScheduleExists, schedule.Name)
+	}
+	return s.commit(append(append([]Schedule(nil), s.stored...), schedule))
+}
+
+// Update replaces a schedule of the store. Its name cannot be changed.
+func (s *ScheduleStore) Update(name string, schedule Schedule) error {
+	if schedule.Name != name {
+		return fmt.Errorf("name %q does not match schedule %q, schedule names cannot be changed", schedule.Name, name)
+	}
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.mutableIndex(name)
+	if err != nil {
+		return err
+	}
+	schedules := append([]Schedule(nil), s.stored...)
+	schedules[i] = schedule
+	return s.commit(schedules)
+}
+
+// Delete removes a schedule from the store. Its backups are kept.
+func (s *ScheduleStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.mutableIndex(name)
+	if err != nil {
+		return err
+	}
+	return s.commit(append(append([]Schedule(nil), s.stored[:i]...), s.stored[i+1:]...))
+}
+
+// mutableIndex returns the index of a schedule that may be changed through
+// the API. The caller must hold s.mu.
+func (s *ScheduleStore) mutableIndex(name string) (int, error) {
+	if s.isStatic(name) {
+		return -1, fmt.Errorf("%w: %s", ErrScheduleReadOnly, name)
+	}
+	i := s.indexOf(name)
+	if i < 0 {
+		return -1, fmt.Errorf("%w: %s", ErrScheduleNotFound, name)
+	}
+	return i, nil
+}
+
+// isStatic reports whether a schedule is defined in config.yaml. The caller
+// must hold s.mu.
+func (s *ScheduleStore) isStatic(name string) bool {
+	for _, schedule := range s.static {
+		if schedule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOf returns the index of a stored schedule, or -1. The caller must
+// hold s.mu.
+func (s *ScheduleStore) indexOf(name string) int {
+	for i, schedule := range s.stored {
+		if schedule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// commit writes the schedules to the store file and makes them current.
+// The caller must hold s.mu.
+func (s *ScheduleStore) commit(schedules []Schedule) error {
+	data, err := yaml.Marshal(scheduleFile{Schedules: append([]Schedule{}, schedules...)})
+	if err != nil {
+		return fmt.Errorf("failed to encode schedule store: %w", err)
+	}
+	if err := utils.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write schedule store: %w", err)
+	}
+
+	s.stored = schedules
+	return nil
+}
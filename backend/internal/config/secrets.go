@@ -0,0 +1,251 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Prefixes of configuration values that reference secrets kept elsewhere.
+// Values without a prefix may still interpolate environment variables.
+const (
+	secretFilePrefix      = "file:"
+	secretEncryptedPrefix = "enc:"
+)
+
+// Environment variables holding the master key that decrypts "enc:" values,
+// either directly or as the path of a file holding it
+const (
+	MasterKeyEnv     = "PGM_MASTER_KEY"
+	MasterKeyFileEnv = "PGM_MASTER_KEY_FILE"
+)
+
+// envReference matches ${NAME} references to environment variables
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// secretResolver resolves secret references in configuration values and
+// collects the resolved secrets, so that they can be kept out of logs
+type secretResolver struct {
+	masterKey []byte
+	secrets   []string
+}
+
+// resolveSecrets replaces secret references in the credential fields of the
+// configuration with the values they point to
+func (c *Config) resolveSecrets() error {
+	r := &secretResolver{}
+
+	for i := range c.Servers {
+		if err := r.resolveServer(&c.Servers[i]); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Auth.Tokens {
+		if err := r.resolve(&c.Auth.Tokens[i].Hash, false); err != nil {
+			return fmt.Errorf("API token %q: hash: %w", c.Auth.Tokens[i].Name, err)
+		}
+	}
+	for i := range c.Auth.Users {
+		if err := r.resolve(&c.Auth.Users[i].PasswordHash, false); err != nil {
+			return fmt.Errorf("user %q: password_hash: %w", c.Auth.Users[i].Username, err)
+		}
+	}
+
+	for i := range c.Encryption.Keys {
+		if err := r.resolve(&c.Encryption.Keys[i].Key, true); err != nil {
+			return fmt.Errorf("encryption key %q: %w", c.Encryption.Keys[i].ID, err)
+		}
+	}
+
+	for i := range c.Backups.Storage {
+		storage := &c.Backups.Storage[i]
+		if err := r.resolve(&storage.AccessKeyID, false); err != nil {
+			return fmt.Errorf("storage %q: access_key_id: %w", storage.Name, err)
+		}
+		if err := r.resolve(&storage.SecretAccessKey, true); err != nil {
+			return fmt.Errorf("storage %q: secret_access_key: %w", storage.Name, err)
+		}
+	}
+
+	c.secrets = r.secrets
+	return nil
+}
+
+// resolveServer resolves the credentials of a server and its jump hosts
+func (r *secretResolver) resolveServer(server *Server) error {
+	if err := r.resolveCredentials(fmt.Sprintf("server %q", server.ID), &server.Username, &server.Password, &server.PrivateKey, &server.PrivateKeyPassphrase); err != nil {
+		return err
+	}
+	if err := r.resolve(&server.PostgresUser, false); err != nil {
+		return fmt.Errorf("server %q: postgres_user: %w", server.ID, err)
+	}
+	for j := range server.JumpHosts {
+		hop := &server.JumpHosts[j]
+		if err := r.resolveCredentials(fmt.Sprintf("server %q: jump host %s", server.ID, hop.Host), &hop.Username, &hop.Password, &hop.PrivateKey, &hop.PrivateKeyPassphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveCredentials resolves the SSH credentials of a server or jump host.
+// Private keys are only secret when given inline rather than as a path.
+func (r *secretResolver) resolveCredentials(owner string, username, password, privateKey, passphrase *string) error {
+	if err := r.resolve(username, false); err != nil {
+		return fmt.Errorf("%s: username: %w", owner, err)
+	}
+	if err := r.resolve(password, true); err != nil {
+		return fmt.Errorf("%s: password: %w", owner, err)
+	}
+	if err := r.resolve(privateKey, false); err != nil {
+		return fmt.Errorf("%s: private_key: %w", owner, err)
+	}
+	if strings.Contains(*privateKey, "PRIVATE KEY-----") {
+		r.secrets = append(r.secrets, *privateKey)
+	}
+	if err := r.resolve(passphrase, true); err != nil {
+		return fmt.Errorf("%s: private_key_passphrase: %w", owner, err)
+	}
+	return nil
+}
+
+// resolve replaces a secret reference with the value it points to: the
+// contents of a file for "file:<path>", the decrypted value for
+// "enc:<ciphertext>", and environment variables for ${NAME} anywhere else.
+// Values of secret fields are recorded for redaction.
+func (r *secretResolver) resolve(value *string, secret bool) error {
+	resolved, err := r.resolveValue(*value)
+	if err != nil {
+		return err
+	}
+	*value = resolved
+	if secret && resolved != "" {
+		r.secrets = append(r.secrets, resolved)
+	}
+	return nil
+}
+
+func (r *secretResolver) resolveValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		path, err := interpolateEnv(strings.TrimPrefix(value, secretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		// Secret files usually end in a newline that is not part of the secret
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case strings.HasPrefix(value, secretEncryptedPrefix):
+		if r.masterKey == nil {
+			key, err := LoadMasterKey()
+			if err != nil {
+				return "", err
+			}
+			r.masterKey = key
+		}
+		return DecryptSecret(r.masterKey, value)
+
+	default:
+		return interpolateEnv(value)
+	}
+}
+
+// interpolateEnv replaces ${NAME} references with environment variables.
+// Unset variables are an error rather than silently becoming empty.
+func interpolateEnv(value string) (string, error) {
+	var missing []string
+	resolved := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return env
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// LoadMasterKey reads the base64 encoded 32 byte master key from
+// PGM_MASTER_KEY or from the file named by PGM_MASTER_KEY_FILE
+func LoadMasterKey() ([]byte, error) {
+	encoded := os.Getenv(MasterKeyEnv)
+	if path := os.Getenv(MasterKeyFileEnv); encoded == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("encrypted values require a master key in %s or %s", MasterKeyEnv, MasterKeyFileEnv)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, base64 encoded")
+	}
+	return key, nil
+}
+
+// EncryptSecret encrypts a value with the master key into the "enc:" syntax
+func EncryptSecret(masterKey []byte, plaintext string) (string, error) {
+	aead, err := secretCipher(masterKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretEncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts an "enc:" value with the master key
+func DecryptSecret(masterKey []byte, value string) (string, error) {
+	aead, err := secretCipher(masterKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretEncryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value, wrong master key?")
+	}
+	return string(plaintext), nil
+}
+
+// secretCipher returns the AES-256-GCM cipher of the master key
+func secretCipher(masterKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Secrets returns the credentials of the configuration and of the servers
+// in the server store that must not be logged, as they were resolved
+func (c *Config) Secrets() []string {
+	secrets := append([]string(nil), c.secrets...)
+	if c.store != nil {
+		secrets = append(secrets, c.store.Secrets()...)
+	}
+	return secrets
+}
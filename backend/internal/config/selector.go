@@ -0,0 +1,68 @@
+package config
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// ServerSelector selects servers by their IDs and labels. Empty fields match
+// every server, and a server must carry all of the tags to match.
+type ServerSelector struct {
+	// IDs are glob patterns of server IDs, any of which must match
+	IDs         []string `yaml:"ids,omitempty"`
+	Environment string   `yaml:"environment,omitempty"`
+	Group       string   `yaml:"group,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+}
+
+// IsEmpty reports whether the selector matches every server
+func (s ServerSelector) IsEmpty() bool {
+	return len(s.IDs) == 0 && s.Environment == "" && s.Group == "" && len(s.Tags) == 0
+}
+
+// Matches reports whether a server is selected. Labels are compared case
+// insensitively.
+func (s ServerSelector) Matches(server *Server) bool {
+	if !MatchesAny(s.IDs, server.ID) {
+		return false
+	}
+	if s.Environment != "" && !strings.EqualFold(s.Environment, server.Environment) {
+		return false
+	}
+	if s.Group != "" && !strings.EqualFold(s.Group, server.Group) {
+		return false
+	}
+	for _, tag := range s.Tags {
+		if !slices.ContainsFunc(server.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectServers returns the servers matched by a selector, those of
+// config.yaml first
+func (c *Config) SelectServers(selector ServerSelector) []Server {
+	var selected []Server
+	for _, server := range c.GetServers() {
+		if selector.Matches(&server) {
+			selected = append(selected, server)
+		}
+	}
+	return selected
+}
+
+// MatchesAny reports whether value matches one of the path.Match glob
+// patterns. An empty pattern list matches everything.
+func MatchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
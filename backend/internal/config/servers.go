@@ -0,0 +1,387 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"backend/internal/utils"
+)
+
+var (
+	// ErrServerNotFound is returned for unknown server IDs
+	ErrServerNotFound = errors.New("server not found")
+	// ErrServerExists is returned when adding a server with an ID in use
+	ErrServerExists = errors.New("server already exists")
+	// ErrServerReadOnly is returned when changing a server of config.yaml
+	ErrServerReadOnly = errors.New("server is defined in config.yaml and cannot be changed through the API")
+)
+
+// serverIDPattern restricts server IDs and labels to what is safe in URLs
+// and file names
+var serverIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Validate checks that a server is complete enough to be connected to
+func (s *Server) Validate() error {
+	if !serverIDPattern.MatchString(s.ID) {
+		return fmt.Errorf("id %q must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", s.ID)
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", s.Port)
+	}
+	for _, label := range append([]string{s.Environment, s.Group}, s.Tags...) {
+		if label != "" && !serverIDPattern.MatchString(label) {
+			return fmt.Errorf("environment, group and tags may only contain letters, digits, '.', '_' and '-', got %q", label)
+		}
+	}
+	for i, hop := range s.JumpHosts {
+		if hop.Host == "" {
+			return fmt.Errorf("jump host %d has no host", i+1)
+		}
+		if hop.Port < 0 || hop.Port > 65535 {
+			return fmt.Errorf("jump host %s: port %d is out of range", hop.Host, hop.Port)
+		}
+	}
+	return nil
+}
+
+// CheckSubmitted checks a server submitted by a caller who may not use what
+// the backend itself can read: secret references resolved from its
+// environment, files and master key, key and known_hosts files on its disk,
+// and its SSH agent. Such servers must carry their credentials inline, and
+// cannot be local, as commands for local servers run on the backend itself.
+func (s *Server) CheckSubmitted() error {
+	if s.IsLocal() {
+		return fmt.Errorf("server %q: host %q is the backend itself", s.ID, s.Host)
+	}
+	if s.ForwardAgent {
+		return fmt.Errorf("server %q: forward_agent lends the SSH agent of the backend", s.ID)
+	}
+	if err := checkSubmittedCredentials(fmt.Sprintf("server %q", s.ID), s.Username, s.Password, s.PrivateKey, s.PrivateKeyPassphrase, s.KnownHostsFile); err != nil {
+		return err
+	}
+	if isSecretReference(s.PostgresUser) {
+		return fmt.Errorf("server %q: postgres_user refers to a secret of the backend", s.ID)
+	}
+	for _, hop := range s.JumpHosts {
+		if err := checkSubmittedCredentials(fmt.Sprintf("server %q: jump host %s", s.ID, hop.Host), hop.Username, hop.Password, hop.PrivateKey, hop.PrivateKeyPassphrase, hop.KnownHostsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSubmittedCredentials checks the SSH credentials of a submitted server
+// or jump host
+func checkSubmittedCredentials(owner, username, password, privateKey, passphrase, knownHostsFile string) error {
+	for field, value := range map[string]string{
+		"username":               username,
+		"password":               password,
+		"private_key":            privateKey,
+		"private_key_passphrase": passphrase,
+	} {
+		if isSecretReference(value) {
+			return fmt.Errorf("%s: %s refers to a secret of the backend", owner, field)
+		}
+	}
+	if privateKey != "" && !strings.Contains(privateKey, "PRIVATE KEY-----") {
+		return fmt.Errorf("%s: private_key must be given inline, not as a path", owner)
+	}
+	if knownHostsFile != "" {
+		return fmt.Errorf("%s: known_hosts_file is a path on the backend", owner)
+	}
+	return nil
+}
+
+// isSecretReference reports whether resolving a value would read from the
+// environment, a file or the master key of the backend
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretFilePrefix) || strings.HasPrefix(value, secretEncryptedPrefix) || strings.Contains(value, "${")
+}
+
+// serverFile is the layout of the server store file, the same as the
+// servers section of config.yaml
+type serverFile struct {
+	Servers []Server `yaml:"servers"`
+}
+
+// storedServer is a server of the store, as written to the file and with
+// its secret references resolved
+type storedServer struct {
+	raw      Server
+	resolved Server
+	secrets  []string
+}
+
+// ServerStore is the inventory of servers. Servers of config.yaml are read
+// only, servers added through the API are kept in a YAML file with their
+// secret references unresolved. The file is rewritten atomically on every
+// change, so that a crash never leaves it half written.
+type ServerStore struct {
+	path string
+
+	mu       sync.RWMutex
+	static   []Server
+	stored   []storedServer
+	onChange []func()
+}
+
+// openServerStore loads the servers of the store file next to the servers
+// of config.yaml. A missing file is an empty store.
+func openServerStore(path string, static []Server) (*ServerStore, error) {
+	s := &ServerStore{path: path, static: static}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server store: %w", err)
+	}
+
+	var file serverFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal server store: %w", err)
+	}
+	for _, server := range file.Servers {
+		if s.indexOf(server.ID) >= 0 || s.isStatic(server.ID) {
+			return nil, fmt.Errorf("server %q: %w", server.ID, ErrServerExists)
+		}
+		stored, err := resolveStoredServer(server)
+		if err != nil {
+			return nil, err
+		}
+		s.stored = append(s.stored, stored)
+	}
+	return s, nil
+}
+
+// setStatic replaces the servers of config.yaml. Stored servers keep their
+// IDs, so config.yaml may not define them as well.
+func (s *ServerStore) setStatic(static []Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, server := range static {
+		if s.indexOf(server.ID) >= 0 {
+			return fmt.Errorf("server %q is also in %s: %w", server.ID, s.path, ErrServerExists)
+		}
+	}
+	s.static = static
+	return nil
+}
+
+// List returns all servers, those of config.yaml first
+func (s *ServerStore) List() []Server {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	servers := append([]Server(nil), s.static...)
+	for _, stored := range s.stored {
+		servers = append(servers, stored.resolved)
+	}
+	return servers
+}
+
+// Get returns a server by its ID
+func (s *ServerStore) Get(id string) (*Server, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, server := range s.static {
+		if server.ID == id {
+			return &server, nil
+		}
+	}
+	if i := s.indexOf(id); i >= 0 {
+		server := s.stored[i].resolved
+		return &server, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrServerNotFound, id)
+}
+
+// ReadOnly reports whether a server is defined in config.yaml
+func (s *ServerStore) ReadOnly(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isStatic(id)
+}
+
+// Resolve validates a server and resolves its secret references, returning
+// the server as it will be connected to
+func (s *ServerStore) Resolve(server Server) (*Server, error) {
+	if err := server.Validate(); err != nil {
+		return nil, err
+	}
+	stored, err := resolveStoredServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return &stored.resolved, nil
+}
+
+// Create adds a server to the store
+func (s *ServerStore) Create(server Server) error {
+	if err := server.Validate(); err != nil {
+		return err
+	}
+	stored, err := resolveStoredServer(server)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.isStatic(server.ID) || s.indexOf(server.ID) >= 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrServerExists, server.ID)
+	}
+	err = s.commit(append(append([]storedServer(nil), s.stored...), stored))
+	s.mu.Unlock()
+
+	if err == nil {
+		s.notify()
+	}
+	return err
+}
+
+// Update replaces a server of the store. Its ID cannot be changed.
+func (s *ServerStore) Update(id string, server Server) error {
+	if server.ID != id {
+		return fmt.Errorf("id %q does not match server %q, server IDs cannot be changed", server.ID, id)
+	}
+	if err := server.Validate(); err != nil {
+		return err
+	}
+	stored, err := resolveStoredServer(server)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	i, err := s.mutableIndex(id)
+	if err == nil {
+		servers := append([]storedServer(nil), s.stored...)
+		servers[i] = stored
+		err = s.commit(servers)
+	}
+	s.mu.Unlock()
+
+	if err == nil {
+		s.notify()
+	}
+	return err
+}
+
+// Delete removes a server from the store
+func (s *ServerStore) Delete(id string) error {
+	s.mu.Lock()
+	i, err := s.mutableIndex(id)
+	if err == nil {
+		servers := append(append([]storedServer(nil), s.stored[:i]...), s.stored[i+1:]...)
+		err = s.commit(servers)
+	}
+	s.mu.Unlock()
+
+	if err == nil {
+		s.notify()
+	}
+	return err
+}
+
+// Secrets returns the resolved credentials of the stored servers
+func (s *ServerStore) Secrets() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var secrets []string
+	for _, stored := range s.stored {
+		secrets = append(secrets, stored.secrets...)
+	}
+	return secrets
+}
+
+// OnChange registers a function called after every change to the store
+func (s *ServerStore) OnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *ServerStore) notify() {
+	s.mu.RLock()
+	callbacks := append([]func(){}, s.onChange...)
+	s.mu.RUnlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// mutableIndex returns the index of a server that may be changed through
+// the API. The caller must hold s.mu.
+func (s *ServerStore) mutableIndex(id string) (int, error) {
+	if s.isStatic(id) {
+		return -1, fmt.Errorf("%w: %s", ErrServerReadOnly, id)
+	}
+	i := s.indexOf(id)
+	if i < 0 {
+		return -1, fmt.Errorf("%w: %s", ErrServerNotFound, id)
+	}
+	return i, nil
+}
+
+// isStatic reports whether a server is defined in config.yaml. The caller
+// must hold s.mu.
+func (s *ServerStore) isStatic(id string) bool {
+	for _, server := range s.static {
+		if server.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOf returns the index of a stored server, or -1. The caller must hold s.mu.
+func (s *ServerStore) indexOf(id string) int {
+	for i, stored := range s.stored {
+		if stored.raw.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// commit writes the servers to the store file and makes them current. The
+// caller must hold s.mu.
+func (s *ServerStore) commit(servers []storedServer) error {
+	file := serverFile{Servers: []Server{}}
+	for _, stored := range servers {
+		file.Servers = append(file.Servers, stored.raw)
+	}
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to encode server store: %w", err)
+	}
+	if err := utils.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write server store: %w", err)
+	}
+
+	s.stored = servers
+	return nil
+}
+
+// resolveStoredServer resolves the secret references of a stored server
+func resolveStoredServer(server Server) (storedServer, error) {
+	r := &secretResolver{}
+	resolved := server
+	resolved.JumpHosts = append([]JumpHost(nil), server.JumpHosts...)
+	if err := r.resolveServer(&resolved); err != nil {
+		return storedServer{}, err
+	}
+	return storedServer{raw: server, resolved: resolved, secrets: r.secrets}, nil
+}
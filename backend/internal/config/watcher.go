@@ -0,0 +1,175 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 2 * time.Second
+
+// Watcher holds the current configuration and reloads it when the config
+// file changes or the process receives SIGHUP. Requests take the current
+// configuration once, so a reload never changes the server of a dump that is
+// already running.
+type Watcher struct {
+	path   string
+	logger *logrus.Logger
+
+	current atomic.Pointer[Config]
+
+	// mu serializes reloads
+	mu       sync.Mutex
+	checksum [sha256.Size]byte
+	onReload []func(*Config) error
+}
+
+// NewWatcher creates a watcher of the config file cfg was loaded from
+func NewWatcher(path string, cfg *Config, logger *logrus.Logger) *Watcher {
+	w := &Watcher{path: path, logger: logger}
+	w.current.Store(cfg)
+	if data, err := os.ReadFile(path); err == nil {
+		w.checksum = sha256.Sum256(data)
+	}
+	return w
+}
+
+// Current returns the current configuration
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// OnReload registers a function applying a reloaded configuration to a part
+// of the backend. An error rejects the configuration, and the functions
+// that already applied it are called again with the previous one.
+func (w *Watcher) OnReload(fn func(*Config) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onReload = append(w.onReload, fn)
+}
+
+// Watch reloads the configuration on changes to the config file and on
+// SIGHUP until ctx is cancelled
+func (w *Watcher) Watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			w.logger.Infof("Received SIGHUP, reloading %s", w.path)
+			w.reload(true)
+		case <-ticker.C:
+			w.reload(false)
+		}
+	}
+}
+
+// Reload loads the config file again and makes it current. On error the
+// previous configuration stays current.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	// A rejected file is not retried until it changes again
+	w.checksum = sha256.Sum256(data)
+
+	previous := w.Current()
+	next, err := reloadConfig(w.path, previous)
+	if err != nil {
+		return err
+	}
+
+	for i, apply := range w.onReload {
+		if err := apply(next); err != nil {
+			w.rollback(previous, i)
+			return err
+		}
+	}
+	w.current.Store(next)
+
+	w.warnRestartRequired(previous, next)
+	return nil
+}
+
+// reload reloads the configuration and logs the outcome. Unless forced, the
+// file is only reloaded if its contents changed.
+func (w *Watcher) reload(force bool) {
+	if !force {
+		data, err := os.ReadFile(w.path)
+		if err != nil {
+			// Editors may replace the file rather than write it in place,
+			// it shows up again on the next poll
+			return
+		}
+		w.mu.Lock()
+		unchanged := sha256.Sum256(data) == w.checksum
+		w.mu.Unlock()
+		if unchanged {
+			return
+		}
+	}
+
+	if err := w.Reload(); err != nil {
+		w.logger.Errorf("Rejected configuration from %s, keeping the previous one: %v", w.path, err)
+		return
+	}
+	w.logger.Infof("Reloaded configuration from %s", w.path)
+}
+
+// rollback applies the previous configuration again after the reload
+// function at index failed. The caller must hold w.mu.
+func (w *Watcher) rollback(previous *Config, failed int) {
+	if err := previous.store.setStatic(previous.Servers); err != nil {
+		w.logger.Errorf("Failed to restore servers of the previous configuration: %v", err)
+	}
+	previous.schedules.setStatic(previous.Backups.Schedules)
+	for _, apply := range w.onReload[:failed] {
+		if err := apply(previous); err != nil {
+			w.logger.Errorf("Failed to restore the previous configuration: %v", err)
+		}
+	}
+}
+
+// warnRestartRequired logs the changed sections that are only read on startup
+func (w *Watcher) warnRestartRequired(previous, next *Config) {
+	for _, section := range []struct {
+		name      string
+		old, next any
+	}{
+		{"http", previous.HTTP, next.HTTP},
+		{"docker", previous.Docker, next.Docker},
+		{"jobs", previous.Jobs, next.Jobs},
+		{"ssh", previous.SSH, next.SSH},
+		{"audit", previous.Audit, next.Audit},
+		{"inventory", previous.Inventory, next.Inventory},
+		{"backups.dir", previous.Backups.Dir, next.Backups.Dir},
+		{"backups.storage", previous.Backups.Storage, next.Backups.Storage},
+		{"backups.catalog_file", previous.Backups.CatalogFile, next.Backups.CatalogFile},
+		{"backups.schedules_file", previous.Backups.SchedulesFile, next.Backups.SchedulesFile},
+	} {
+		if !reflect.DeepEqual(section.old, section.next) {
+			w.logger.Warnf("Changes to %s in %s take effect after a restart", section.name, w.path)
+		}
+	}
+}
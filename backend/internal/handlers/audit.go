@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// maxAuditLimit caps the number of audit entries returned by one request
+const maxAuditLimit = 1000
+
+// GetAuditLog returns audit log entries, newest first, filtered by the
+// actor, action, server_id, container, database, outcome, since, until and
+// limit query parameters. Callers only see entries of servers they are
+// admins of.
+func (h *Handler) GetAuditLog(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid audit filter",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	principal := principalOf(c)
+	entries, err := h.auditLog.Query(filter, func(entry models.AuditEntry) bool {
+		return h.authService.Authorize(principal, services.PermissionAdmin, services.Resource{ServerID: entry.ServerID})
+	})
+	if err != nil {
+		h.logger.Errorf("Failed to read audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to read audit log",
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	// Ensure we return an empty array, not null
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	response := gin.H{
+		"entries":  entries,
+		"total":    len(entries),
+		"verified": true,
+	}
+	if err := h.auditLog.Verify(); err != nil {
+		h.logger.Errorf("Audit log failed verification: %v", err)
+		response["verified"] = false
+		response["verification_error"] = err.Error()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseAuditFilter parses audit log filters from the query string
+func parseAuditFilter(c *gin.Context) (services.AuditFilter, error) {
+	filter := services.AuditFilter{
+		Actor:     c.Query("actor"),
+		Action:    c.Query("action"),
+		ServerID:  c.Query("server_id"),
+		Container: c.Query("container"),
+		Database:  c.Query("database"),
+		Outcome:   c.Query("outcome"),
+		Limit:     100,
+	}
+
+	for key, target := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if value := c.Query(key); value != "" {
+			val, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s value %q, expected an RFC 3339 time", key, value)
+			}
+			*target = val
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		val, err := strconv.Atoi(limit)
+		if err != nil || val <= 0 || val > maxAuditLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		filter.Limit = val
+	}
+
+	return filter, nil
+}
+
+// beginAudit starts the audit log entry of a request. The handler fills in
+// what it acts on as it learns it and finishAudit, deferred right after,
+// records the entry once the response is written.
+func (h *Handler) beginAudit(c *gin.Context, action, serverID string) *models.AuditEntry {
+	return &models.AuditEntry{
+		Time:     time.Now(),
+		Actor:    principalOf(c).ID(),
+		SourceIP: c.ClientIP(),
+		Action:   action,
+		ServerID: serverID,
+	}
+}
+
+// finishAudit records an audit log entry, deriving the outcome from the
+// response status unless the handler recorded an error of its own
+func (h *Handler) finishAudit(c *gin.Context, entry *models.AuditEntry) {
+	entry.Duration = time.Since(entry.Time).Round(time.Millisecond).String()
+	entry.Status = c.Writer.Status()
+
+	switch {
+	case entry.Status == http.StatusForbidden:
+		entry.Outcome = services.AuditOutcomeDenied
+	case entry.Status >= http.StatusBadRequest:
+		entry.Outcome = services.AuditOutcomeFailure
+		if entry.Error == "" {
+			entry.Error = http.StatusText(entry.Status)
+		}
+	case entry.Error != "":
+		entry.Outcome = services.AuditOutcomeFailure
+	default:
+		entry.Outcome = services.AuditOutcomeSuccess
+	}
+
+	if err := h.auditLog.Record(*entry); err != nil {
+		h.logger.Errorf("Failed to write audit log entry for %s by %s: %v", entry.Action, entry.Actor, err)
+	}
+}
+
+// auditOptions encodes request options for an audit log entry
+func auditOptions(options any) json.RawMessage {
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// countingBody counts the bytes read from an upload
+type countingBody struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingBody) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
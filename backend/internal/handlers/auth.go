@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// principalKey is the context key of the authenticated caller
+const principalKey = "principal"
+
+// RequireAuth is the middleware rejecting API requests without valid credentials
+func (h *Handler) RequireAuth(c *gin.Context) {
+	principal, err := h.authService.Authenticate(c.Request)
+	if err != nil {
+		message := "Send an API token as bearer token or log in first"
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			message = "The API token or session is invalid or has expired"
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: message,
+			Code:    http.StatusUnauthorized,
+		})
+		return
+	}
+
+	c.Set(principalKey, principal)
+	c.Next()
+}
+
+// Login logs in a local user and sets the session cookie
+func (h *Handler) Login(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid login request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	sessions := h.authService.Sessions()
+	id, principal, err := sessions.Login(req.Username, req.Password, c.ClientIP())
+	if err != nil {
+		status := http.StatusInternalServerError
+		var throttled *services.LoginThrottledError
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			status = http.StatusUnauthorized
+		case errors.As(err, &throttled):
+			status = http.StatusTooManyRequests
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Login failed",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return
+	}
+
+	http.SetCookie(c.Writer, sessions.Cookie(id))
+	c.JSON(http.StatusOK, principalResponse(principal))
+}
+
+// Logout ends the session of the caller and clears the session cookie
+func (h *Handler) Logout(c *gin.Context) {
+	sessions := h.authService.Sessions()
+	sessions.Logout(c.Request)
+	http.SetCookie(c.Writer, sessions.Cookie(""))
+	c.Status(http.StatusNoContent)
+}
+
+// GetCurrentUser returns the authenticated caller
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, principalResponse(principalOf(c)))
+}
+
+// authorize checks that the caller has a permission on a resource, writing
+// an error response and returning false if not
+func (h *Handler) authorize(c *gin.Context, permission string, resource services.Resource) bool {
+	principal := principalOf(c)
+	if h.authService.Authorize(principal, permission, resource) {
+		return true
+	}
+
+	h.logger.Warnf("Denied %s permission on %s to %s", permission, resourceName(resource), principal.ID())
+	c.JSON(http.StatusForbidden, models.ErrorResponse{
+		Error:   "Forbidden",
+		Message: fmt.Sprintf("%s permission required on %s", permission, resourceName(resource)),
+		Code:    http.StatusForbidden,
+	})
+	return false
+}
+
+// authorizeEverywhere checks that the caller has a permission on every
+// server, container and database, writing the error response if not
+func (h *Handler) authorizeEverywhere(c *gin.Context, permission string) bool {
+	principal := principalOf(c)
+	if h.authService.AuthorizeEverywhere(principal, permission) {
+		return true
+	}
+
+	h.logger.Warnf("Denied %s permission on all servers to %s", permission, principal.ID())
+	c.JSON(http.StatusForbidden, models.ErrorResponse{
+		Error:   "Forbidden",
+		Message: fmt.Sprintf("%s permission required on all servers", permission),
+		Code:    http.StatusForbidden,
+	})
+	return false
+}
+
+// authorizeDump checks that the caller may take a dump with the given
+// options. Callers that only hold schema_only may take schema-only dumps.
+func (h *Handler) authorizeDump(c *gin.Context, resource services.Resource, options models.DumpOptions) bool {
+	principal := principalOf(c)
+	if h.authService.Authorize(principal, services.PermissionDump, resource) {
+		return true
+	}
+	if options.SchemaOnly {
+		return h.authorize(c, services.PermissionSchemaOnly, resource)
+	}
+
+	if h.authService.Authorize(principal, services.PermissionSchemaOnly, resource) {
+		h.logger.Warnf("Denied full dump of %s to %s", resourceName(resource), principal.ID())
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: fmt.Sprintf("only schema-only dumps are permitted on %s", resourceName(resource)),
+			Code:    http.StatusForbidden,
+		})
+		return false
+	}
+	return h.authorize(c, services.PermissionDump, resource)
+}
+
+// canList reports whether the caller may see a resource, for filtering listings
+func (h *Handler) canList(c *gin.Context, resource services.Resource) bool {
+	return h.authService.Authorize(principalOf(c), services.PermissionList, resource)
+}
+
+// resourceName describes a resource for log and error messages
+func resourceName(resource services.Resource) string {
+	name := "server " + resource.ServerID
+	if resource.Container != "" {
+		name += ", container " + resource.Container
+	}
+	if resource.Database != "" {
+		name += ", database " + resource.Database
+	}
+	return name
+}
+
+// principalOf returns the caller authenticated by RequireAuth
+func principalOf(c *gin.Context) *services.Principal {
+	if principal, exists := c.Get(principalKey); exists {
+		return principal.(*services.Principal)
+	}
+	return &services.Principal{Name: "anonymous", Method: services.AuthMethodNone}
+}
+
+// principalResponse returns the API representation of a caller
+func principalResponse(principal *services.Principal) models.PrincipalResponse {
+	resp := models.PrincipalResponse{
+		Name:   principal.Name,
+		Method: principal.Method,
+		Roles:  principal.Roles,
+	}
+	if resp.Roles == nil {
+		resp.Roles = []string{}
+	}
+	if !principal.ExpiresAt.IsZero() {
+		expiresAt := principal.ExpiresAt
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
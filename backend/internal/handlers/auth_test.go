@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// testAuthHandler returns a handler authenticating the token "ci-token"
+// with the role viewer and the user alice with the password "secret" and
+// the role app-admin, an admin of the container app on every server
+func testAuthHandler(t *testing.T) *Handler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tokenHash := sha256.Sum256([]byte("ci-token"))
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	authService, err := services.NewAuthService(config.Auth{
+		SessionTTL: time.Hour,
+		Tokens:     []config.APIToken{{Name: "ci", Hash: hex.EncodeToString(tokenHash[:]), Roles: []string{"viewer"}}},
+		Users:      []config.User{{Username: "alice", PasswordHash: string(passwordHash), Roles: []string{"app-admin"}}},
+		Roles: []config.Role{
+			{Name: "viewer", Permissions: []string{services.PermissionList}},
+			{Name: "app-admin", Permissions: []string{services.PermissionAdmin}, Containers: []string{"app"}},
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	return &Handler{authService: authService, logger: logger}
+}
+
+// testAuthRouter registers the routes of main.go needed to log in and to
+// check who is logged in, and a route only admins of all servers may use
+func testAuthRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	api := r.Group("/api/v1")
+	api.POST("/auth/login", h.Login)
+	api.Use(h.RequireAuth)
+	api.GET("/auth/me", h.GetCurrentUser)
+	api.GET("/everywhere", func(c *gin.Context) {
+		if h.authorizeEverywhere(c, services.PermissionAdmin) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+	return r
+}
+
+func login(r *gin.Engine, username, password string) *httptest.ResponseRecorder {
+	body := `{"username":"` + username + `","password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "192.0.2.1:41000"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireAuth(t *testing.T) {
+	r := testAuthRouter(testAuthHandler(t))
+
+	w := login(r, "alice", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var session *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == services.SessionCookieName {
+			session = cookie
+		}
+	}
+	require.NotNil(t, session)
+
+	for _, tc := range []struct {
+		name      string
+		header    string
+		cookie    *http.Cookie
+		status    int
+		principal string
+		message   string
+	}{
+		{"no credentials", "", nil, http.StatusUnauthorized, "", "Send an API token"},
+		{"invalid token", "Bearer nope", nil, http.StatusUnauthorized, "", "invalid or has expired"},
+		{"invalid session", "", &http.Cookie{Name: services.SessionCookieName, Value: "nope"}, http.StatusUnauthorized, "", "invalid or has expired"},
+		{"token", "Bearer ci-token", nil, http.StatusOK, "ci", ""},
+		{"session", "", session, http.StatusOK, "alice", ""},
+		{"token before session", "Bearer ci-token", session, http.StatusOK, "ci", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/me", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tc.status, w.Code)
+			if tc.status != http.StatusOK {
+				var resp models.ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Contains(t, resp.Message, tc.message)
+				return
+			}
+			var resp models.PrincipalResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tc.principal, resp.Name)
+		})
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	r := testAuthRouter(testAuthHandler(t))
+
+	status := http.StatusUnauthorized
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 10 && status == http.StatusUnauthorized; i++ {
+		w = login(r, "alice", "wrong")
+		status = w.Code
+	}
+	require.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The right password does not get past the lock either
+	assert.Equal(t, http.StatusTooManyRequests, login(r, "alice", "secret").Code)
+}
+
+func TestAuthorizeEverywhere(t *testing.T) {
+	r := testAuthRouter(testAuthHandler(t))
+
+	// alice is an admin of the container app on every server, which is not
+	// enough for actions on all servers
+	w := login(r, "alice", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/everywhere", nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "admin permission required on all servers")
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// maxBackupLimit caps the number of backups returned by one request
+const maxBackupLimit = 1000
+
+// ListBackups returns the backups of the catalog, newest first, filtered by
+// the server_id, container, database, storage, owner, format, since, until
+// and limit query parameters. Callers only see backups of databases they
+// may list.
+func (h *Handler) ListBackups(c *gin.Context) {
+	filter, err := parseBackupFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid backup filter",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	backups := h.catalog.Query(filter, func(backup models.Backup) bool {
+		return h.canList(c, backupResource(backup))
+	})
+
+	// Ensure we return an empty array, not null
+	if backups == nil {
+		backups = []models.Backup{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"backups": backups,
+		"total":   len(backups),
+	})
+}
+
+// DownloadBackup streams a backup of the catalog from its storage. It needs
+// the same permission as taking the dump did.
+func (h *Handler) DownloadBackup(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionDownloadBackup, "")
+	defer h.finishAudit(c, audit)
+
+	backup, ok := h.lookupBackup(c)
+	if !ok {
+		return
+	}
+
+	audit.ServerID = backup.ServerID
+	audit.Container = backup.ContainerName
+	audit.Database = backup.Database
+	audit.Options = auditOptions(gin.H{"backup": backup.ID, "storage": backup.Storage, "key": backup.Key})
+
+	if !h.authorizeDump(c, backupResource(backup), backup.Options) {
+		return
+	}
+
+	storage, err := h.storageService.Get(backup.Storage)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Backup not available",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	// The length lets clients notice a download cut short because the
+	// stored file does not match its checksum
+	size := int64(-1)
+	if backup.Size > 0 {
+		size = backup.Size
+	}
+	c.Header("X-Backup-SHA256", backup.SHA256)
+	audit.Bytes, err = h.sendStoredObject(c, storage, backup.Key, path.Base(backup.Key), size, backup.SHA256)
+	if err != nil {
+		audit.Error = err.Error()
+	}
+}
+
+// VerifyBackup starts a restore verification of a backup in a throwaway
+// container on the server of the backup or the server_id of the request.
+// Backups encrypted with a passphrase or an identity that is not configured
+// need the X-Dump-Passphrase or X-Dump-Identity header.
+func (h *Handler) VerifyBackup(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionVerifyBackup, "")
+	defer h.finishAudit(c, audit)
+
+	backup, ok := h.lookupBackup(c)
+	if !ok {
+		return
+	}
+	audit.ServerID = backup.ServerID
+	audit.Container = backup.ContainerName
+	audit.Database = backup.Database
+
+	var req models.VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid verification request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	if req.ServerID == "" {
+		req.ServerID = backup.ServerID
+	}
+	audit.Options = auditOptions(gin.H{"backup": backup.ID, "verify_server_id": req.ServerID})
+
+	server, err := h.config().GetServerByID(req.ServerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	// Verifying reads the backup and starts a container on the server
+	if !h.authorizeDump(c, backupResource(backup), backup.Options) ||
+		!h.authorize(c, services.PermissionRestore, services.Resource{ServerID: server.ID}) {
+		return
+	}
+
+	secrets := services.DecryptionSecrets{
+		Passphrase:  c.GetHeader("X-Dump-Passphrase"),
+		AgeIdentity: c.GetHeader("X-Dump-Identity"),
+	}
+	verification, err := h.verificationService.Start(backup, server, req, secrets, principalOf(c).ID())
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrVerificationRunning) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Failed to start verification",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, verification)
+}
+
+// GetBackupVerification returns the verification of a backup in progress,
+// or the last finished one
+func (h *Handler) GetBackupVerification(c *gin.Context) {
+	backup, ok := h.lookupBackup(c)
+	if !ok {
+		return
+	}
+
+	if verification, running := h.verificationService.Current(backup.ID); running {
+		c.JSON(http.StatusOK, verification)
+		return
+	}
+	if backup.Verification == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Verification not found",
+			Message: "backup has not been verified",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, backup.Verification)
+}
+
+// lookupBackup finds the backup of the request among those the caller may
+// see, writing a 404 response if there is none
+func (h *Handler) lookupBackup(c *gin.Context) (models.Backup, bool) {
+	backup, err := h.catalog.Get(c.Param("backupID"))
+	if err == nil && !h.canList(c, backupResource(backup)) {
+		err = services.ErrBackupNotFound
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Backup not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return models.Backup{}, false
+	}
+	return backup, true
+}
+
+// sendStoredObject streams an object of a storage as a file attachment and
+// returns the number of bytes sent. size is -1 if unknown. A non-empty
+// checksum is verified on the way, and an object not matching it is cut
+// short. The error is of the stream, after the response was started.
+func (h *Handler) sendStoredObject(c *gin.Context, storage services.Storage, key, filename string, size int64, checksum string) (int64, error) {
+	reader, err := storage.Open(c.Request.Context(), key)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrObjectNotFound) {
+			status = http.StatusNotFound
+		} else {
+			h.logger.Errorf("Failed to open %s in %s: %v", key, storage.Name(), err)
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Artifact not available",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return 0, nil
+	}
+	defer reader.Close()
+
+	var body io.Reader = reader
+	if checksum != "" {
+		body = services.NewChecksumReader(reader, checksum)
+	}
+	errorCount := len(c.Errors)
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", body, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%s", filename),
+	})
+	if len(c.Errors) > errorCount {
+		err := c.Errors.Last().Err
+		h.logger.Errorf("Failed to send %s from %s: %v", key, storage.Name(), err)
+		return int64(c.Writer.Size()), err
+	}
+	return int64(c.Writer.Size()), nil
+}
+
+// backupResource returns the resource a backup was taken of
+func backupResource(backup models.Backup) services.Resource {
+	return services.Resource{ServerID: backup.ServerID, Container: backup.ContainerName, Database: backup.Database}
+}
+
+// parseBackupFilter parses backup filters from the query string
+func parseBackupFilter(c *gin.Context) (services.BackupFilter, error) {
+	filter := services.BackupFilter{
+		ServerID:  c.Query("server_id"),
+		Container: c.Query("container"),
+		Database:  c.Query("database"),
+		Storage:   c.Query("storage"),
+		Owner:     c.Query("owner"),
+		Format:    c.Query("format"),
+		Limit:     100,
+	}
+
+	for key, target := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if value := c.Query(key); value != "" {
+			val, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s value %q, expected an RFC 3339 time", key, value)
+			}
+			*target = val
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		val, err := strconv.Atoi(limit)
+		if err != nil || val <= 0 || val > maxBackupLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxBackupLimit)
+		}
+		filter.Limit = val
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// maxDiscoveryConcurrency caps the servers discovered at the same time
+const maxDiscoveryConcurrency = 8
+
+// DiscoverServers lists the PostgreSQL containers and their databases on
+// every server selected by the env, group and tag query parameters. Servers
+// are discovered concurrently, and a server that fails is reported with its
+// error rather than failing the request.
+func (h *Handler) DiscoverServers(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionDiscover, "")
+	selector := serverSelector(c)
+	audit.Options = auditOptions(selector)
+	defer h.finishAudit(c, audit)
+
+	var servers []config.Server
+	for _, server := range h.config().SelectServers(selector) {
+		if h.canList(c, services.Resource{ServerID: server.ID}) {
+			servers = append(servers, server)
+		}
+	}
+
+	results := make([]models.ServerDiscovery, len(servers))
+	slots := make(chan struct{}, maxDiscoveryConcurrency)
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = h.discoverServer(c, &servers[i])
+		}(i)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{
+		"servers": results,
+		"total":   len(results),
+	})
+}
+
+// discoverServer lists the containers and databases of one server the
+// caller may see
+func (h *Handler) discoverServer(c *gin.Context, server *config.Server) models.ServerDiscovery {
+	result := models.ServerDiscovery{ServerID: server.ID, Containers: []models.ContainerDiscovery{}}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	found, err := h.dockerService.GetPostgreSQLContainers(ctx, server, h.sshService)
+	if err != nil {
+		h.logger.Errorf("Failed to discover containers on %s: %v", server.ID, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, container := range found {
+		if !h.canList(c, services.Resource{ServerID: server.ID, Container: container.Name}) {
+			continue
+		}
+
+		discovery := models.ContainerDiscovery{Container: container, Databases: []models.DatabaseResponse{}}
+		databases, err := h.postgresService.GetDatabasesViaSSH(ctx, server, container.ID, h.sshService)
+		if err != nil {
+			h.logger.Errorf("Failed to discover databases in %s on %s: %v", container.Name, server.ID, err)
+			discovery.Error = err.Error()
+		} else {
+			discovery.Databases = h.visibleDatabases(c, server.ID, container.Name, databases)
+		}
+		result.Containers = append(result.Containers, discovery)
+	}
+
+	h.logger.Infof("Discovered %d containers on server %s", len(result.Containers), server.ID)
+	return result
+}
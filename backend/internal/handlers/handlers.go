@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,45 +22,74 @@ import (
 
 // Handler contains all HTTP handlers
 type Handler struct {
-	config          *config.Config
-	dockerService   *services.DockerService
-	sshService      *services.SSHService
-	postgresService *services.PostgresService
-	logger          *logrus.Logger
+	configWatcher       *config.Watcher
+	dockerService       *services.DockerService
+	sshService          *services.SSHService
+	postgresService     *services.PostgresService
+	jobService          *services.JobService
+	scheduler           *services.Scheduler
+	storageService      *services.StorageService
+	catalog             *services.BackupCatalog
+	verificationService *services.VerificationService
+	encryptionService   *services.EncryptionService
+	authService         *services.AuthService
+	auditLog            *services.AuditLog
+	logger              *logrus.Logger
 }
 
 // NewHandler creates a new handler instance
 func NewHandler(
-	cfg *config.Config,
+	configWatcher *config.Watcher,
 	dockerService *services.DockerService,
 	sshService *services.SSHService,
 	postgresService *services.PostgresService,
+	jobService *services.JobService,
+	scheduler *services.Scheduler,
+	storageService *services.StorageService,
+	catalog *services.BackupCatalog,
+	verificationService *services.VerificationService,
+	encryptionService *services.EncryptionService,
+	authService *services.AuthService,
+	auditLog *services.AuditLog,
 	logger *logrus.Logger,
 ) *Handler {
 	return &Handler{
-		config:          cfg,
-		dockerService:   dockerService,
-		sshService:      sshService,
-		postgresService: postgresService,
-		logger:          logger,
+		configWatcher:       configWatcher,
+		dockerService:       dockerService,
+		sshService:          sshService,
+		postgresService:     postgresService,
+		jobService:          jobService,
+		scheduler:           scheduler,
+		storageService:      storageService,
+		catalog:             catalog,
+		verificationService: verificationService,
+		encryptionService:   encryptionService,
+		authService:         authService,
+		auditLog:            auditLog,
+		logger:              logger,
 	}
 }
 
-// GetServers returns list of available servers
+// config returns the current configuration. Servers are handed out as
+// copies, so a reload never changes the server a running request works with.
+func (h *Handler) config() *config.Config {
+	return h.configWatcher.Current()
+}
+
+// GetServers returns list of available servers the caller may see,
+// filtered by the env, group and tag query parameters
 func (h *Handler) GetServers(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionListServers, "")
+	defer h.finishAudit(c, audit)
+
 	var servers []models.ServerResponse
 
-	for _, server := range h.config.Servers {
-		serverResp := models.ServerResponse{
-			ID:          server.ID,
-			Name:        server.Name,
-			Host:        server.Host,
-			Port:        server.Port,
-			Description: server.Description,
-			Status:      "unknown", // We'll check this in real-time if needed
+	for _, server := range h.config().SelectServers(serverSelector(c)) {
+		if !h.canList(c, services.Resource{ServerID: server.ID}) {
+			continue
 		}
 
-		servers = append(servers, serverResp)
+		servers = append(servers, h.serverResponse(&server))
 	}
 
 	// Ensure we never return null
@@ -75,7 +108,10 @@ func (h *Handler) GetContainers(c *gin.Context) {
 	serverID := c.Param("serverID")
 	h.logger.Infof("Getting containers for server: %s", serverID)
 
-	server, err := h.config.GetServerByID(serverID)
+	audit := h.beginAudit(c, services.AuditActionListContainers, serverID)
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
 	if err != nil {
 		h.logger.Errorf("Server not found: %v", err)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
@@ -86,11 +122,15 @@ func (h *Handler) GetContainers(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second) // Increased timeout for remote operations
+	if !h.authorize(c, services.PermissionList, services.Resource{ServerID: server.ID}) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second) // Increased timeout for remote operations
 	defer cancel()
 
 	// Use SSH-based Docker discovery for remote servers
-	containers, err := h.dockerService.GetPostgreSQLContainers(ctx, server, h.sshService)
+	found, err := h.dockerService.GetPostgreSQLContainers(ctx, server, h.sshService)
 	if err != nil {
 		h.logger.Errorf("Failed to get containers from %s: %v", server.Host, err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
@@ -102,8 +142,11 @@ func (h *Handler) GetContainers(c *gin.Context) {
 	}
 
 	// Ensure we return an empty array, not null
-	if containers == nil {
-		containers = []models.ContainerResponse{}
+	containers := []models.ContainerResponse{}
+	for _, container := range found {
+		if h.canList(c, services.Resource{ServerID: server.ID, Container: container.Name}) {
+			containers = append(containers, container)
+		}
 	}
 
 	h.logger.Infof("Returning %d containers for server %s", len(containers), serverID)
@@ -120,7 +163,11 @@ func (h *Handler) GetDatabases(c *gin.Context) {
 	containerID := c.Param("containerID")
 	h.logger.Infof("Getting databases for server: %s, container: %s", serverID, containerID)
 
-	server, err := h.config.GetServerByID(serverID)
+	audit := h.beginAudit(c, services.AuditActionListDatabases, serverID)
+	audit.Container = containerID
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
 	if err != nil {
 		h.logger.Errorf("Server not found: %v", err)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
@@ -131,11 +178,25 @@ func (h *Handler) GetDatabases(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	if !h.authorize(c, services.PermissionList, services.Resource{ServerID: server.ID}) {
+		return
+	}
+
+	containerID, containerName, ok := h.resolveTarget(c, server, containerID, "", false)
+	if !ok {
+		return
+	}
+	audit.Container = containerName
+
+	if !h.authorize(c, services.PermissionList, services.Resource{ServerID: server.ID, Container: containerName}) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
 	defer cancel()
 
 	// Get databases using SSH
-	databases, err := h.postgresService.GetDatabasesViaSSH(ctx, server, containerID, h.sshService)
+	found, err := h.postgresService.GetDatabasesViaSSH(ctx, server, containerID, h.sshService)
 	if err != nil {
 		h.logger.Errorf("Failed to get databases: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
@@ -147,9 +208,7 @@ func (h *Handler) GetDatabases(c *gin.Context) {
 	}
 
 	// Ensure we return an empty array, not null
-	if databases == nil {
-		databases = []models.DatabaseResponse{}
-	}
+	databases := h.visibleDatabases(c, server.ID, containerName, found)
 
 	c.JSON(http.StatusOK, gin.H{
 		"databases":    databases,
@@ -165,7 +224,12 @@ func (h *Handler) DownloadDump(c *gin.Context) {
 	containerID := c.Param("containerID")
 	dbName := c.Param("dbName")
 
-	server, err := h.config.GetServerByID(serverID)
+	audit := h.beginAudit(c, services.AuditActionDump, serverID)
+	audit.Container = containerID
+	audit.Database = dbName
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
 	if err != nil {
 		h.logger.Errorf("Server not found: %v", err)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
@@ -177,21 +241,36 @@ func (h *Handler) DownloadDump(c *gin.Context) {
 	}
 
 	// Parse query parameters for dump options
-	options := make(map[string]interface{})
+	options, err := parseDumpOptions(c)
+	if err == nil {
+		err = h.encryptionService.CheckKey(options)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid dump options",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
 
-	if dataOnly := c.Query("data_only"); dataOnly != "" {
-		if val, err := strconv.ParseBool(dataOnly); err == nil {
-			options["data_only"] = val
-		}
+	audit.Options = auditOptions(options)
+
+	if !h.authorize(c, services.PermissionSchemaOnly, services.Resource{ServerID: server.ID, Database: dbName}) {
+		return
 	}
 
-	if schemaOnly := c.Query("schema_only"); schemaOnly != "" {
-		if val, err := strconv.ParseBool(schemaOnly); err == nil {
-			options["schema_only"] = val
-		}
+	containerID, containerName, ok := h.resolveTarget(c, server, containerID, dbName, false)
+	if !ok {
+		return
+	}
+	audit.Container = containerName
+
+	if !h.authorizeDump(c, services.Resource{ServerID: server.ID, Container: containerName, Database: dbName}, options) {
+		return
 	}
 
-	ctx := context.Background() // Don't set timeout for dump operations
+	ctx := c.Request.Context() // Don't set timeout for dump operations, they end with the request
 
 	h.logger.Infof("Creating dump for database %s in container %s on server %s", dbName, containerID, serverID)
 
@@ -206,35 +285,19 @@ func (h *Handler) DownloadDump(c *gin.Context) {
 		})
 		return
 	}
-	defer dumpReader.Close()
 
-	// Set response headers for file download
-	filename := fmt.Sprintf("%s_%s_%s.sql", serverID, containerID[:8], dbName)
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "application/sql")
-	c.Header("Content-Transfer-Encoding", "binary")
-
-	// Stream the dump to the client
-	c.Stream(func(w io.Writer) bool {
-		buffer := make([]byte, 4096)
-		n, err := dumpReader.Read(buffer)
-		if err != nil {
-			if err != io.EOF {
-				h.logger.Errorf("Error reading dump: %v", err)
-			}
-			return false
-		}
-
-		_, err = w.Write(buffer[:n])
-		return err == nil
-	})
+	filename := services.DumpFilename(serverID, containerID, dbName, options)
+	audit.Bytes, err = h.streamDump(c, dumpReader, filename, options)
+	if err != nil {
+		audit.Error = err.Error()
+	}
 }
 
 // CheckServerStatus checks if a server is accessible
 func (h *Handler) CheckServerStatus(c *gin.Context) {
 	serverID := c.Param("serverID")
 
-	server, err := h.config.GetServerByID(serverID)
+	server, err := h.config().GetServerByID(serverID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
 			Error:   "Server not found",
@@ -244,16 +307,12 @@ func (h *Handler) CheckServerStatus(c *gin.Context) {
 		return
 	}
 
-	// Test SSH connection
-	sshConfig := services.SSHConfig{
-		Host:       server.Host,
-		Port:       server.Port,
-		Username:   server.Username,
-		Password:   server.Password,
-		PrivateKey: server.PrivateKey,
+	if !h.authorize(c, services.PermissionList, services.Resource{ServerID: server.ID}) {
+		return
 	}
 
-	if err := h.sshService.TestConnection(sshConfig); err != nil {
+	// Test SSH connection
+	if err := h.sshService.TestConnection(server); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"server_id": serverID,
 			"status":    "unreachable",
@@ -275,7 +334,11 @@ func (h *Handler) GetHostDatabases(c *gin.Context) {
 	serverID := c.Param("serverID")
 	h.logger.Infof("Getting host databases for server: %s", serverID)
 
-	server, err := h.config.GetServerByID(serverID)
+	audit := h.beginAudit(c, services.AuditActionListDatabases, serverID)
+	audit.Container = services.HostContainerName
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
 	if err != nil {
 		h.logger.Errorf("Server not found: %v", err)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
@@ -286,11 +349,15 @@ func (h *Handler) GetHostDatabases(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	if !h.authorize(c, services.PermissionList, services.Resource{ServerID: server.ID, Container: services.HostContainerName}) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
 	defer cancel()
 
 	// Get host PostgreSQL databases
-	databases, err := h.postgresService.GetHostPostgreSQLDatabases(ctx, server, h.sshService)
+	found, err := h.postgresService.GetHostPostgreSQLDatabases(ctx, server, h.sshService)
 	if err != nil {
 		h.logger.Errorf("Failed to get host databases from %s: %v", server.Host, err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
@@ -302,9 +369,7 @@ func (h *Handler) GetHostDatabases(c *gin.Context) {
 	}
 
 	// Ensure we return an empty array, not null
-	if databases == nil {
-		databases = []models.DatabaseResponse{}
-	}
+	databases := h.visibleDatabases(c, server.ID, services.HostContainerName, found)
 
 	c.JSON(http.StatusOK, gin.H{
 		"databases": databases,
@@ -319,7 +384,12 @@ func (h *Handler) DownloadHostDump(c *gin.Context) {
 	serverID := c.Param("serverID")
 	dbName := c.Param("dbName")
 
-	server, err := h.config.GetServerByID(serverID)
+	audit := h.beginAudit(c, services.AuditActionDump, serverID)
+	audit.Container = services.HostContainerName
+	audit.Database = dbName
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
 	if err != nil {
 		h.logger.Errorf("Server not found: %v", err)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
@@ -331,19 +401,31 @@ func (h *Handler) DownloadHostDump(c *gin.Context) {
 	}
 
 	// Parse query parameters for dump options
-	options := make(map[string]interface{})
-	if dataOnly := c.Query("data_only"); dataOnly != "" {
-		if val, err := strconv.ParseBool(dataOnly); err == nil {
-			options["data_only"] = val
-		}
+	options, err := parseDumpOptions(c)
+	if err == nil {
+		err = h.encryptionService.CheckKey(options)
 	}
-	if schemaOnly := c.Query("schema_only"); schemaOnly != "" {
-		if val, err := strconv.ParseBool(schemaOnly); err == nil {
-			options["schema_only"] = val
-		}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid dump options",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	audit.Options = auditOptions(options)
+
+	resource := services.Resource{ServerID: server.ID, Container: services.HostContainerName, Database: dbName}
+	if !h.authorizeDump(c, resource, options) {
+		return
+	}
+
+	if _, _, ok := h.resolveTarget(c, server, "", dbName, false); !ok {
+		return
 	}
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	h.logger.Infof("Creating host dump for database %s on server %s", dbName, serverID)
 
@@ -358,25 +440,651 @@ func (h *Handler) DownloadHostDump(c *gin.Context) {
 		})
 		return
 	}
-	defer dumpReader.Close()
 
-	// Set response headers for file download
-	filename := fmt.Sprintf("%s_host_%s.sql", serverID, dbName)
+	filename := services.DumpFilename(serverID, "", dbName, options)
+	audit.Bytes, err = h.streamDump(c, dumpReader, filename, options)
+	if err != nil {
+		audit.Error = err.Error()
+	}
+}
+
+// streamDump sends a dump to the client as a file download. The response is
+// only committed once pg_dump produced output, so failures at startup are
+// reported as an error response. Failures after that are reported through
+// the X-Dump-Status and X-Dump-Error trailers. It returns the number of
+// bytes sent and the error the dump failed with, if any.
+func (h *Handler) streamDump(c *gin.Context, dumpReader io.ReadCloser, filename string, options models.DumpOptions) (int64, error) {
+	dumpReader, err := services.AwaitDumpOutput(dumpReader)
+	if err != nil {
+		h.logger.Errorf("Dump failed before producing output: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Dump failed",
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return 0, err
+	}
+
+	// Compress the stream on the way out if requested
+	dumpReader, err = services.CompressStream(dumpReader, options.Compression, options.CompressionLevel)
+	if err != nil {
+		h.logger.Errorf("Failed to compress dump: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to compress dump",
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return 0, err
+	}
+
+	// Encrypt last, compressed ciphertext would not get any smaller
+	dumpReader, err = h.encryptionService.EncryptStream(dumpReader, options)
+	if err != nil {
+		h.logger.Errorf("Failed to encrypt dump: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to encrypt dump",
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return 0, err
+	}
+
+	// Set response headers for file download. Compressed dumps are sent as
+	// compressed files without a Content-Encoding, which clients would
+	// decode on the fly and save under the compressed name. Encrypted dumps
+	// are opaque.
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "application/sql")
+	switch {
+	case options.Encryption != models.EncryptionNone:
+		c.Header("Content-Type", "application/octet-stream")
+	case options.Compression != models.CompressionNone:
+		c.Header("Content-Type", compressedContentType(options.Compression))
+	default:
+		c.Header("Content-Type", dumpContentType(options.Format))
+	}
 	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Trailer", "X-Dump-Status, X-Dump-Error")
 
 	// Stream the dump to the client
+	var sent int64
+	var streamErr error
+	buffer := make([]byte, 32*1024)
 	c.Stream(func(w io.Writer) bool {
-		buffer := make([]byte, 4096)
 		n, err := dumpReader.Read(buffer)
+		if n > 0 {
+			written, writeErr := w.Write(buffer[:n])
+			sent += int64(written)
+			if writeErr != nil {
+				streamErr = fmt.Errorf("failed to send dump: %w", writeErr)
+				return false
+			}
+		}
 		if err != nil {
 			if err != io.EOF {
-				h.logger.Errorf("Error reading host dump: %v", err)
+				h.logger.Errorf("Error reading dump: %v", err)
+				streamErr = err
+			}
+			return false
+		}
+		return true
+	})
+
+	// The exit status of pg_dump is only known once the stream is closed
+	if err := dumpReader.Close(); err != nil {
+		c.Writer.Header().Set("X-Dump-Status", "failed")
+		c.Writer.Header().Set("X-Dump-Error", trailerValue(err.Error()))
+		return sent, err
+	}
+	c.Writer.Header().Set("X-Dump-Status", "ok")
+	return sent, streamErr
+}
+
+// trailerValue folds a message onto a single line of bounded length so it
+// can be sent as a header value
+func trailerValue(message string) string {
+	message = strings.Join(strings.Fields(message), " ")
+	if len(message) > 1024 {
+		message = message[:1024]
+	}
+	return message
+}
+
+// RestoreDump restores an uploaded dump into a database in a PostgreSQL container
+func (h *Handler) RestoreDump(c *gin.Context) {
+	serverID := c.Param("serverID")
+	containerID := c.Param("containerID")
+	dbName := c.Param("dbName")
+
+	audit := h.beginAudit(c, services.AuditActionRestore, serverID)
+	audit.Container = containerID
+	audit.Database = dbName
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	if !h.authorize(c, services.PermissionRestore, services.Resource{ServerID: server.ID, Database: dbName}) {
+		return
+	}
+
+	input, options, ok := h.prepareRestore(c)
+	if !ok {
+		return
+	}
+	audit.Options = auditOptions(options)
+
+	containerID, containerName, ok := h.resolveTarget(c, server, containerID, dbName, options.CreateDatabase)
+	if !ok {
+		return
+	}
+	audit.Container = containerName
+
+	if !h.authorize(c, services.PermissionRestore, services.Resource{ServerID: server.ID, Container: containerName, Database: dbName}) {
+		return
+	}
+
+	ctx := c.Request.Context() // Don't set timeout for restore operations, they end with the request
+	start := time.Now()
+
+	upload := &countingBody{Reader: input}
+
+	output, err := h.postgresService.RestoreDumpViaSSH(ctx, server, containerID, dbName, upload, options, h.sshService)
+	audit.Bytes = upload.count
+	if err != nil {
+		h.logger.Errorf("Failed to restore dump: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to restore dump",
+			Message: fmt.Sprintf("%v\n%s", err, output),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RestoreResponse{
+		ServerID:    serverID,
+		ContainerID: containerID,
+		Database:    dbName,
+		Format:      options.Format,
+		Status:      "completed",
+		Output:      output,
+		Duration:    time.Since(start).Round(time.Millisecond).String(),
+	})
+}
+
+// RestoreHostDump restores an uploaded dump into a database of host PostgreSQL
+func (h *Handler) RestoreHostDump(c *gin.Context) {
+	serverID := c.Param("serverID")
+	dbName := c.Param("dbName")
+
+	audit := h.beginAudit(c, services.AuditActionRestore, serverID)
+	audit.Container = services.HostContainerName
+	audit.Database = dbName
+	defer h.finishAudit(c, audit)
+
+	server, err := h.config().GetServerByID(serverID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	resource := services.Resource{ServerID: server.ID, Container: services.HostContainerName, Database: dbName}
+	if !h.authorize(c, services.PermissionRestore, resource) {
+		return
+	}
+
+	input, options, ok := h.prepareRestore(c)
+	if !ok {
+		return
+	}
+	audit.Options = auditOptions(options)
+
+	if _, _, ok := h.resolveTarget(c, server, "", dbName, options.CreateDatabase); !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	start := time.Now()
+
+	upload := &countingBody{Reader: input}
+
+	output, err := h.postgresService.RestoreHostDumpViaSSH(ctx, server, dbName, upload, options, h.sshService)
+	audit.Bytes = upload.count
+	if err != nil {
+		h.logger.Errorf("Failed to restore host dump: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to restore host dump",
+			Message: fmt.Sprintf("%v\n%s", err, output),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RestoreResponse{
+		ServerID: serverID,
+		Database: dbName,
+		Format:   options.Format,
+		Status:   "completed",
+		Output:   output,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+	})
+}
+
+// CloneDatabase copies a database from one server to another by piping a dump
+// of the source into a restore on the target. Progress is streamed back as
+// newline delimited JSON events, the last one carrying the final status.
+func (h *Handler) CloneDatabase(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionClone, "")
+	defer h.finishAudit(c, audit)
+
+	var req models.CloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid clone request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	// The entry is recorded against the source, the target is part of the
+	// recorded request
+	audit.ServerID = req.Source.ServerID
+	audit.Container = req.Source.ContainerID
+	audit.Database = req.Source.Database
+	audit.Options = auditOptions(req)
+
+	if req.Source == req.Target {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid clone request",
+			Message: "source and target must be different databases",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if err := services.ValidateDumpSelectors(req.DumpOptions); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid dump options",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	sourceServer, err := h.config().GetServerByID(req.Source.ServerID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Source server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	targetServer, err := h.config().GetServerByID(req.Target.ServerID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Target server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	if !h.authorize(c, services.PermissionSchemaOnly, services.Resource{ServerID: sourceServer.ID, Database: req.Source.Database}) ||
+		!h.authorize(c, services.PermissionRestore, services.Resource{ServerID: targetServer.ID, Database: req.Target.Database}) {
+		return
+	}
+
+	sourceContainerID, sourceContainerName, ok := h.resolveTarget(c, sourceServer, req.Source.ContainerID, req.Source.Database, false)
+	if !ok {
+		return
+	}
+	targetContainerID, targetContainerName, ok := h.resolveTarget(c, targetServer, req.Target.ContainerID, req.Target.Database, req.RestoreOptions.CreateDatabase)
+	if !ok {
+		return
+	}
+	audit.Container = sourceContainerName
+
+	sourceResource := services.Resource{ServerID: sourceServer.ID, Container: sourceContainerName, Database: req.Source.Database}
+	targetResource := services.Resource{ServerID: targetServer.ID, Container: targetContainerName, Database: req.Target.Database}
+	if !h.authorizeDump(c, sourceResource, req.DumpOptions) || !h.authorize(c, services.PermissionRestore, targetResource) {
+		return
+	}
+
+	source := services.DatabaseTarget{Server: sourceServer, ContainerID: sourceContainerID, Database: req.Source.Database}
+	target := services.DatabaseTarget{Server: targetServer, ContainerID: targetContainerID, Database: req.Target.Database}
+
+	type cloneResult struct {
+		output string
+		err    error
+	}
+
+	var transferred atomic.Int64
+	done := make(chan cloneResult, 1)
+	start := time.Now()
+
+	// The clone is stopped when the client goes away
+	ctx := c.Request.Context()
+	go func() {
+		output, err := h.postgresService.CloneDatabase(ctx, source, target, req.DumpOptions, req.RestoreOptions, h.sshService, &transferred)
+		done <- cloneResult{output: output, err: err}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	finished := false
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Stream(func(w io.Writer) bool {
+		progress := models.CloneProgress{Status: "running"}
+
+		select {
+		case result := <-done:
+			finished = true
+			progress.Status = "completed"
+			progress.Output = result.output
+			if result.err != nil {
+				h.logger.Errorf("Failed to clone database: %v", result.err)
+				progress.Status = "failed"
+				progress.Error = result.err.Error()
+				audit.Error = progress.Error
 			}
+		case <-ticker.C:
+		}
+
+		progress.BytesTransferred = transferred.Load()
+		progress.Duration = time.Since(start).Round(time.Second).String()
+		if err := json.NewEncoder(w).Encode(progress); err != nil {
 			return false
 		}
-		_, err = w.Write(buffer[:n])
-		return err == nil
+		return progress.Status == "running"
 	})
+	audit.Bytes = transferred.Load()
+
+	// The response status is already 200, so a clone the client abandoned
+	// has to be recorded as a failure explicitly
+	if !finished {
+		audit.Error = "client disconnected before the clone finished"
+		if err := ctx.Err(); err != nil {
+			audit.Error += ": " + err.Error()
+		}
+	}
+}
+
+// resolveTarget checks the container and database named by a request against
+// what is discovered on the server before anything runs, writing an error
+// response and returning false if either is unknown. An empty containerID
+// refers to host PostgreSQL and an empty dbName checks the container only.
+// A database that is about to be created only needs a valid name. It returns
+// the discovered container ID and the container name, which is
+// HostContainerName for host PostgreSQL.
+func (h *Handler) resolveTarget(c *gin.Context, server *config.Server, containerID, dbName string, create bool) (string, string, bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	var err error
+	containerName := services.HostContainerName
+	if containerID != "" {
+		containerID, containerName, err = h.dockerService.ResolveContainer(ctx, server, containerID, h.sshService)
+	}
+
+	if err == nil && dbName != "" {
+		if create {
+			if err := h.postgresService.ValidateDatabaseName(dbName); err != nil {
+				c.JSON(http.StatusBadRequest, models.ErrorResponse{
+					Error:   "Invalid database name",
+					Message: err.Error(),
+					Code:    http.StatusBadRequest,
+				})
+				return "", "", false
+			}
+		} else {
+			_, err = h.postgresService.ResolveDatabase(ctx, server, containerID, dbName, h.sshService)
+		}
+	}
+
+	switch {
+	case err == nil:
+		return containerID, containerName, true
+	case errors.Is(err, services.ErrContainerNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Container not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, services.ErrDatabaseNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Database not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+	default:
+		h.logger.Errorf("Failed to look up inventory of server %s: %v", server.ID, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to look up containers and databases",
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+	}
+	return "", "", false
+}
+
+// visibleDatabases filters databases to those the caller may see. It never
+// returns nil, so listings encode as an empty array.
+func (h *Handler) visibleDatabases(c *gin.Context, serverID, containerName string, databases []models.DatabaseResponse) []models.DatabaseResponse {
+	visible := []models.DatabaseResponse{}
+	for _, database := range databases {
+		if h.canList(c, services.Resource{ServerID: serverID, Container: containerName, Database: database.Name}) {
+			visible = append(visible, database)
+		}
+	}
+	return visible
+}
+
+// prepareRestore parses the restore options and opens the uploaded dump,
+// writing an error response and returning false if either is invalid
+func (h *Handler) prepareRestore(c *gin.Context) (io.Reader, models.RestoreOptions, bool) {
+	options, err := parseRestoreOptions(c)
+	if err == nil {
+		err = services.ValidateRestoreOptions(options)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid restore options",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return nil, options, false
+	}
+
+	upload, err := restoreUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid upload",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return nil, options, false
+	}
+
+	secrets := services.DecryptionSecrets{
+		Passphrase:  c.GetHeader("X-Dump-Passphrase"),
+		AgeIdentity: c.GetHeader("X-Dump-Identity"),
+	}
+	input, err := h.encryptionService.DecryptInput(upload, secrets)
+	if err == nil {
+		var format string
+		input, format, err = services.PrepareRestoreInput(input, options.Format)
+		if err == nil {
+			options.Format = format
+			err = services.ValidateRestoreOptions(options)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid dump",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return nil, options, false
+	}
+
+	return input, options, true
+}
+
+// restoreUpload returns the uploaded dump without buffering it: the "file"
+// part of a multipart form, or the raw request body otherwise
+func restoreUpload(c *gin.Context) (io.Reader, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, nil
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("multipart form has no file field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// parseRestoreOptions parses restore options from the query string
+func parseRestoreOptions(c *gin.Context) (models.RestoreOptions, error) {
+	var options models.RestoreOptions
+
+	for key, target := range map[string]*bool{
+		"create_database":    &options.CreateDatabase,
+		"clean":              &options.Clean,
+		"single_transaction": &options.SingleTransaction,
+	} {
+		if value := c.Query(key); value != "" {
+			val, err := strconv.ParseBool(value)
+			if err != nil {
+				return options, fmt.Errorf("invalid %s value %q", key, value)
+			}
+			*target = val
+		}
+	}
+
+	if jobs := c.Query("jobs"); jobs != "" {
+		val, err := strconv.Atoi(jobs)
+		if err != nil {
+			return options, fmt.Errorf("invalid jobs value %q", jobs)
+		}
+		options.Jobs = val
+	}
+
+	format, err := services.ParseRestoreFormat(c.Query("format"))
+	if err != nil {
+		return options, err
+	}
+	options.Format = format
+
+	return options, nil
+}
+
+// parseDumpOptions parses pg_dump options from the query string
+func parseDumpOptions(c *gin.Context) (models.DumpOptions, error) {
+	var options models.DumpOptions
+
+	if dataOnly := c.Query("data_only"); dataOnly != "" {
+		if val, err := strconv.ParseBool(dataOnly); err == nil {
+			options.DataOnly = val
+		}
+	}
+
+	if schemaOnly := c.Query("schema_only"); schemaOnly != "" {
+		if val, err := strconv.ParseBool(schemaOnly); err == nil {
+			options.SchemaOnly = val
+		}
+	}
+
+	options.Tables = queryList(c, "table")
+	options.ExcludeTables = queryList(c, "exclude_table")
+	options.Schemas = queryList(c, "schema")
+	options.ExcludeSchemas = queryList(c, "exclude_schema")
+	options.ExcludeTableData = queryList(c, "exclude_table_data")
+	options.Format = c.Query("format")
+	options.Compression = c.Query("compression")
+	options.Encryption = c.Query("encryption")
+	options.Recipients = queryList(c, "recipient")
+	options.KeyID = c.Query("key_id")
+	// Passphrases go in a header, query strings end up in access logs
+	options.Passphrase = c.GetHeader("X-Dump-Passphrase")
+
+	if level := c.Query("level"); level != "" {
+		// A level of 0 would silently select the default level
+		val, err := strconv.Atoi(level)
+		if err != nil || val < 1 {
+			return options, fmt.Errorf("invalid compression level %q", level)
+		}
+		options.CompressionLevel = val
+	}
+
+	return services.NormalizeDumpOptions(options)
+}
+
+// queryList returns the values of a repeatable query parameter, also
+// splitting comma separated values
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// dumpContentType returns the content type for a dump format. Directory
+// format dumps are streamed back as a tarball of the directory.
+func dumpContentType(format string) string {
+	switch format {
+	case models.DumpFormatCustom:
+		return "application/octet-stream"
+	case models.DumpFormatTar, models.DumpFormatDirectory:
+		return "application/x-tar"
+	default:
+		return "application/sql"
+	}
+}
+
+// compressedContentType returns the media type of a compressed dump
+func compressedContentType(compression string) string {
+	switch compression {
+	case models.CompressionGzip:
+		return "application/gzip"
+	case models.CompressionZstd:
+		return "application/zstd"
+	default:
+		return "application/octet-stream"
+	}
 }
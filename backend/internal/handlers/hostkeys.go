@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// GetHostKey returns the accepted SSH host keys of a server along with the
+// key the server currently presents
+func (h *Handler) GetHostKey(c *gin.Context) {
+	serverID := c.Param("serverID")
+
+	server, err := h.config().GetServerByID(serverID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	if !h.authorize(c, services.PermissionList, services.Resource{ServerID: server.ID}) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	info, err := h.sshService.HostKeyInfo(ctx, server)
+	if err != nil {
+		h.logger.Errorf("Failed to get host key of %s: %v", serverID, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Failed to get host key",
+			Message: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// RotateHostKey replaces the accepted host key of a server with the key it
+// currently presents, which must match the fingerprint in the request
+func (h *Handler) RotateHostKey(c *gin.Context) {
+	serverID := c.Param("serverID")
+
+	var req models.RotateHostKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid rotate request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	server, err := h.config().GetServerByID(serverID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	if !h.authorize(c, services.PermissionAdmin, services.Resource{ServerID: server.ID}) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	info, err := h.sshService.RotateHostKey(ctx, server, req.Fingerprint)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrHostKeyNotManaged) {
+			status = http.StatusConflict
+		}
+		h.logger.Errorf("Failed to rotate host key of %s: %v", serverID, err)
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Failed to rotate host key",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return
+	}
+
+	h.logger.Warnf("Host key of server %s rotated to %s", serverID, req.Fingerprint)
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// CreateJob queues an asynchronous dump job. An empty container_id dumps a
+// database of host PostgreSQL. With a storage the dump is kept there under
+// jobs/ instead of the spool directory.
+func (h *Handler) CreateJob(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionCreateJob, "")
+	defer h.finishAudit(c, audit)
+
+	var req models.DumpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid job request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	audit.ServerID = req.ServerID
+	audit.Container = req.ContainerID
+	audit.Database = req.Database
+	audit.Options = auditOptions(req.Options)
+
+	if req.ServerID == "" || req.Database == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid job request",
+			Message: "server_id and database are required",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	req.Options.Passphrase = req.Passphrase
+	options, err := services.NormalizeDumpOptions(req.Options)
+	if err == nil {
+		err = h.encryptionService.CheckKey(options)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid dump options",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	audit.Options = auditOptions(options)
+
+	var storage services.Storage
+	if req.Storage != "" {
+		storage, err = h.storageService.Get(req.Storage)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:   "Invalid job request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+
+	server, err := h.config().GetServerByID(req.ServerID)
+	if err != nil {
+		h.logger.Errorf("Server not found: %v", err)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	if !h.authorize(c, services.PermissionSchemaOnly, services.Resource{ServerID: server.ID, Database: req.Database}) {
+		return
+	}
+
+	containerID, containerName, ok := h.resolveTarget(c, server, req.ContainerID, req.Database, false)
+	if !ok {
+		return
+	}
+	audit.Container = containerName
+
+	if !h.authorizeDump(c, services.Resource{ServerID: server.ID, Container: containerName, Database: req.Database}, options) {
+		return
+	}
+
+	var job models.JobResponse
+	if storage != nil {
+		dir := path.Join("jobs", server.ID, containerName, req.Database)
+		key := services.BackupKey(dir, time.Now(), services.DumpFilename(server.ID, containerID, req.Database, options))
+		job, err = h.jobService.SubmitBackup(principalOf(c).ID(), server, containerID, containerName, req.Database, options, storage, key, nil)
+	} else {
+		job, err = h.jobService.SubmitDump(principalOf(c).ID(), server, containerID, containerName, req.Database, options)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		h.logger.Errorf("Failed to queue dump job: %v", err)
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Failed to queue job",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListJobs returns the known jobs the caller may see
+func (h *Handler) ListJobs(c *gin.Context) {
+	jobs := []models.JobResponse{}
+	for _, job := range h.jobService.ListJobs() {
+		if h.canSeeJob(c, job) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  jobs,
+		"total": len(jobs),
+	})
+}
+
+// GetJob returns the state of a job
+func (h *Handler) GetJob(c *gin.Context) {
+	job, err := h.jobService.GetJob(c.Param("jobID"))
+	if err == nil && !h.canSeeJob(c, job) {
+		err = services.ErrJobNotFound
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Job not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadJobArtifact downloads the dump produced by a completed job
+func (h *Handler) DownloadJobArtifact(c *gin.Context) {
+	jobID := c.Param("jobID")
+
+	audit := h.beginAudit(c, services.AuditActionDownloadJob, "")
+	defer h.finishAudit(c, audit)
+
+	var artifact services.JobArtifact
+	job, err := h.jobService.GetJob(jobID)
+	if err == nil && !h.canSeeJob(c, job) {
+		err = services.ErrJobNotFound
+	}
+	if err == nil {
+		audit.ServerID = job.ServerID
+		audit.Container = job.ContainerName
+		if audit.Container == "" {
+			audit.Container = services.HostContainerName
+		}
+		audit.Database = job.Database
+		audit.Options = auditOptions(job.Options)
+		artifact, err = h.jobService.Artifact(jobID)
+	}
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, services.ErrJobNotFinished) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Artifact not available",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return
+	}
+
+	if artifact.Storage == nil {
+		c.FileAttachment(artifact.Path, artifact.Filename)
+		audit.Bytes = int64(c.Writer.Size())
+		return
+	}
+
+	audit.Bytes, err = h.sendStoredObject(c, artifact.Storage, artifact.Key, artifact.Filename, -1, "")
+	if err != nil {
+		audit.Error = err.Error()
+	}
+}
+
+// CancelJob aborts a queued or running job. A running dump is stopped on
+// its server; the job shows as cancelled once it has been cleaned up.
+func (h *Handler) CancelJob(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionCancelJob, "")
+	defer h.finishAudit(c, audit)
+
+	job, err := h.jobService.GetJob(c.Param("jobID"))
+	if err == nil && !h.canSeeJob(c, job) {
+		err = services.ErrJobNotFound
+	}
+	if err == nil {
+		audit.ServerID = job.ServerID
+		audit.Container = job.ContainerName
+		if audit.Container == "" {
+			audit.Container = services.HostContainerName
+		}
+		audit.Database = job.Database
+		audit.Options = auditOptions(gin.H{"job": job.ID})
+		job, err = h.jobService.Cancel(job.ID)
+	}
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, services.ErrJobFinished) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Failed to cancel job",
+			Message: err.Error(),
+			Code:    status,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// canSeeJob reports whether the caller may see a job and download its dump:
+// jobs are visible to whoever created them and to admins of their server
+func (h *Handler) canSeeJob(c *gin.Context, job models.JobResponse) bool {
+	principal := principalOf(c)
+	return job.Owner == principal.ID() ||
+		h.authService.Authorize(principal, services.PermissionAdmin, services.Resource{ServerID: job.ServerID})
+}
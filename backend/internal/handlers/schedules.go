@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// ListSchedules returns the backup schedules with their next and last runs
+func (h *Handler) ListSchedules(c *gin.Context) {
+	if !h.authorize(c, services.PermissionList, services.Resource{}) {
+		return
+	}
+
+	schedules := []models.ScheduleResponse{}
+	for _, schedule := range h.config().GetSchedules() {
+		schedules = append(schedules, h.scheduleResponse(schedule))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+		"total":     len(schedules),
+	})
+}
+
+// CreateSchedule adds a schedule to the schedule store
+func (h *Handler) CreateSchedule(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionCreateSchedule, "")
+	defer h.finishAudit(c, audit)
+
+	if !h.authorizeEverywhere(c, services.PermissionAdmin) {
+		return
+	}
+
+	schedule, ok := h.bindSchedule(c, "")
+	if !ok {
+		return
+	}
+	audit.Options = auditOptions(gin.H{"schedule": schedule.Name})
+
+	if err := h.config().ScheduleStore().Create(schedule); err != nil {
+		h.writeScheduleStoreError(c, err)
+		return
+	}
+
+	h.logger.Infof("Schedule %s added by %s", schedule.Name, principalOf(c).ID())
+	c.JSON(http.StatusCreated, h.scheduleResponse(schedule))
+}
+
+// UpdateSchedule replaces a schedule of the schedule store. Schedules of
+// config.yaml are read only.
+func (h *Handler) UpdateSchedule(c *gin.Context) {
+	name := c.Param("name")
+
+	audit := h.beginAudit(c, services.AuditActionUpdateSchedule, "")
+	audit.Options = auditOptions(gin.H{"schedule": name})
+	defer h.finishAudit(c, audit)
+
+	if !h.authorizeEverywhere(c, services.PermissionAdmin) {
+		return
+	}
+
+	schedule, ok := h.bindSchedule(c, name)
+	if !ok {
+		return
+	}
+
+	if err := h.config().ScheduleStore().Update(name, schedule); err != nil {
+		h.writeScheduleStoreError(c, err)
+		return
+	}
+
+	h.logger.Infof("Schedule %s updated by %s", name, principalOf(c).ID())
+	c.JSON(http.StatusOK, h.scheduleResponse(schedule))
+}
+
+// DeleteSchedule removes a schedule from the schedule store. Its backups are
+// kept, and a run in progress finishes.
+func (h *Handler) DeleteSchedule(c *gin.Context) {
+	name := c.Param("name")
+
+	audit := h.beginAudit(c, services.AuditActionDeleteSchedule, "")
+	audit.Options = auditOptions(gin.H{"schedule": name})
+	defer h.finishAudit(c, audit)
+
+	if !h.authorizeEverywhere(c, services.PermissionAdmin) {
+		return
+	}
+
+	if err := h.config().ScheduleStore().Delete(name); err != nil {
+		h.writeScheduleStoreError(c, err)
+		return
+	}
+
+	h.logger.Infof("Schedule %s deleted by %s", name, principalOf(c).ID())
+	c.Status(http.StatusNoContent)
+}
+
+// RunSchedule starts a run of a schedule right away, also if it is disabled
+func (h *Handler) RunSchedule(c *gin.Context) {
+	name := c.Param("name")
+
+	audit := h.beginAudit(c, services.AuditActionRunSchedule, "")
+	audit.Options = auditOptions(gin.H{"schedule": name})
+	defer h.finishAudit(c, audit)
+
+	if !h.authorizeEverywhere(c, services.PermissionAdmin) {
+		return
+	}
+
+	schedule, err := h.config().ScheduleStore().Get(name)
+	if err != nil {
+		h.writeScheduleStoreError(c, err)
+		return
+	}
+
+	if err := h.scheduler.Run(*schedule); err != nil {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "Schedule is running",
+			Message: err.Error(),
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	h.logger.Infof("Schedule %s started by %s", name, principalOf(c).ID())
+	c.JSON(http.StatusAccepted, h.scheduleResponse(*schedule))
+}
+
+// bindSchedule reads a schedule from the request body and checks it. The
+// name of an update defaults to name and must not differ from it.
+func (h *Handler) bindSchedule(c *gin.Context, name string) (config.Schedule, bool) {
+	var req models.Schedule
+	err := c.ShouldBindJSON(&req)
+
+	schedule := scheduleFromRequest(req)
+	if err == nil && name != "" {
+		if schedule.Name == "" {
+			schedule.Name = name
+		}
+		if schedule.Name != name {
+			err = fmt.Errorf("name %q does not match schedule %q, schedule names cannot be changed", schedule.Name, name)
+		}
+	}
+	if err == nil {
+		err = h.scheduler.CheckSchedule(schedule)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid schedule",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return config.Schedule{}, false
+	}
+	return schedule, true
+}
+
+// writeScheduleStoreError writes the response for a failed schedule store
+// operation
+func (h *Handler) writeScheduleStoreError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, config.ErrScheduleNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, config.ErrScheduleExists), errors.Is(err, config.ErrScheduleReadOnly):
+		status = http.StatusConflict
+	default:
+		h.logger.Errorf("Failed to save schedule: %v", err)
+	}
+
+	c.JSON(status, models.ErrorResponse{
+		Error:   http.StatusText(status),
+		Message: err.Error(),
+		Code:    status,
+	})
+}
+
+// scheduleResponse returns a schedule as shown by the API
+func (h *Handler) scheduleResponse(schedule config.Schedule) models.ScheduleResponse {
+	return models.ScheduleResponse{
+		Schedule: models.Schedule{
+			Name:     schedule.Name,
+			Cron:     schedule.Cron,
+			Timezone: schedule.Timezone,
+			Servers: models.ServerSelector{
+				IDs:         schedule.Servers.IDs,
+				Environment: schedule.Servers.Environment,
+				Group:       schedule.Servers.Group,
+				Tags:        schedule.Servers.Tags,
+			},
+			Containers: schedule.Containers,
+			Databases:  schedule.Databases,
+			Options:    services.ScheduleDumpOptions(schedule.Options),
+			Retention: models.RetentionPolicy{
+				Daily:   schedule.Retention.Daily,
+				Weekly:  schedule.Retention.Weekly,
+				Monthly: schedule.Retention.Monthly,
+			},
+			Storage:  schedule.Storage,
+			Disabled: schedule.Disabled,
+		},
+		ReadOnly: h.config().ScheduleStore().ReadOnly(schedule.Name),
+		NextRun:  h.scheduler.NextRun(schedule),
+		LastRun:  h.scheduler.LastRun(schedule.Name),
+	}
+}
+
+// scheduleFromRequest converts a schedule request to the configuration of a
+// schedule
+func scheduleFromRequest(req models.Schedule) config.Schedule {
+	return config.Schedule{
+		Name:     req.Name,
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Servers: config.ServerSelector{
+			IDs:         req.Servers.IDs,
+			Environment: req.Servers.Environment,
+			Group:       req.Servers.Group,
+			Tags:        req.Servers.Tags,
+		},
+		Containers: req.Containers,
+		Databases:  req.Databases,
+		Options: config.ScheduleOptions{
+			Format:           req.Options.Format,
+			DataOnly:         req.Options.DataOnly,
+			SchemaOnly:       req.Options.SchemaOnly,
+			Tables:           req.Options.Tables,
+			ExcludeTables:    req.Options.ExcludeTables,
+			Schemas:          req.Options.Schemas,
+			ExcludeSchemas:   req.Options.ExcludeSchemas,
+			ExcludeTableData: req.Options.ExcludeTableData,
+			Compression:      req.Options.Compression,
+			CompressionLevel: req.Options.CompressionLevel,
+			Encryption:       req.Options.Encryption,
+			Recipients:       req.Options.Recipients,
+			KeyID:            req.Options.KeyID,
+		},
+		Retention: config.Retention{
+			Daily:   req.Retention.Daily,
+			Weekly:  req.Retention.Weekly,
+			Monthly: req.Retention.Monthly,
+		},
+		Storage:  req.Storage,
+		Disabled: req.Disabled,
+	}
+}
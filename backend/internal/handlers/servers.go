@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/services"
+)
+
+// CreateServer adds a server to the server store. Remote servers must pass
+// a connectivity test unless skip_test=true is given.
+func (h *Handler) CreateServer(c *gin.Context) {
+	audit := h.beginAudit(c, services.AuditActionCreateServer, "")
+	defer h.finishAudit(c, audit)
+
+	server, ok := h.bindServer(c, "")
+	if !ok {
+		return
+	}
+	audit.ServerID = server.ID
+
+	if !h.authorize(c, services.PermissionAdmin, services.Resource{ServerID: server.ID}) ||
+		!h.authorizeSubmitted(c, server) {
+		return
+	}
+
+	if !h.testServer(c, server) {
+		return
+	}
+
+	if err := h.config().ServerStore().Create(server); err != nil {
+		h.writeServerStoreError(c, err)
+		return
+	}
+
+	h.logger.Infof("Server %s added by %s", server.ID, principalOf(c).ID())
+	c.JSON(http.StatusCreated, h.serverResponse(&server))
+}
+
+// UpdateServer replaces a server of the server store. Servers of config.yaml
+// are read only.
+func (h *Handler) UpdateServer(c *gin.Context) {
+	serverID := c.Param("serverID")
+
+	audit := h.beginAudit(c, services.AuditActionUpdateServer, serverID)
+	defer h.finishAudit(c, audit)
+
+	server, ok := h.bindServer(c, serverID)
+	if !ok {
+		return
+	}
+
+	if !h.authorize(c, services.PermissionAdmin, services.Resource{ServerID: serverID}) ||
+		!h.authorizeSubmitted(c, server) {
+		return
+	}
+
+	if _, err := h.config().GetServerByID(serverID); err != nil {
+		h.writeServerStoreError(c, err)
+		return
+	}
+	if h.config().ServerStore().ReadOnly(serverID) {
+		h.writeServerStoreError(c, config.ErrServerReadOnly)
+		return
+	}
+
+	if !h.testServer(c, server) {
+		return
+	}
+
+	if err := h.config().ServerStore().Update(serverID, server); err != nil {
+		h.writeServerStoreError(c, err)
+		return
+	}
+
+	h.logger.Infof("Server %s updated by %s", serverID, principalOf(c).ID())
+	c.JSON(http.StatusOK, h.serverResponse(&server))
+}
+
+// DeleteServer removes a server from the server store. Jobs already running
+// against it are not affected.
+func (h *Handler) DeleteServer(c *gin.Context) {
+	serverID := c.Param("serverID")
+
+	audit := h.beginAudit(c, services.AuditActionDeleteServer, serverID)
+	defer h.finishAudit(c, audit)
+
+	if !h.authorize(c, services.PermissionAdmin, services.Resource{ServerID: serverID}) {
+		return
+	}
+
+	if err := h.config().ServerStore().Delete(serverID); err != nil {
+		h.writeServerStoreError(c, err)
+		return
+	}
+
+	h.logger.Infof("Server %s deleted by %s", serverID, principalOf(c).ID())
+	c.Status(http.StatusNoContent)
+}
+
+// bindServer reads a server from the request body and validates it. The
+// ID of an update defaults to serverID and must not differ from it.
+func (h *Handler) bindServer(c *gin.Context, serverID string) (config.Server, bool) {
+	var req models.ServerRequest
+	err := c.ShouldBindJSON(&req)
+
+	server := serverFromRequest(req)
+	if err == nil && serverID != "" {
+		if server.ID == "" {
+			server.ID = serverID
+		}
+		if server.ID != serverID {
+			err = fmt.Errorf("id %q does not match server %q, server IDs cannot be changed", server.ID, serverID)
+		}
+	}
+	if err == nil {
+		if server.Name == "" {
+			server.Name = server.ID
+		}
+		err = server.Validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid server",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return config.Server{}, false
+	}
+	return server, true
+}
+
+// authorizeSubmitted checks that the caller may submit a server that uses
+// secrets, files or the SSH agent of the backend, or runs its commands on the
+// backend itself. Only admins of all servers may, as they could read them
+// anyway; anyone else must give credentials inline for a remote host.
+func (h *Handler) authorizeSubmitted(c *gin.Context, server config.Server) bool {
+	principal := principalOf(c)
+	if h.authService.AuthorizeEverywhere(principal, services.PermissionAdmin) {
+		return true
+	}
+
+	if err := server.CheckSubmitted(); err != nil {
+		h.logger.Warnf("Denied server %s submitted by %s: %v", server.ID, principal.ID(), err)
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: fmt.Sprintf("%v, which requires the admin permission on all servers", err),
+			Code:    http.StatusForbidden,
+		})
+		return false
+	}
+	return true
+}
+
+// testServer resolves the credentials of a server and checks that it can be
+// connected to, writing the error response if not. Local servers have
+// nothing to connect to. The credentials are only sent to hosts whose keys
+// are pinned, so a test cannot be pointed at a host that collects them.
+func (h *Handler) testServer(c *gin.Context, server config.Server) bool {
+	resolved, err := h.config().ServerStore().Resolve(server)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid server",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return false
+	}
+
+	if skip, _ := strconv.ParseBool(c.Query("skip_test")); skip || resolved.IsLocal() {
+		return true
+	}
+
+	if err := requirePinnedHostKeys(resolved); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid server",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return false
+	}
+
+	if err := h.sshService.TestConnection(resolved); err != nil {
+		h.logger.Warnf("Connectivity test of server %s failed: %v", server.ID, err)
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Error:   "Connectivity test failed",
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		})
+		return false
+	}
+	return true
+}
+
+// requirePinnedHostKeys checks that the host keys of a server and its jump
+// hosts are pinned by fingerprint or known_hosts file rather than accepted
+// on first use
+func requirePinnedHostKeys(server *config.Server) error {
+	if server.HostKeyFingerprint == "" && server.KnownHostsFile == "" {
+		return fmt.Errorf("host_key_fingerprint is required to test the connection, or use skip_test=true")
+	}
+	for _, hop := range server.JumpHosts {
+		if hop.HostKeyFingerprint == "" && hop.KnownHostsFile == "" {
+			return fmt.Errorf("jump host %s: host_key_fingerprint is required to test the connection, or use skip_test=true", hop.Host)
+		}
+	}
+	return nil
+}
+
+// writeServerStoreError writes the response for a failed server store operation
+func (h *Handler) writeServerStoreError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, config.ErrServerNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, config.ErrServerExists), errors.Is(err, config.ErrServerReadOnly):
+		status = http.StatusConflict
+	default:
+		h.logger.Errorf("Failed to save server: %v", err)
+	}
+
+	c.JSON(status, models.ErrorResponse{
+		Error:   http.StatusText(status),
+		Message: err.Error(),
+		Code:    status,
+	})
+}
+
+// serverResponse returns a server as shown by the API, without its credentials
+func (h *Handler) serverResponse(server *config.Server) models.ServerResponse {
+	// Ensure we never return null
+	tags := server.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return models.ServerResponse{
+		ID:          server.ID,
+		Name:        server.Name,
+		Host:        server.Host,
+		Port:        server.Port,
+		Description: server.Description,
+		Environment: server.Environment,
+		Group:       server.Group,
+		Tags:        tags,
+		Status:      "unknown", // We'll check this in real-time if needed
+		ReadOnly:    h.config().ServerStore().ReadOnly(server.ID),
+	}
+}
+
+// serverSelector parses a server selector from the env, group and tag query
+// parameters. tag is repeatable or comma separated.
+func serverSelector(c *gin.Context) config.ServerSelector {
+	return config.ServerSelector{
+		Environment: c.Query("env"),
+		Group:       c.Query("group"),
+		Tags:        queryList(c, "tag"),
+	}
+}
+
+// serverFromRequest converts a server request to the configuration of a server
+func serverFromRequest(req models.ServerRequest) config.Server {
+	server := config.Server{
+		ID:                   req.ID,
+		Name:                 req.Name,
+		Host:                 req.Host,
+		Port:                 req.Port,
+		Username:             req.Username,
+		PostgresUser:         req.PostgresUser,
+		Password:             req.Password,
+		PrivateKey:           req.PrivateKey,
+		PrivateKeyPassphrase: req.PrivateKeyPassphrase,
+		DockerHost:           req.DockerHost,
+		Description:          req.Description,
+		Environment:          req.Environment,
+		Group:                req.Group,
+		Tags:                 req.Tags,
+		ForwardAgent:         req.ForwardAgent,
+		HostKeyFingerprint:   req.HostKeyFingerprint,
+		KnownHostsFile:       req.KnownHostsFile,
+	}
+	for _, hop := range req.JumpHosts {
+		server.JumpHosts = append(server.JumpHosts, config.JumpHost{
+			Host:                 hop.Host,
+			Port:                 hop.Port,
+			Username:             hop.Username,
+			Password:             hop.Password,
+			PrivateKey:           hop.PrivateKey,
+			PrivateKeyPassphrase: hop.PrivateKeyPassphrase,
+			HostKeyFingerprint:   hop.HostKeyFingerprint,
+			KnownHostsFile:       hop.KnownHostsFile,
+		})
+	}
+	return server
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+	"backend/internal/services"
+)
+
+// testServerRouter returns the server routes of main.go for the tokens
+// "admin-token", with the built-in admin role, and "prod-token", an admin of
+// the servers prod-*. The store holds the remote server prod-1.
+func testServerRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+inventory:
+  file: "`+filepath.Join(dir, "servers.yaml")+`"
+backups:
+  schedules_file: "`+filepath.Join(dir, "schedules.yaml")+`"
+`), 0o600))
+	cfg, err := config.LoadConfig(configPath)
+	require.NoError(t, err)
+	require.NoError(t, cfg.ServerStore().Create(config.Server{ID: "prod-1", Name: "prod-1", Host: "db.example.com"}))
+
+	tokenHash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	authService, err := services.NewAuthService(config.Auth{
+		Tokens: []config.APIToken{
+			{Name: "admin", Hash: tokenHash("admin-token"), Roles: []string{services.AdminRole}},
+			{Name: "prod", Hash: tokenHash("prod-token"), Roles: []string{"prod-admin"}},
+		},
+		Roles: []config.Role{{Name: "prod-admin", Permissions: []string{services.PermissionAdmin}, Servers: []string{"prod-*"}}},
+	}, logger)
+	require.NoError(t, err)
+
+	auditLog, err := services.NewAuditLog(config.Audit{File: filepath.Join(dir, "audit.log")}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLog.Close() })
+
+	h := &Handler{
+		configWatcher: config.NewWatcher(configPath, cfg, logger),
+		authService:   authService,
+		auditLog:      auditLog,
+		logger:        logger,
+	}
+
+	r := gin.New()
+	api := r.Group("/api/v1")
+	api.Use(h.RequireAuth)
+	api.POST("/servers", h.CreateServer)
+	api.PUT("/servers/:serverID", h.UpdateServer)
+	return r
+}
+
+func TestSubmitLocalServer(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"scoped admin, empty host", "prod-token", http.MethodPost, "/api/v1/servers", `{"id":"prod-2","host":""}`, http.StatusForbidden},
+		{"scoped admin, localhost", "prod-token", http.MethodPost, "/api/v1/servers", `{"id":"prod-2","host":"localhost"}`, http.StatusForbidden},
+		{"scoped admin, loopback address", "prod-token", http.MethodPost, "/api/v1/servers", `{"id":"prod-2","host":"127.0.0.1"}`, http.StatusForbidden},
+		{"scoped admin, update to localhost", "prod-token", http.MethodPut, "/api/v1/servers/prod-1", `{"host":"localhost"}`, http.StatusForbidden},
+		{"scoped admin, remote host", "prod-token", http.MethodPost, "/api/v1/servers?skip_test=true", `{"id":"prod-2","host":"db2.example.com","username":"backup","password":"secret"}`, http.StatusCreated},
+		{"scoped admin, localhost behind a jump host", "prod-token", http.MethodPost, "/api/v1/servers?skip_test=true", `{"id":"prod-2","host":"localhost","jump_hosts":[{"host":"bastion.example.com"}]}`, http.StatusCreated},
+		{"global admin, empty host", "admin-token", http.MethodPost, "/api/v1/servers", `{"id":"prod-2","host":""}`, http.StatusCreated},
+		{"global admin, update to localhost", "admin-token", http.MethodPut, "/api/v1/servers/prod-1", `{"host":"localhost"}`, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testServerRouter(t)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tc.status, w.Code, w.Body.String())
+			if tc.status == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), "is the backend itself, which requires the admin permission on all servers")
+			}
+		})
+	}
+}
@@ -1,15 +1,75 @@
 package models
 
-import "time"
+import (
+    "encoding/json"
+    "time"
+)
 
 // ServerResponse represents a server in API responses
 type ServerResponse struct {
-    ID          string `json:"id"`
-    Name        string `json:"name"`
-    Host        string `json:"host"`
-    Port        int    `json:"port"`
-    Description string `json:"description,omitempty"`
-    Status      string `json:"status"`
+    ID          string   `json:"id"`
+    Name        string   `json:"name"`
+    Host        string   `json:"host"`
+    Port        int      `json:"port"`
+    Description string   `json:"description,omitempty"`
+    Environment string   `json:"environment,omitempty"`
+    Group       string   `json:"group,omitempty"`
+    Tags        []string `json:"tags"`
+    Status      string   `json:"status"`
+    // ReadOnly is set for servers of config.yaml, which cannot be changed
+    // through the API
+    ReadOnly bool `json:"read_only"`
+}
+
+// ServerRequest represents a server to add or replace through the API.
+// Credentials may be secret references like in config.yaml.
+type ServerRequest struct {
+    ID                   string            `json:"id"`
+    Name                 string            `json:"name"`
+    Host                 string            `json:"host"`
+    Port                 int               `json:"port,omitempty"`
+    Username             string            `json:"username,omitempty"`
+    PostgresUser         string            `json:"postgres_user,omitempty"`
+    Password             string            `json:"password,omitempty"`
+    PrivateKey           string            `json:"private_key,omitempty"`
+    PrivateKeyPassphrase string            `json:"private_key_passphrase,omitempty"`
+    DockerHost           string            `json:"docker_host,omitempty"`
+    Description          string            `json:"description,omitempty"`
+    Environment          string            `json:"environment,omitempty"`
+    Group                string            `json:"group,omitempty"`
+    Tags                 []string          `json:"tags,omitempty"`
+    ForwardAgent         bool              `json:"forward_agent,omitempty"`
+    HostKeyFingerprint   string            `json:"host_key_fingerprint,omitempty"`
+    KnownHostsFile       string            `json:"known_hosts_file,omitempty"`
+    JumpHosts            []JumpHostRequest `json:"jump_hosts,omitempty"`
+}
+
+// JumpHostRequest represents an SSH bastion of a ServerRequest
+type JumpHostRequest struct {
+    Host                 string `json:"host"`
+    Port                 int    `json:"port,omitempty"`
+    Username             string `json:"username,omitempty"`
+    Password             string `json:"password,omitempty"`
+    PrivateKey           string `json:"private_key,omitempty"`
+    PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty"`
+    HostKeyFingerprint   string `json:"host_key_fingerprint,omitempty"`
+    KnownHostsFile       string `json:"known_hosts_file,omitempty"`
+}
+
+// ServerDiscovery represents the containers and databases found on one
+// server by bulk discovery
+type ServerDiscovery struct {
+    ServerID   string               `json:"server_id"`
+    Containers []ContainerDiscovery `json:"containers"`
+    Error      string               `json:"error,omitempty"`
+}
+
+// ContainerDiscovery represents a container and its databases found by bulk
+// discovery
+type ContainerDiscovery struct {
+    Container ContainerResponse  `json:"container"`
+    Databases []DatabaseResponse `json:"databases"`
+    Error     string             `json:"error,omitempty"`
 }
 
 // ContainerResponse represents a PostgreSQL container in API responses
@@ -48,14 +108,328 @@ type PostgreSQLConnection struct {
     Database string `json:"database"`
 }
 
+// Dump output formats accepted by pg_dump
+const (
+    DumpFormatPlain     = "plain"
+    DumpFormatCustom    = "custom"
+    DumpFormatTar       = "tar"
+    DumpFormatDirectory = "directory"
+)
+
+// Compression algorithms applied to dump streams
+const (
+    CompressionNone = "none"
+    CompressionGzip = "gzip"
+    CompressionZstd = "zstd"
+)
+
+// Encryption algorithms applied to dump streams
+const (
+    EncryptionNone   = "none"
+    EncryptionAge    = "age"
+    EncryptionAESGCM = "aes-256-gcm"
+)
+
+// DumpOptions represents the pg_dump options of a dump request
+type DumpOptions struct {
+    DataOnly         bool     `json:"data_only"`
+    SchemaOnly       bool     `json:"schema_only"`
+    Format           string   `json:"format,omitempty"`
+    Tables           []string `json:"tables,omitempty"`
+    ExcludeTables    []string `json:"exclude_tables,omitempty"`
+    Schemas          []string `json:"schemas,omitempty"`
+    ExcludeSchemas   []string `json:"exclude_schemas,omitempty"`
+    ExcludeTableData []string `json:"exclude_table_data,omitempty"`
+    Compression      string   `json:"compression,omitempty"`
+    CompressionLevel int      `json:"compression_level,omitempty"`
+    Encryption       string   `json:"encryption,omitempty"`
+    // Recipients are the age recipients of an age encrypted dump
+    Recipients []string `json:"recipients,omitempty"`
+    // KeyID names the configured key of an AES-256-GCM encrypted dump
+    KeyID string `json:"key_id,omitempty"`
+    // Passphrase encrypts an AES-256-GCM dump instead of a configured key.
+    // It is never serialized, so it stays out of job listings and the audit log.
+    Passphrase string `json:"-"`
+}
+
 // DumpRequest represents a database dump request
 type DumpRequest struct {
+    ServerID    string      `json:"server_id"`
+    ContainerID string      `json:"container_id"`
+    Database    string      `json:"database"`
+    Options     DumpOptions `json:"options,omitempty"`
+    // Storage names the backup storage an asynchronous dump is written to
+    // instead of the spool directory
+    Storage string `json:"storage,omitempty"`
+    // Passphrase sets DumpOptions.Passphrase, which is not read from JSON
+    Passphrase string `json:"passphrase,omitempty"`
+}
+
+// RestoreOptions represents the options of a database restore request
+type RestoreOptions struct {
+    Format            string `json:"format,omitempty"`
+    CreateDatabase    bool   `json:"create_database"`
+    Clean             bool   `json:"clean"`
+    SingleTransaction bool   `json:"single_transaction"`
+    Jobs              int    `json:"jobs,omitempty"`
+}
+
+// RestoreResponse represents the result of a database restore
+type RestoreResponse struct {
     ServerID    string `json:"server_id"`
-    ContainerID string `json:"container_id"`
+    ContainerID string `json:"container_id,omitempty"`
     Database    string `json:"database"`
-    Options     struct {
-        DataOnly   bool     `json:"data_only"`
-        SchemaOnly bool     `json:"schema_only"`
-        Tables     []string `json:"tables,omitempty"`
-    } `json:"options,omitempty"`
+    Format      string `json:"format"`
+    Status      string `json:"status"`
+    Output      string `json:"output,omitempty"`
+    Duration    string `json:"duration"`
+}
+
+// DatabaseRef identifies a database on a configured server. An empty
+// ContainerID refers to PostgreSQL installed on the host.
+type DatabaseRef struct {
+    ServerID    string `json:"server_id" binding:"required"`
+    ContainerID string `json:"container_id,omitempty"`
+    Database    string `json:"database" binding:"required"`
+}
+
+// CloneRequest represents a server-to-server database clone request
+type CloneRequest struct {
+    Source         DatabaseRef    `json:"source" binding:"required"`
+    Target         DatabaseRef    `json:"target" binding:"required"`
+    DumpOptions    DumpOptions    `json:"dump_options"`
+    RestoreOptions RestoreOptions `json:"restore_options"`
+}
+
+// CloneProgress represents a progress or final status event of a clone
+type CloneProgress struct {
+    Status           string `json:"status"`
+    BytesTransferred int64  `json:"bytes_transferred"`
+    Duration         string `json:"duration"`
+    Output           string `json:"output,omitempty"`
+    Error            string `json:"error,omitempty"`
+}
+
+// Job states
+const (
+    JobStatusQueued    = "queued"
+    JobStatusRunning   = "running"
+    JobStatusCompleted = "completed"
+    JobStatusFailed    = "failed"
+    JobStatusCancelled = "cancelled"
+)
+
+// JobResponse represents an asynchronous dump job in API responses
+type JobResponse struct {
+    ID           string      `json:"id"`
+    Status       string      `json:"status"`
+    Owner        string      `json:"owner"`
+    ServerID     string      `json:"server_id"`
+    ContainerID  string      `json:"container_id,omitempty"`
+    // ContainerName is the name of the container, empty for host PostgreSQL
+    ContainerName string     `json:"container_name,omitempty"`
+    Database     string      `json:"database"`
+    Options      DumpOptions `json:"options"`
+    Filename     string      `json:"filename"`
+    // Storage and Key locate the artifact of a job that wrote to backup
+    // storage rather than the spool directory
+    Storage      string      `json:"storage,omitempty"`
+    Key          string      `json:"key,omitempty"`
+    // BackupID is the catalog entry of a stored artifact
+    BackupID     string      `json:"backup_id,omitempty"`
+    BytesWritten int64       `json:"bytes_written"`
+    Duration     string      `json:"duration,omitempty"`
+    Error        string      `json:"error,omitempty"`
+    CreatedAt    time.Time   `json:"created_at"`
+    StartedAt    *time.Time  `json:"started_at,omitempty"`
+    FinishedAt   *time.Time  `json:"finished_at,omitempty"`
+}
+
+// Backup is a dump kept in backup storage, as recorded in the backup catalog
+type Backup struct {
+    ID            string      `json:"id"`
+    ServerID      string      `json:"server_id"`
+    ContainerID   string      `json:"container_id,omitempty"`
+    ContainerName string      `json:"container_name"`
+    Database      string      `json:"database"`
+    Options       DumpOptions `json:"options"`
+    Format        string      `json:"format"`
+    Compression   string      `json:"compression"`
+    Encryption    string      `json:"encryption"`
+    Size          int64       `json:"size"`
+    SHA256        string      `json:"sha256"`
+    // ServerVersion is the version of the PostgreSQL server dumped, empty
+    // if it could not be read
+    ServerVersion string      `json:"server_version,omitempty"`
+    // RowEstimates are the planner's row estimates of the tables dumped
+    // when the dump started, by qualified table name and -1 for tables
+    // never analyzed. Null if they could not be read.
+    RowEstimates  map[string]int64 `json:"row_estimates"`
+    Storage       string      `json:"storage"`
+    Key           string      `json:"key"`
+    // Owner is who took the backup, schedule:<name> for scheduled backups
+    Owner         string      `json:"owner"`
+    JobID         string      `json:"job_id"`
+    StartedAt     time.Time   `json:"started_at"`
+    FinishedAt    time.Time   `json:"finished_at"`
+    // Verification is the last finished restore verification, if any
+    Verification  *BackupVerification `json:"verification,omitempty"`
+}
+
+// Verification states, besides the queued and running job states
+const (
+    VerificationPassed = "passed"
+    VerificationFailed = "failed"
+)
+
+// Verification check states
+const (
+    CheckPassed  = "passed"
+    CheckFailed  = "failed"
+    CheckSkipped = "skipped"
+)
+
+// VerifyRequest starts a restore verification of a backup
+type VerifyRequest struct {
+    // ServerID is the server the throwaway container runs on, the server
+    // of the backup by default
+    ServerID string `json:"server_id,omitempty"`
+    // Version is the PostgreSQL major version of the container, taken from
+    // the catalog by default
+    Version string `json:"version,omitempty"`
+    // RowTolerance is the fraction row counts may differ from the source
+    // by, for sources written to since the backup
+    RowTolerance float64 `json:"row_tolerance,omitempty"`
+    // CompareSource counts the rows of the source database to compare the
+    // restore with, instead of the row estimates recorded with the backup.
+    // Counting scans every table of the source.
+    CompareSource bool `json:"compare_source,omitempty"`
+}
+
+// VerificationCheck is the outcome of one check of a verification
+type VerificationCheck struct {
+    Name    string `json:"name"`
+    Status  string `json:"status"`
+    Message string `json:"message,omitempty"`
+}
+
+// BackupVerification is a restore of a backup into a throwaway container
+// and the checks run against it
+type BackupVerification struct {
+    Status      string              `json:"status"`
+    ServerID    string              `json:"server_id"`
+    Image       string              `json:"image,omitempty"`
+    RequestedBy string              `json:"requested_by"`
+    Checks      []VerificationCheck `json:"checks,omitempty"`
+    Error       string              `json:"error,omitempty"`
+    Duration    string              `json:"duration,omitempty"`
+    StartedAt   time.Time           `json:"started_at"`
+    FinishedAt  *time.Time          `json:"finished_at,omitempty"`
+}
+
+// ServerSelector selects servers by glob patterns of their IDs and by their
+// labels. Empty fields match every server.
+type ServerSelector struct {
+    IDs         []string `json:"ids,omitempty"`
+    Environment string   `json:"environment,omitempty"`
+    Group       string   `json:"group,omitempty"`
+    Tags        []string `json:"tags,omitempty"`
+}
+
+// RetentionPolicy is how many backups of each database a schedule keeps
+type RetentionPolicy struct {
+    Daily   int `json:"daily"`
+    Weekly  int `json:"weekly"`
+    Monthly int `json:"monthly"`
+}
+
+// Schedule represents recurring backups in API requests and responses
+type Schedule struct {
+    Name       string          `json:"name"`
+    Cron       string          `json:"cron"`
+    Timezone   string          `json:"timezone,omitempty"`
+    Servers    ServerSelector  `json:"servers"`
+    Containers []string        `json:"containers,omitempty"`
+    Databases  []string        `json:"databases,omitempty"`
+    Options    DumpOptions     `json:"options"`
+    Retention  RetentionPolicy `json:"retention"`
+    Storage    string          `json:"storage,omitempty"`
+    Disabled   bool            `json:"disabled"`
+}
+
+// ScheduleRun represents the current or last run of a schedule
+type ScheduleRun struct {
+    Status     string     `json:"status"`
+    StartedAt  time.Time  `json:"started_at"`
+    FinishedAt *time.Time `json:"finished_at,omitempty"`
+    Backups    int        `json:"backups"`
+    Failed     int        `json:"failed"`
+    Errors     []string   `json:"errors,omitempty"`
+}
+
+// ScheduleResponse represents a schedule and its runs in API responses
+type ScheduleResponse struct {
+    Schedule
+    // ReadOnly is set for schedules of config.yaml, which cannot be changed
+    // through the API
+    ReadOnly bool         `json:"read_only"`
+    NextRun  *time.Time   `json:"next_run,omitempty"`
+    LastRun  *ScheduleRun `json:"last_run,omitempty"`
+}
+
+// HostKey represents an SSH host key
+type HostKey struct {
+    Type        string `json:"type"`
+    Fingerprint string `json:"fingerprint"`
+}
+
+// HostKeyResponse represents the host key verification state of a server
+type HostKeyResponse struct {
+    ServerID  string    `json:"server_id"`
+    Address   string    `json:"address"`
+    Source    string    `json:"source"`
+    Accepted  []HostKey `json:"accepted"`
+    Presented *HostKey  `json:"presented,omitempty"`
+    Matches   bool      `json:"matches"`
+    Error     string    `json:"error,omitempty"`
+}
+
+// RotateHostKeyRequest confirms the fingerprint of the new host key to accept
+type RotateHostKeyRequest struct {
+    Fingerprint string `json:"fingerprint" binding:"required"`
+}
+
+// LoginRequest represents a request to log in with a local user
+type LoginRequest struct {
+    Username string `json:"username" binding:"required"`
+    Password string `json:"password" binding:"required"`
+}
+
+// PrincipalResponse represents the authenticated caller in API responses
+type PrincipalResponse struct {
+    Name      string     `json:"name"`
+    Method    string     `json:"method"`
+    Roles     []string   `json:"roles"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// AuditEntry represents an event in the audit log. Each entry carries the
+// hash of the previous one, so that edits and deletions break the chain.
+type AuditEntry struct {
+    Seq       int64           `json:"seq"`
+    Time      time.Time       `json:"time"`
+    Actor     string          `json:"actor"`
+    SourceIP  string          `json:"source_ip"`
+    Action    string          `json:"action"`
+    ServerID  string          `json:"server_id,omitempty"`
+    Container string          `json:"container,omitempty"`
+    Database  string          `json:"database,omitempty"`
+    Options   json.RawMessage `json:"options,omitempty"`
+    Bytes     int64           `json:"bytes"`
+    Duration  string          `json:"duration"`
+    Outcome   string          `json:"outcome"`
+    Status    int             `json:"status"`
+    Error     string          `json:"error,omitempty"`
+    PrevHash  string          `json:"prev_hash"`
+    Hash      string          `json:"hash"`
 }
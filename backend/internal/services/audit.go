@@ -0,0 +1,312 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// Audited actions
+const (
+	AuditActionListServers    = "list_servers"
+	AuditActionListContainers = "list_containers"
+	AuditActionListDatabases  = "list_databases"
+	AuditActionDiscover       = "discover"
+	AuditActionDump           = "dump"
+	AuditActionRestore        = "restore"
+	AuditActionClone          = "clone"
+	AuditActionCreateJob      = "create_job"
+	AuditActionDownloadJob    = "download_job"
+	AuditActionCancelJob      = "cancel_job"
+	AuditActionCreateServer   = "create_server"
+	AuditActionUpdateServer   = "update_server"
+	AuditActionDeleteServer   = "delete_server"
+	AuditActionCreateSchedule = "create_schedule"
+	AuditActionUpdateSchedule = "update_schedule"
+	AuditActionDeleteSchedule = "delete_schedule"
+	AuditActionRunSchedule    = "run_schedule"
+	AuditActionBackup         = "scheduled_backup"
+	AuditActionDownloadBackup = "download_backup"
+	AuditActionVerifyBackup   = "verify_backup"
+)
+
+// Outcomes of audited actions
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDenied  = "denied"
+)
+
+// ErrAuditChainBroken is returned when the audit log does not verify, because
+// entries were edited, removed or reordered
+var ErrAuditChainBroken = errors.New("audit log hash chain is broken")
+
+// AuditFilter selects audit log entries. Empty fields match everything.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	ServerID  string
+	Container string
+	Database  string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	// Limit caps the number of entries returned, newest first
+	Limit int
+}
+
+// matches reports whether an entry is selected by the filter
+func (f AuditFilter) matches(entry models.AuditEntry) bool {
+	for _, field := range [][2]string{
+		{f.Actor, entry.Actor},
+		{f.Action, entry.Action},
+		{f.ServerID, entry.ServerID},
+		{f.Container, entry.Container},
+		{f.Database, entry.Database},
+		{f.Outcome, entry.Outcome},
+	} {
+		if field[0] != "" && field[0] != field[1] {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditLog is the append-only audit log. Entries are written as JSON lines,
+// each hashed together with the hash of the entry before it.
+type AuditLog struct {
+	path   string
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	file     *os.File
+	seq      int64
+	lastHash string
+
+	// verified is how far the hash chain is verified, so that Verify only
+	// needs to hash the entries added since
+	verified auditVerification
+}
+
+// auditVerification is the verified start of the audit log
+type auditVerification struct {
+	// offset is the length of the verified start of the file, ending with
+	// entry seq whose hash is hash
+	offset int64
+	seq    int64
+	hash   string
+	// err is the break found in the chain. The log is append-only, so once
+	// broken it stays broken.
+	err error
+}
+
+// NewAuditLog opens the audit log for appending, continuing the hash chain
+// of the entries already in it
+func NewAuditLog(cfg config.Audit, logger *logrus.Logger) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.File), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	l := &AuditLog{path: cfg.File, file: file, logger: logger}
+
+	entries, end, err := l.readEntries(0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := l.verified.extend(entries, end); err != nil {
+		// Refusing to start would stop auditing altogether, so the
+		// chain is continued and the break stays visible in queries
+		logger.Errorf("Audit log %s failed verification: %v", cfg.File, err)
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		l.seq = last.Seq
+		l.lastHash = last.Hash
+	}
+
+	return l, nil
+}
+
+// Record appends an entry to the audit log, assigning its sequence number
+// and hashes. The entry is synced to disk before Record returns.
+func (l *AuditLog) Record(entry models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	entry.Time = entry.Time.UTC()
+	entry.PrevHash = l.lastHash
+	hash, err := auditHash(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return nil
+}
+
+// Query returns the entries selected by filter and visible, newest first
+func (l *AuditLog) Query(filter AuditFilter, visible func(models.AuditEntry) bool) ([]models.AuditEntry, error) {
+	l.mu.Lock()
+	entries, _, err := l.readEntries(0)
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []models.AuditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(selected) >= filter.Limit {
+			break
+		}
+		if filter.matches(entries[i]) && visible(entries[i]) {
+			selected = append(selected, entries[i])
+		}
+	}
+	return selected, nil
+}
+
+// Verify checks the hash chain of the audit log. It returns an error
+// wrapping ErrAuditChainBroken if the log was tampered with. The whole chain
+// is verified on startup, after that only the entries added since the last
+// verification are, and a log that got shorter.
+func (l *AuditLog) Verify() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.verified.err != nil {
+		return l.verified.err
+	}
+
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	if info.Size() < l.verified.offset {
+		l.verified.err = fmt.Errorf("%w, the log was truncated", ErrAuditChainBroken)
+		return l.verified.err
+	}
+
+	entries, end, err := l.readEntries(l.verified.offset)
+	if err != nil {
+		return err
+	}
+	return l.verified.extend(entries, end)
+}
+
+// Close closes the audit log
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// readEntries reads the entries of the audit log from offset on, returning
+// them with the offset of the end of the file. Lines that do not decode are
+// returned as empty entries, so that verification reports them. The caller
+// must hold l.mu, so that no entry is half written.
+func (l *AuditLog) readEntries(offset int64) ([]models.AuditEntry, int64, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	var entries []models.AuditEntry
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry models.AuditEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				entry = models.AuditEntry{}
+			}
+			entries = append(entries, entry)
+		}
+		if err == io.EOF {
+			return entries, offset, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+}
+
+// extend verifies that every entry hashes to its recorded hash and links to
+// the entry before it, continuing the verified chain up to end
+func (v *auditVerification) extend(entries []models.AuditEntry, end int64) error {
+	seq, prevHash := v.seq, v.hash
+	for _, entry := range entries {
+		if entry.Seq != seq+1 || entry.PrevHash != prevHash {
+			v.err = fmt.Errorf("%w at line %d", ErrAuditChainBroken, seq+1)
+			return v.err
+		}
+		hash, err := auditHash(entry)
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			v.err = fmt.Errorf("%w at line %d", ErrAuditChainBroken, seq+1)
+			return v.err
+		}
+		seq, prevHash = entry.Seq, entry.Hash
+	}
+	v.offset, v.seq, v.hash = end, seq, prevHash
+	return nil
+}
+
+// auditHash hashes an entry with everything but its own hash. The previous
+// hash is part of the entry, which is what chains the entries together.
+func auditHash(entry models.AuditEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
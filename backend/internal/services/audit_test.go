@@ -0,0 +1,99 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+func testAuditLog(t *testing.T, path string) *AuditLog {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	l, err := NewAuditLog(config.Audit{File: path}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func recordAudit(t *testing.T, l *AuditLog, database string) {
+	t.Helper()
+	require.NoError(t, l.Record(models.AuditEntry{
+		Time:     time.Now(),
+		Actor:    "token:ci",
+		Action:   AuditActionDump,
+		ServerID: "prod-1",
+		Database: database,
+	}))
+}
+
+func TestAuditLogVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := testAuditLog(t, path)
+
+	recordAudit(t, l, "hr")
+	require.NoError(t, l.Verify())
+	recordAudit(t, l, "crm")
+	recordAudit(t, l, "billing")
+	require.NoError(t, l.Verify())
+	assert.Equal(t, int64(3), l.verified.seq)
+
+	// An edited entry added since the last verification is caught
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	recordAudit(t, l, "sales")
+	edited, err := os.ReadFile(path)
+	require.NoError(t, err)
+	edited = append(data, strings.Replace(string(edited[len(data):]), "sales", "other", 1)...)
+	require.NoError(t, os.WriteFile(path, edited, 0o600))
+	assert.ErrorIs(t, l.Verify(), ErrAuditChainBroken)
+
+	// A broken chain stays broken
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	assert.ErrorContains(t, l.Verify(), "at line 4")
+
+	// Starting over verifies the whole log
+	assert.NoError(t, testAuditLog(t, path).Verify())
+}
+
+func TestAuditLogVerifyTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := testAuditLog(t, path)
+	recordAudit(t, l, "hr")
+	recordAudit(t, l, "crm")
+	require.NoError(t, l.Verify())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	require.NoError(t, os.WriteFile(path, []byte(lines[0]), 0o600))
+	assert.ErrorContains(t, l.Verify(), "truncated")
+}
+
+func TestAuditLogVerifyOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := testAuditLog(t, path)
+	recordAudit(t, l, "hr")
+	recordAudit(t, l, "crm")
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(string(data), `"hr"`, `"xx"`, 1)), 0o600))
+
+	// The break is found on startup and reported by later verifications,
+	// while new entries continue the chain
+	l = testAuditLog(t, path)
+	recordAudit(t, l, "billing")
+	assert.ErrorContains(t, l.Verify(), "at line 1")
+}
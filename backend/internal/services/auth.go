@@ -0,0 +1,415 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+
+	"backend/internal/config"
+)
+
+// Authentication methods
+const (
+	AuthMethodToken   = "token"
+	AuthMethodSession = "session"
+	AuthMethodNone    = "none"
+)
+
+// SessionCookieName is the name of the cookie carrying the session of a local user
+const SessionCookieName = "pgm_session"
+
+var (
+	// ErrUnauthenticated is returned for requests without valid credentials
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrInvalidCredentials is returned for failed logins and unknown tokens
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated caller of the API
+type Principal struct {
+	Name      string
+	Method    string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+// ID identifies the caller across authentication methods, as tokens and
+// users may share names
+func (p *Principal) ID() string {
+	switch p.Method {
+	case AuthMethodToken:
+		return "token:" + p.Name
+	case AuthMethodSession:
+		return "user:" + p.Name
+	default:
+		return p.Name
+	}
+}
+
+// Authenticator authenticates API requests by one kind of credentials
+type Authenticator interface {
+	// Authenticate returns the caller of a request. It returns nil without
+	// an error if the request carries no credentials of its kind, and
+	// ErrInvalidCredentials if it carries invalid ones.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthService authenticates API requests with the configured authenticators
+// and authorizes them with role-based access control
+type AuthService struct {
+	sessions *SessionAuthenticator
+	logger   *logrus.Logger
+
+	mu             sync.RWMutex
+	config         config.Auth
+	authenticators []Authenticator
+	rbac           *RBAC
+}
+
+// NewAuthService creates the authenticators for static API tokens and local users
+func NewAuthService(cfg config.Auth, logger *logrus.Logger) (*AuthService, error) {
+	sessions, err := NewSessionAuthenticator(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &AuthService{sessions: sessions, logger: logger}
+	if err := s.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the tokens, users and roles. Sessions stay valid as long as
+// their user is still configured. The current settings stay in use if the
+// new ones are invalid.
+func (s *AuthService) Reload(cfg config.Auth) error {
+	rbac, err := NewRBAC(cfg.Roles)
+	if err != nil {
+		return err
+	}
+	for _, token := range cfg.Tokens {
+		if err := rbac.CheckRoles(token.Roles); err != nil {
+			return fmt.Errorf("API token %q: %w", token.Name, err)
+		}
+	}
+	for _, user := range cfg.Users {
+		if err := rbac.CheckRoles(user.Roles); err != nil {
+			return fmt.Errorf("user %q: %w", user.Username, err)
+		}
+	}
+
+	tokens, err := NewTokenAuthenticator(cfg.Tokens)
+	if err != nil {
+		return err
+	}
+	users, err := parseUsers(cfg.Users)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.Disabled:
+		s.logger.Warn("API authentication is disabled, anyone who can reach the API can use it")
+	case len(cfg.Tokens) == 0 && len(cfg.Users) == 0:
+		s.logger.Warn("No API tokens or users are configured, every API request will be rejected")
+	}
+
+	s.sessions.update(cfg, users)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = cfg
+	s.authenticators = []Authenticator{tokens, s.sessions}
+	s.rbac = rbac
+	return nil
+}
+
+// Authenticate returns the caller of a request, trying each authenticator in turn
+func (s *AuthService) Authenticate(r *http.Request) (*Principal, error) {
+	s.mu.RLock()
+	disabled, authenticators := s.config.Disabled, s.authenticators
+	s.mu.RUnlock()
+
+	if disabled {
+		return &Principal{Name: "anonymous", Method: AuthMethodNone}, nil
+	}
+
+	for _, authenticator := range authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+		if principal != nil {
+			return principal, nil
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Authorize reports whether a caller has a permission on a resource. With
+// authentication disabled everything is allowed.
+func (s *AuthService) Authorize(principal *Principal, permission string, resource Resource) bool {
+	if principal.Method == AuthMethodNone {
+		return true
+	}
+
+	s.mu.RLock()
+	rbac := s.rbac
+	s.mu.RUnlock()
+	return rbac.Allowed(principal.Roles, permission, resource)
+}
+
+// AuthorizeEverywhere reports whether a caller has a permission on every
+// server, container and database. With authentication disabled everything is
+// allowed.
+func (s *AuthService) AuthorizeEverywhere(principal *Principal, permission string) bool {
+	if principal.Method == AuthMethodNone {
+		return true
+	}
+
+	s.mu.RLock()
+	rbac := s.rbac
+	s.mu.RUnlock()
+	return rbac.AllowedEverywhere(principal.Roles, permission)
+}
+
+// Sessions returns the session store of local users
+func (s *AuthService) Sessions() *SessionAuthenticator {
+	return s.sessions
+}
+
+// TokenAuthenticator authenticates requests by static API tokens sent as
+// bearer tokens. Only hashes of the tokens are kept.
+type TokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]config.APIToken
+}
+
+// NewTokenAuthenticator creates a token authenticator from the configured token hashes
+func NewTokenAuthenticator(tokens []config.APIToken) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{tokens: make(map[[sha256.Size]byte]config.APIToken)}
+	for _, token := range tokens {
+		hash, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(token.Hash), "sha256:"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("API token %q: hash must be a hex encoded SHA-256 hash", token.Name)
+		}
+		if token.Name == "" {
+			return nil, fmt.Errorf("API token with hash %s has no name", token.Hash)
+		}
+		a.tokens[[sha256.Size]byte(hash)] = token
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+
+	// Looking up the hash rather than the token keeps the comparison
+	// independent of how much of a guessed token is right
+	apiToken, exists := a.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !exists {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: apiToken.Name, Method: AuthMethodToken, Roles: apiToken.Roles}, nil
+}
+
+// session is a logged in local user
+type session struct {
+	username  string
+	expiresAt time.Time
+}
+
+// SessionAuthenticator logs in local users and authenticates requests by
+// their session cookie. Sessions are kept in memory and end on restart.
+type SessionAuthenticator struct {
+	logger *logrus.Logger
+
+	// dummyHash is checked for unknown users, so that a login takes as
+	// long whether the user exists or not
+	dummyHash []byte
+	limiter   *loginLimiter
+
+	mu       sync.Mutex
+	users    map[string]config.User
+	ttl      time.Duration
+	secure   bool
+	sessions map[[sha256.Size]byte]session
+}
+
+// NewSessionAuthenticator creates a session authenticator for the configured users
+func NewSessionAuthenticator(cfg config.Auth, logger *logrus.Logger) (*SessionAuthenticator, error) {
+	users, err := parseUsers(cfg.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &SessionAuthenticator{
+		logger:   logger,
+		limiter:  newLoginLimiter(),
+		sessions: make(map[[sha256.Size]byte]session),
+	}
+	a.update(cfg, users)
+
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare password checks: %w", err)
+	}
+	a.dummyHash = dummyHash
+
+	return a, nil
+}
+
+// parseUsers checks the configured users and maps them by username
+func parseUsers(configured []config.User) (map[string]config.User, error) {
+	users := make(map[string]config.User)
+	for _, user := range configured {
+		if user.Username == "" {
+			return nil, fmt.Errorf("user without a username")
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %q: password_hash must be a bcrypt hash: %w", user.Username, err)
+		}
+		users[user.Username] = user
+	}
+	return users, nil
+}
+
+// update replaces the users and session settings
+func (a *SessionAuthenticator) update(cfg config.Auth, users map[string]config.User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	a.ttl = cfg.SessionTTL
+	a.secure = cfg.CookieSecure
+}
+
+// Login checks the password of a local user, logging in from the client
+// address, and starts a session. It returns the session ID to send as
+// cookie. Once the user or the address failed too often, logins are refused
+// with a LoginThrottledError for a growing time.
+func (a *SessionAuthenticator) Login(username, password, address string) (string, *Principal, error) {
+	userKey, addressKey := "user:"+username, "address:"+address
+	if err := a.limiter.attempt(userKey, addressKey); err != nil {
+		a.logger.Warnf("Refused login for user %q from %s: %v", username, address, err)
+		return "", nil, err
+	}
+
+	a.mu.Lock()
+	user, exists := a.users[username]
+	ttl := a.ttl
+	a.mu.Unlock()
+
+	hash := []byte(user.PasswordHash)
+	if !exists {
+		hash = a.dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !exists {
+		a.logger.Warnf("Failed login for user %q from %s", username, address)
+		return "", nil, ErrInvalidCredentials
+	}
+	a.limiter.succeeded(userKey, addressKey)
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+	expiresAt := time.Now().Add(ttl)
+
+	a.mu.Lock()
+	a.pruneExpired()
+	a.sessions[sha256.Sum256([]byte(id))] = session{username: username, expiresAt: expiresAt}
+	a.mu.Unlock()
+
+	a.logger.Infof("User %s logged in", username)
+	return id, &Principal{Name: username, Method: AuthMethodSession, Roles: user.Roles, ExpiresAt: expiresAt}, nil
+}
+
+// Logout ends the session of a request, if it has one
+func (a *SessionAuthenticator) Logout(r *http.Request) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, sha256.Sum256([]byte(cookie.Value)))
+}
+
+// Cookie returns the session cookie for a session ID. An empty ID returns
+// a cookie that clears the session cookie.
+func (a *SessionAuthenticator) Cookie(id string) *http.Cookie {
+	a.mu.Lock()
+	ttl, secure := a.ttl, a.secure
+	a.mu.Unlock()
+
+	cookie := &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secure,
+		// Strict keeps the cookie off cross-site requests, which is what
+		// protects the API from cross-site request forgery
+		SameSite: http.SameSiteStrictMode,
+	}
+	if id == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	return cookie
+}
+
+// Authenticate implements Authenticator
+func (a *SessionAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+
+	key := sha256.Sum256([]byte(cookie.Value))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, exists := a.sessions[key]
+	if !exists || time.Now().After(sess.expiresAt) {
+		delete(a.sessions, key)
+		return nil, ErrInvalidCredentials
+	}
+
+	// Users removed from the config lose their sessions as well
+	user, exists := a.users[sess.username]
+	if !exists {
+		delete(a.sessions, key)
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Name: sess.username, Method: AuthMethodSession, Roles: user.Roles, ExpiresAt: sess.expiresAt}, nil
+}
+
+// pruneExpired removes expired sessions. The caller must hold a.mu.
+func (a *SessionAuthenticator) pruneExpired() {
+	now := time.Now()
+	for key, sess := range a.sessions {
+		if now.After(sess.expiresAt) {
+			delete(a.sessions, key)
+		}
+	}
+}
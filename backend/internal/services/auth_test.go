@@ -0,0 +1,210 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"backend/internal/config"
+)
+
+// testAuthConfig returns an auth config with the token "ci-token" and the
+// user alice with the password "secret"
+func testAuthConfig(t *testing.T) config.Auth {
+	t.Helper()
+	tokenHash := sha256.Sum256([]byte("ci-token"))
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	return config.Auth{
+		SessionTTL: time.Hour,
+		Tokens:     []config.APIToken{{Name: "ci", Hash: "sha256:" + hex.EncodeToString(tokenHash[:]), Roles: []string{"viewer"}}},
+		Users:      []config.User{{Username: "alice", PasswordHash: string(passwordHash), Roles: []string{AdminRole}}},
+		Roles:      []config.Role{{Name: "viewer", Permissions: []string{PermissionList}}},
+	}
+}
+
+func testAuthService(t *testing.T, cfg config.Auth) *AuthService {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s, err := NewAuthService(cfg, logger)
+	require.NoError(t, err)
+	return s
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a, err := NewTokenAuthenticator(testAuthConfig(t).Tokens)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		principal     string
+		err           error
+	}{
+		{"no header", "", "", nil},
+		{"other scheme", "Basic Y2k6Y2ktdG9rZW4=", "", nil},
+		{"valid token", "Bearer ci-token", "ci", nil},
+		{"surrounding space", "Bearer  ci-token ", "ci", nil},
+		{"unknown token", "Bearer ci-token2", "", ErrInvalidCredentials},
+		{"empty token", "Bearer ", "", ErrInvalidCredentials},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/servers", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			principal, err := a.Authenticate(r)
+			assert.ErrorIs(t, err, tc.err)
+			if tc.principal == "" {
+				assert.Nil(t, principal)
+				return
+			}
+			require.NotNil(t, principal)
+			assert.Equal(t, tc.principal, principal.Name)
+			assert.Equal(t, AuthMethodToken, principal.Method)
+			assert.Equal(t, []string{"viewer"}, principal.Roles)
+		})
+	}
+}
+
+func TestNewTokenAuthenticatorErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		token config.APIToken
+		err   string
+	}{
+		{"not hex", config.APIToken{Name: "ci", Hash: "sha256:xyz"}, "hex encoded SHA-256"},
+		{"wrong length", config.APIToken{Name: "ci", Hash: "abcd"}, "hex encoded SHA-256"},
+		{"no name", config.APIToken{Hash: hex.EncodeToString(make([]byte, sha256.Size))}, "has no name"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewTokenAuthenticator([]config.APIToken{tc.token})
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestSessionAuthenticator(t *testing.T) {
+	s := testAuthService(t, testAuthConfig(t))
+	sessions := s.Sessions()
+
+	withCookie := func(id string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/servers", nil)
+		r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: id})
+		return r
+	}
+
+	_, _, err := sessions.Login("alice", "wrong", "192.0.2.1")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, _, err = sessions.Login("mallory", "secret", "192.0.2.1")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	id, principal, err := sessions.Login("alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, "user:alice", principal.ID())
+
+	// No cookie is no credential, an unknown one an invalid credential
+	principal, err = sessions.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, principal)
+	_, err = sessions.Authenticate(withCookie("unknown"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	principal, err = sessions.Authenticate(withCookie(id))
+	require.NoError(t, err)
+	assert.Equal(t, AuthMethodSession, principal.Method)
+	assert.Equal(t, []string{AdminRole}, principal.Roles)
+
+	// Removing the user from the config ends the session
+	cfg := testAuthConfig(t)
+	cfg.Users = nil
+	require.NoError(t, s.Reload(cfg))
+	_, err = sessions.Authenticate(withCookie(id))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestSessionAuthenticatorLogout(t *testing.T) {
+	sessions := testAuthService(t, testAuthConfig(t)).Sessions()
+	id, _, err := sessions.Login("alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", nil)
+	r.AddCookie(sessions.Cookie(id))
+	sessions.Logout(r)
+
+	_, err = sessions.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, -1, sessions.Cookie("").MaxAge)
+}
+
+func TestAuthServiceAuthenticate(t *testing.T) {
+	s := testAuthService(t, testAuthConfig(t))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/servers", nil)
+	_, err := s.Authenticate(r)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	r.Header.Set("Authorization", "Bearer ci-token")
+	principal, err := s.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, "token:ci", principal.ID())
+
+	// An invalid token is not retried as a session
+	r.Header.Set("Authorization", "Bearer nope")
+	_, err = s.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	cfg := testAuthConfig(t)
+	cfg.Disabled = true
+	require.NoError(t, s.Reload(cfg))
+	principal, err = s.Authenticate(httptest.NewRequest(http.MethodGet, "/api/v1/servers", nil))
+	require.NoError(t, err)
+	assert.Equal(t, AuthMethodNone, principal.Method)
+}
+
+func TestAuthServiceReload(t *testing.T) {
+	s := testAuthService(t, testAuthConfig(t))
+
+	cfg := testAuthConfig(t)
+	cfg.Tokens[0].Roles = []string{"editor"}
+	assert.ErrorContains(t, s.Reload(cfg), `API token "ci": unknown role "editor"`)
+
+	cfg = testAuthConfig(t)
+	cfg.Users[0].Roles = []string{"editor"}
+	assert.ErrorContains(t, s.Reload(cfg), `user "alice": unknown role "editor"`)
+
+	// The previous settings stay in use
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/servers", nil)
+	r.Header.Set("Authorization", "Bearer ci-token")
+	principal, err := s.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, principal.Roles)
+}
+
+func TestAuthServiceAuthorize(t *testing.T) {
+	s := testAuthService(t, testAuthConfig(t))
+	token := &Principal{Name: "ci", Method: AuthMethodToken, Roles: []string{"viewer"}}
+	user := &Principal{Name: "alice", Method: AuthMethodSession, Roles: []string{AdminRole}}
+	anonymous := &Principal{Name: "anonymous", Method: AuthMethodNone}
+	resource := Resource{ServerID: "prod-1", Database: "hr"}
+
+	assert.True(t, s.Authorize(token, PermissionList, resource))
+	assert.False(t, s.Authorize(token, PermissionDump, resource))
+	assert.False(t, s.AuthorizeEverywhere(token, PermissionAdmin))
+	assert.True(t, s.Authorize(user, PermissionRestore, resource))
+	assert.True(t, s.AuthorizeEverywhere(user, PermissionAdmin))
+	assert.True(t, s.Authorize(anonymous, PermissionAdmin, resource))
+	assert.True(t, s.AuthorizeEverywhere(anonymous, PermissionAdmin))
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/utils"
+)
+
+var (
+	// ErrBackupNotFound is returned for unknown backup IDs
+	ErrBackupNotFound = errors.New("backup not found")
+	// ErrChecksumMismatch is returned when a stored backup differs from the
+	// checksum recorded when it was taken
+	ErrChecksumMismatch = errors.New("stored backup does not match its checksum")
+)
+
+// BackupFilter selects backups of the catalog. Empty fields match
+// everything.
+type BackupFilter struct {
+	ServerID string
+	// Container matches the container ID or name, @host for host PostgreSQL
+	Container string
+	Database  string
+	Storage   string
+	Owner     string
+	Format    string
+	// Since and Until bound the start of the backups
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of backups returned, newest first
+	Limit int
+}
+
+// matches reports whether a backup is selected by the filter
+func (f BackupFilter) matches(backup models.Backup) bool {
+	for _, field := range [][2]string{
+		{f.ServerID, backup.ServerID},
+		{f.Database, backup.Database},
+		{f.Storage, backup.Storage},
+		{f.Owner, backup.Owner},
+		{f.Format, backup.Format},
+	} {
+		if field[0] != "" && field[0] != field[1] {
+			return false
+		}
+	}
+	if f.Container != "" && f.Container != backup.ContainerID && f.Container != backup.ContainerName {
+		return false
+	}
+	if !f.Since.IsZero() && backup.StartedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && backup.StartedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// BackupCatalog records the backups written to storage with their metadata.
+// It is kept in memory and rewritten to a JSON file on every change.
+type BackupCatalog struct {
+	path   string
+	logger *logrus.Logger
+
+	mu      sync.RWMutex
+	backups []models.Backup
+}
+
+// NewBackupCatalog loads the catalog file of the configuration, starting an
+// empty catalog if there is none yet
+func NewBackupCatalog(cfg config.Backups, logger *logrus.Logger) (*BackupCatalog, error) {
+	c := &BackupCatalog{path: cfg.CatalogFile, logger: logger}
+
+	data, err := os.ReadFile(cfg.CatalogFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup catalog: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.backups); err != nil {
+		return nil, fmt.Errorf("failed to parse backup catalog %s: %w", cfg.CatalogFile, err)
+	}
+
+	logger.Infof("Loaded %d backups from catalog %s", len(c.backups), cfg.CatalogFile)
+	return c, nil
+}
+
+// Add records a backup under a new ID. A backup already recorded for the
+// same storage and key is replaced.
+func (c *BackupCatalog) Add(backup models.Backup) (models.Backup, error) {
+	id, err := newJobID()
+	if err != nil {
+		return models.Backup{}, err
+	}
+	backup.ID = id
+	backup.StartedAt = backup.StartedAt.UTC()
+	backup.FinishedAt = backup.FinishedAt.UTC()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	backups := make([]models.Backup, 0, len(c.backups)+1)
+	for _, existing := range c.backups {
+		if existing.Storage != backup.Storage || existing.Key != backup.Key {
+			backups = append(backups, existing)
+		}
+	}
+	backups = append(backups, backup)
+
+	if err := c.save(backups); err != nil {
+		return models.Backup{}, err
+	}
+	c.backups = backups
+	return backup, nil
+}
+
+// Get returns a backup by its ID
+func (c *BackupCatalog) Get(id string) (models.Backup, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, backup := range c.backups {
+		if backup.ID == id {
+			return backup, nil
+		}
+	}
+	return models.Backup{}, ErrBackupNotFound
+}
+
+// SetVerification records the result of a verification of a backup
+func (c *BackupCatalog) SetVerification(id string, verification models.BackupVerification) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	backups := make([]models.Backup, len(c.backups))
+	copy(backups, c.backups)
+	for i := range backups {
+		if backups[i].ID == id {
+			backups[i].Verification = &verification
+			if err := c.save(backups); err != nil {
+				return err
+			}
+			c.backups = backups
+			return nil
+		}
+	}
+	return ErrBackupNotFound
+}
+
+// Remove forgets the backup at key in a storage, if it is recorded
+func (c *BackupCatalog) Remove(storage, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	backups := make([]models.Backup, 0, len(c.backups))
+	for _, backup := range c.backups {
+		if backup.Storage != storage || backup.Key != key {
+			backups = append(backups, backup)
+		}
+	}
+	if len(backups) == len(c.backups) {
+		return nil
+	}
+
+	if err := c.save(backups); err != nil {
+		return err
+	}
+	c.backups = backups
+	return nil
+}
+
+// Query returns the backups selected by filter and visible, newest first
+func (c *BackupCatalog) Query(filter BackupFilter, visible func(models.Backup) bool) []models.Backup {
+	c.mu.RLock()
+	backups := make([]models.Backup, len(c.backups))
+	copy(backups, c.backups)
+	c.mu.RUnlock()
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].StartedAt.After(backups[j].StartedAt)
+	})
+
+	var selected []models.Backup
+	for _, backup := range backups {
+		if filter.Limit > 0 && len(selected) >= filter.Limit {
+			break
+		}
+		if filter.matches(backup) && visible(backup) {
+			selected = append(selected, backup)
+		}
+	}
+	return selected
+}
+
+// save writes backups to the catalog file, replacing it atomically so a
+// crash never leaves a truncated catalog behind
+func (c *BackupCatalog) save(backups []models.Backup) error {
+	data, err := json.MarshalIndent(backups, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup catalog: %w", err)
+	}
+	if err := utils.WriteFileAtomic(c.path, data); err != nil {
+		return fmt.Errorf("failed to write backup catalog: %w", err)
+	}
+	return nil
+}
+
+// ChecksumReader passes a stored backup through while hashing it, and fails
+// at its end when the SHA-256 differs from the one in the catalog. The last
+// byte is held back then, so a download of known size ends short instead
+// of delivering a corrupt backup as if it were complete.
+type ChecksumReader struct {
+	r        *bufio.Reader
+	hash     hash.Hash
+	expected string
+}
+
+// NewChecksumReader returns a reader checking r against the hex encoded
+// SHA-256 expected
+func NewChecksumReader(r io.Reader, expected string) *ChecksumReader {
+	return &ChecksumReader{r: bufio.NewReader(r), hash: sha256.New(), expected: expected}
+}
+
+func (r *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	if err == nil && n > 0 {
+		// Look ahead to check the hash before the last data is returned
+		_, err = r.r.Peek(1)
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != r.expected {
+		return max(n-1, 0), fmt.Errorf("%w: SHA-256 is %s instead of %s", ErrChecksumMismatch, sum, r.expected)
+	}
+	return n, io.EOF
+}
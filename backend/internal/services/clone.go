@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// DatabaseTarget identifies a database on a resolved server. An empty
+// ContainerID refers to PostgreSQL installed on the host.
+type DatabaseTarget struct {
+	Server      *config.Server
+	ContainerID string
+	Database    string
+}
+
+// CloneDatabase streams a custom format dump of the source database straight
+// into a restore on the target, without storing it anywhere in between. The
+// number of bytes moved so far is published through transferred.
+func (s *PostgresService) CloneDatabase(ctx context.Context, source, target DatabaseTarget, dumpOptions models.DumpOptions, restoreOptions models.RestoreOptions, sshService *SSHService, transferred *atomic.Int64) (string, error) {
+	s.logger.Infof("Cloning database %s on server %s into %s on server %s", source.Database, source.Server.ID, target.Database, target.Server.ID)
+
+	// Custom format lets pg_restore clean and run parallel jobs on the target
+	dumpOptions.Format = models.DumpFormatCustom
+	dumpOptions.Compression = models.CompressionNone
+	dumpOptions.Encryption = models.EncryptionNone
+	restoreOptions.Format = models.DumpFormatCustom
+	if err := ValidateRestoreOptions(restoreOptions); err != nil {
+		return "", err
+	}
+
+	var dumpReader io.ReadCloser
+	var err error
+	if source.ContainerID != "" {
+		dumpReader, err = s.CreateDumpViaSSH(ctx, source.Server, source.ContainerID, source.Database, dumpOptions, sshService)
+	} else {
+		dumpReader, err = s.CreateHostDumpViaSSH(ctx, source.Server, source.Database, dumpOptions, sshService)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to start source dump: %w", err)
+	}
+
+	input := &countingReader{Reader: dumpReader, count: transferred}
+
+	var output string
+	if target.ContainerID != "" {
+		output, err = s.RestoreDumpViaSSH(ctx, target.Server, target.ContainerID, target.Database, input, restoreOptions, sshService)
+	} else {
+		output, err = s.RestoreHostDumpViaSSH(ctx, target.Server, target.Database, input, restoreOptions, sshService)
+	}
+
+	// Closing the dump also stops it if the restore gave up early
+	dumpErr := dumpReader.Close()
+	if err != nil {
+		return output, err
+	}
+	if dumpErr != nil {
+		return output, fmt.Errorf("source dump failed: %w", dumpErr)
+	}
+
+	s.logger.Infof("Cloned database %s (%d bytes)", source.Database, transferred.Load())
+	return output, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	count *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count.Add(int64(n))
+	return n, err
+}
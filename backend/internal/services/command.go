@@ -0,0 +1,85 @@
+package services
+
+import (
+	"strings"
+)
+
+// Command is a program invocation kept as an argument vector. It only
+// becomes shell text through String, which quotes every argument, so values
+// from requests or configuration can never be interpreted by the shell
+// commands are run through, locally or over SSH.
+type Command []string
+
+// NewCommand creates a command running name with args
+func NewCommand(name string, args ...string) Command {
+	return append(Command{name}, args...)
+}
+
+// With returns a copy of the command with args appended
+func (c Command) With(args ...string) Command {
+	cmd := make(Command, 0, len(c)+len(args))
+	cmd = append(cmd, c...)
+	return append(cmd, args...)
+}
+
+// String renders the command as a single line for a POSIX shell
+func (c Command) String() string {
+	words := make([]string, len(c))
+	for i, arg := range c {
+		words[i] = quoteArg(arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// dockerExec wraps a command to run inside a container. Interactive commands
+// get the container's stdin attached. No TTY is ever allocated, as it would
+// rewrite line endings in dump streams.
+func dockerExec(containerID string, interactive bool, cmd Command) Command {
+	docker := NewCommand("docker", "exec")
+	if interactive {
+		docker = docker.With("-i")
+	}
+	return docker.With(containerID).With(cmd...)
+}
+
+// sudoAs wraps a command to run as another OS user
+func sudoAs(user string, cmd Command) Command {
+	return NewCommand("sudo", "-u", user).With(cmd...)
+}
+
+// shellScript wraps a script to run through sh, for pipelines and scratch
+// files that a plain argument vector cannot express. Values embedded in the
+// script must be quoted with quoteArg or come from Command.String.
+func shellScript(script string) Command {
+	return NewCommand("sh", "-c", script)
+}
+
+// databaseArg renders a database name as the value of a -d option. libpq
+// reads a value containing "=" as a connection string, which a database name
+// could use to point the connection elsewhere, so the name is always passed
+// as the quoted dbname of a connection string, which is not expanded again.
+func databaseArg(name string) string {
+	return "dbname='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name) + "'"
+}
+
+// quoteArg quotes an argument for a POSIX shell, leaving arguments made of
+// characters without special meaning as they are for readable logs
+func quoteArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	for _, char := range arg {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9':
+		case strings.ContainsRune("-_./=:,@%+", char):
+		default:
+			return shellQuote(arg)
+		}
+	}
+	return arg
+}
+
+// shellQuote quotes a string for safe use as a single POSIX shell word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
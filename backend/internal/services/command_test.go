@@ -0,0 +1,102 @@
+package services
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteArg(t *testing.T) {
+	for _, tc := range []struct {
+		arg    string
+		quoted string
+	}{
+		{"", "''"},
+		{"pg_dump", "pg_dump"},
+		{"--format=custom", "--format=custom"},
+		{"user@host:5432,a+b%", "user@host:5432,a+b%"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"$(reboot)", "'$(reboot)'"},
+		{"`id`", "'`id`'"},
+		{"a;b|c&d", "'a;b|c&d'"},
+		{"*", "'*'"},
+		{"~", "'~'"},
+		{"line\nbreak", "'line\nbreak'"},
+		{`back\slash`, `'back\slash'`},
+		{"ünïcode", "'ünïcode'"},
+	} {
+		t.Run(tc.arg, func(t *testing.T) {
+			assert.Equal(t, tc.quoted, quoteArg(tc.arg))
+		})
+	}
+}
+
+func TestCommandStringShellRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no POSIX shell available")
+	}
+
+	args := []string{
+		"plain", "", "two words", "it's", `"double"`, "$HOME", "$(echo pwned)", "`echo pwned`",
+		"a;b", "a|b", "a&&b", "a > /tmp/x", "*", "?", "[a]", "~", "#comment", "line\nbreak",
+		`back\slash`, "'", "''", `'\''`, "dbname='x' host=evil", "--flag=value", "-",
+	}
+	// printf prints every argument terminated by a NUL, which no argument
+	// contains
+	script := NewCommand("printf", `%s\0`).With(args...).String()
+	output, err := exec.Command(sh, "-c", script).Output()
+	require.NoError(t, err)
+
+	got := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	assert.Equal(t, args, got)
+}
+
+func TestCommandWrappers(t *testing.T) {
+	psql := NewCommand("psql", "-d", databaseArg("app db"), "-tA")
+
+	for _, tc := range []struct {
+		name string
+		cmd  Command
+		want string
+	}{
+		{"docker exec", dockerExec("26b181849372", false, psql), `docker exec 26b181849372 psql -d 'dbname='\''app db'\''' -tA`},
+		{"interactive docker exec", dockerExec("26b181849372", true, psql), `docker exec -i 26b181849372 psql -d 'dbname='\''app db'\''' -tA`},
+		{"sudo", sudoAs("postgres", psql), `sudo -u postgres psql -d 'dbname='\''app db'\''' -tA`},
+		{"shell script", shellScript("cat > " + quoteArg("/tmp/a b")), `sh -c 'cat > '\''/tmp/a b'\'''`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.cmd.String())
+		})
+	}
+
+	// With never changes the command it extends
+	base := NewCommand("pg_dump", "-U", "postgres")
+	first := base.With("-d", "a")
+	second := base.With("-d", "b")
+	assert.Equal(t, Command{"pg_dump", "-U", "postgres"}, base)
+	assert.Equal(t, Command{"pg_dump", "-U", "postgres", "-d", "a"}, first)
+	assert.Equal(t, Command{"pg_dump", "-U", "postgres", "-d", "b"}, second)
+}
+
+func TestDatabaseArg(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"app", `dbname='app'`},
+		{"host=evil user=x", `dbname='host=evil user=x'`},
+		{"it's", `dbname='it\'s'`},
+		{`back\slash`, `dbname='back\\slash'`},
+		{`x' host='evil`, `dbname='x\' host=\'evil'`},
+		{"", `dbname=''`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, databaseArg(tc.name))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"backend/internal/models"
+)
+
+// ParseCompression normalizes a compression algorithm name and validates the
+// requested level for it. A level of 0 is no level at all and selects the
+// algorithm's default, any other level must be in the algorithm's range.
+func ParseCompression(algorithm string, level int) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(algorithm)) {
+	case "", models.CompressionNone:
+		if level != 0 {
+			return "", fmt.Errorf("compression level requires a compression algorithm")
+		}
+		return models.CompressionNone, nil
+	case "gz", models.CompressionGzip:
+		if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+			return "", fmt.Errorf("gzip compression level must be between %d and %d", gzip.BestSpeed, gzip.BestCompression)
+		}
+		return models.CompressionGzip, nil
+	case "zst", models.CompressionZstd:
+		if level != 0 && (level < 1 || level > 22) {
+			return "", fmt.Errorf("zstd compression level must be between 1 and 22")
+		}
+		return models.CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("unsupported compression %q", algorithm)
+	}
+}
+
+// CompressionExtension returns the file extension suffix for a compression algorithm
+func CompressionExtension(algorithm string) string {
+	switch algorithm {
+	case models.CompressionGzip:
+		return ".gz"
+	case models.CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// CompressStream wraps a dump stream so that reads return the compressed
+// bytes. Closing the returned reader closes the source, so the exit status of
+// the underlying dump command is still reported. The source is closed if the
+// compressor cannot be created.
+func CompressStream(src io.ReadCloser, algorithm string, level int) (io.ReadCloser, error) {
+	if algorithm == "" || algorithm == models.CompressionNone {
+		return src, nil
+	}
+
+	pr, pw := io.Pipe()
+
+	var encoder io.WriteCloser
+	switch algorithm {
+	case models.CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gz, err := gzip.NewWriterLevel(pw, level)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		encoder = gz
+	case models.CompressionZstd:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		zw, err := zstd.NewWriter(pw, zstd.WithEncoderLevel(encoderLevel))
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		encoder = zw
+	default:
+		src.Close()
+		return nil, fmt.Errorf("unsupported compression %q", algorithm)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.Copy(encoder, src)
+		if closeErr := encoder.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return &compressedReader{
+		PipeReader: pr,
+		src:        src,
+		done:       done,
+	}, nil
+}
+
+// compressedReader streams compressed output and cleans up the source stream
+type compressedReader struct {
+	*io.PipeReader
+	src  io.ReadCloser
+	done chan struct{}
+}
+
+// Close stops the compressor and closes the source stream
+func (r *compressedReader) Close() error {
+	r.PipeReader.Close()
+	err := r.src.Close()
+	<-r.done
+	return err
+}
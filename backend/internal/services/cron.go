@@ -0,0 +1,181 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands accepted in place of a cron expression
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// CronSchedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week
+type CronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// Like in cron, a day matches either field when both day of month and
+	// day of week are restricted
+	daysRestricted, weekdaysRestricted bool
+}
+
+// ParseCron parses a cron expression. Fields accept "*", numbers, ranges
+// ("1-5"), steps ("*/15", "0-30/10") and lists of them, months and days of
+// the week also by their English abbreviations. Sunday is 0 or 7.
+func ParseCron(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, exists := cronMacros[strings.ToLower(expression)]; exists {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expression)
+	}
+
+	var s CronSchedule
+	var err error
+	if s.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if s.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	if s.weekdays, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week field: %w", err)
+	}
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.daysRestricted = !strings.HasPrefix(fields[2], "*")
+	s.weekdaysRestricted = !strings.HasPrefix(fields[4], "*")
+
+	return &s, nil
+}
+
+// parseCronField parses a comma separated cron field into a bit set of the
+// values it matches. names, if given, name the values from min on.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseCronValue(lowPart, min, max, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseCronValue(highPart, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end in steps of 15
+				high = max
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// parseCronValue parses a number or name within a cron field
+func parseCronValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + i, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("value %q is not between %d and %d", value, min, max)
+	}
+	return number, nil
+}
+
+// Matches reports whether the schedule fires in the minute of t
+func (s *CronSchedule) Matches(t time.Time) bool {
+	return s.minutes&(1<<t.Minute()) != 0 &&
+		s.hours&(1<<t.Hour()) != 0 &&
+		s.months&(1<<int(t.Month())) != 0 &&
+		s.matchesDay(t)
+}
+
+// matchesDay reports whether the schedule fires on the day of t
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// Next returns the first minute after t the schedule fires in, in the time
+// zone of t. It returns the zero time if the schedule never fires, like on
+// February 30th. Times skipped when clocks go forward are not fired in.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	// Every combination of month, day and weekday comes around within
+	// a few years
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		var skip time.Time
+		switch {
+		case s.months&(1<<int(next.Month())) == 0 || !s.matchesDay(next):
+			skip = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case s.hours&(1<<next.Hour()) == 0:
+			// Adding minutes rather than using time.Date steps over the
+			// hour skipped when clocks go forward
+			skip = next.Add(time.Duration(60-next.Minute()) * time.Minute)
+		case s.Matches(next):
+			return next
+		default:
+			skip = next.Add(time.Minute)
+		}
+		// time.Date returns an earlier time for a midnight skipped when
+		// clocks go forward, the day then starts an hour later
+		if !skip.After(next) {
+			skip = next.Add(time.Hour)
+		}
+		next = skip
+	}
+	return time.Time{}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expression string
+		err        string
+	}{
+		{"* * * *", "must have 5 fields"},
+		{"* * * * * *", "must have 5 fields"},
+		{"@fortnightly", "must have 5 fields"},
+		{"60 * * * *", "invalid minute field"},
+		{"* 24 * * *", "invalid hour field"},
+		{"* * 0 * *", "invalid day of month field"},
+		{"* * 32 * *", "invalid day of month field"},
+		{"* * * 13 * ", "invalid month field"},
+		{"* * * foo *", "invalid month field"},
+		{"* * * * 8", "invalid day of week field"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"30-10 * * * *", "invalid range"},
+		{"1,,2 * * * *", "invalid minute field"},
+	} {
+		t.Run(tc.expression, func(t *testing.T) {
+			_, err := ParseCron(tc.expression)
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	for _, tc := range []struct {
+		name       string
+		expression string
+		from       string
+		next       string
+	}{
+		{"every quarter hour", "*/15 * * * *", "2024-09-10 10:07:00", "2024-09-10 10:15:00"},
+		{"strictly after", "*/15 * * * *", "2024-09-10 10:15:00", "2024-09-10 10:30:00"},
+		{"seconds are ignored", "* * * * *", "2024-09-10 10:15:59", "2024-09-10 10:16:00"},
+		{"list and range", "0 9-17/4,22 * * *", "2024-09-10 17:30:00", "2024-09-10 22:00:00"},
+		{"step from a value", "5/20 * * * *", "2024-09-10 10:46:00", "2024-09-10 11:05:00"},
+		{"next month", "0 0 * * *", "2024-01-31 23:59:30", "2024-02-01 00:00:00"},
+		{"@hourly", "@hourly", "2024-09-10 10:00:00", "2024-09-10 11:00:00"},
+		{"@daily", "@daily", "2024-09-10 10:00:00", "2024-09-11 00:00:00"},
+		{"@weekly", "@WEEKLY", "2024-09-10 10:00:00", "2024-09-15 00:00:00"},
+		{"@monthly", "@monthly", "2024-09-10 10:00:00", "2024-10-01 00:00:00"},
+		{"@yearly", "@yearly", "2024-09-10 10:00:00", "2025-01-01 00:00:00"},
+		{"month by name", "0 0 1 jan *", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+		{"weekdays by name", "0 8 * * mon-fri", "2024-09-13 09:00:00", "2024-09-16 08:00:00"},
+		{"sunday as 7", "0 0 * * 7", "2024-09-02 00:00:00", "2024-09-08 00:00:00"},
+		{"sunday as 0", "0 0 * * 0", "2024-09-02 00:00:00", "2024-09-08 00:00:00"},
+		{"day of month only", "0 12 13 * *", "2024-09-01 00:00:00", "2024-09-13 12:00:00"},
+		{"day of week only", "0 12 * * 5", "2024-09-01 00:00:00", "2024-09-06 12:00:00"},
+		{"day of month or week", "0 12 13 * 5", "2024-09-01 00:00:00", "2024-09-06 12:00:00"},
+		{"day of month or week, month first", "0 12 13 * 5", "2024-09-07 00:00:00", "2024-09-13 12:00:00"},
+		// Like in cron, a field starting with "*" does not count as restricted
+		{"day of month and week", "0 12 */10 * 5", "2024-09-01 00:00:00", "2024-10-11 12:00:00"},
+		{"leap day", "0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"never", "0 0 30 2 *", "2024-03-01 00:00:00", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cron, err := ParseCron(tc.expression)
+			require.NoError(t, err)
+
+			next := cron.Next(at(tc.from))
+			if tc.next == "" {
+				assert.True(t, next.IsZero(), "expected no next time, got %s", next)
+				return
+			}
+			assert.Equal(t, at(tc.next), next)
+			assert.True(t, cron.Matches(next))
+		})
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	for _, tc := range []struct {
+		name       string
+		expression string
+		from       string
+		next       string
+		elapsed    time.Duration
+	}{
+		// Clocks go forward from 02:00 to 03:00 on 2024-03-10
+		{"after clocks go forward", "0 3 * * *", "2024-03-09 12:00", "2024-03-10 03:00", 14 * time.Hour},
+		{"skipped time", "30 2 * * *", "2024-03-09 12:00", "2024-03-11 02:30", 37*time.Hour + 30*time.Minute},
+		{"same wall time", "0 12 * * *", "2024-03-09 12:00", "2024-03-10 12:00", 23 * time.Hour},
+		// Clocks go back from 02:00 to 01:00 on 2024-11-03
+		{"after clocks go back", "0 12 * * *", "2024-11-02 12:00", "2024-11-03 12:00", 25 * time.Hour},
+		{"hourly through the change", "0 * * * *", "2024-11-03 00:30", "2024-11-03 01:00", 30 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cron, err := ParseCron(tc.expression)
+			require.NoError(t, err)
+
+			from := at(tc.from)
+			next := cron.Next(from)
+			assert.Equal(t, at(tc.next), next)
+			assert.Equal(t, tc.elapsed, next.Sub(from))
+			assert.Equal(t, location, next.Location())
+		})
+	}
+}
+
+func TestCronNextSkippedMidnight(t *testing.T) {
+	// Clocks go forward from 00:00 to 01:00 on 2024-09-08
+	location, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	cron, err := ParseCron("0 6 * * *")
+	require.NoError(t, err)
+
+	from := time.Date(2024, 9, 7, 12, 0, 0, 0, location)
+	next := cron.Next(from)
+	assert.Equal(t, time.Date(2024, 9, 8, 6, 0, 0, 0, location), next)
+	assert.Equal(t, 17*time.Hour, next.Sub(from))
+}
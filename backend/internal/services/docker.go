@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os/exec"
 	"strings"
 	"time"
 
@@ -52,15 +53,15 @@ func (s *DockerService) GetDockerClient(host string) (*client.Client, error) {
 
 func (s *DockerService) GetPostgreSQLContainers(ctx context.Context, server *config.Server, sshService *SSHService) ([]models.ContainerResponse, error) {
     // For local servers, use direct Docker API
-    if server.Host == "localhost" || server.Host == "127.0.0.1" || server.Host == "" {
+    if server.IsLocal() {
         return s.getLocalContainers(ctx)
     }
 
     // For remote servers, use SSH to execute docker commands
-    dockerCmd := `docker ps --format "{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Ports}}"`
-    
+    dockerCmd := NewCommand("docker", "ps", "--format", `{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Ports}}`)
+
     s.logger.Infof("Getting PostgreSQL containers from remote server: %s@%s", server.Username, server.Host)
-    output, err := sshService.ExecuteRemoteCommand(server, dockerCmd) // Pass server config instead of just host
+    output, err := sshService.ExecuteRemoteCommand(ctx, server, dockerCmd.String()) // Pass server config instead of just host
     if err != nil {
         return nil, fmt.Errorf("failed to get containers from %s: %w", server.Host, err)
     }
@@ -332,3 +333,67 @@ func (s *DockerService) GetContainerInfo(ctx context.Context, dockerHost, contai
 
 	return &containerInfo, nil
 }
+
+// RunContainer starts a detached container from image on a server, without
+// a network and removed once it stops. A container left over under the same
+// name is removed first. It returns the ID of the new container.
+func (s *DockerService) RunContainer(ctx context.Context, server *config.Server, name, image string, env []string, sshService *SSHService) (string, error) {
+	if err := s.RemoveContainer(ctx, server, name, sshService); err != nil {
+		return "", err
+	}
+
+	cmd := NewCommand("docker", "run", "-d", "--rm", "--network", "none", "--name", name)
+	for _, value := range env {
+		cmd = cmd.With("-e", value)
+	}
+	cmd = cmd.With(image)
+
+	s.logger.Infof("Starting container %s from %s on server %s", name, image, server.ID)
+	output, err := s.runDocker(ctx, server, cmd, nil, sshService)
+	if err != nil {
+		return "", fmt.Errorf("failed to start container %s: %w", name, err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// RemoveContainer force removes a container on a server. Containers that
+// do not exist are no error.
+func (s *DockerService) RemoveContainer(ctx context.Context, server *config.Server, container string, sshService *SSHService) error {
+	// docker rm fails for missing containers, so only existing ones are removed
+	script := fmt.Sprintf("if docker container inspect %[1]s >/dev/null 2>&1; then docker rm -f %[1]s >/dev/null; fi", quoteArg(container))
+	if _, err := s.runDocker(ctx, server, shellScript(script), nil, sshService); err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", container, err)
+	}
+	return nil
+}
+
+// Exec runs a command in a container on a server, feeding it stdin if set,
+// and returns what it printed on stdout
+func (s *DockerService) Exec(ctx context.Context, server *config.Server, containerID string, cmd Command, stdin io.Reader, sshService *SSHService) (string, error) {
+	return s.runDocker(ctx, server, dockerExec(containerID, stdin != nil, cmd), stdin, sshService)
+}
+
+// runDocker runs a docker CLI command locally or over SSH. Errors carry the
+// tail of its stderr.
+func (s *DockerService) runDocker(ctx context.Context, server *config.Server, cmd Command, stdin io.Reader, sshService *SSHService) (string, error) {
+	var stdout strings.Builder
+	stderr := &tailBuffer{limit: maxDumpStderr}
+
+	var err error
+	if server.IsLocal() {
+		execCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+		execCmd.Stdin = stdin
+		execCmd.Stdout = &stdout
+		execCmd.Stderr = stderr
+		err = execCmd.Run()
+	} else {
+		err = sshService.RunRemoteCommand(ctx, server, cmd.String(), stdin, &stdout, stderr)
+	}
+	if err != nil {
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return "", fmt.Errorf("%w: %s", err, output)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
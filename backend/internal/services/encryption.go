@@ -0,0 +1,544 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// encryptionMagic starts the header line of encrypted dumps. The rest of the
+// line is the JSON encoded EncryptionHeader, and the payload follows it.
+const encryptionMagic = "PGM-ENCRYPTED-DUMP/1 "
+
+// maxEncryptionHeader bounds the header line read from uploads
+const maxEncryptionHeader = 64 * 1024
+
+// gcmChunkSize is the plaintext size of the chunks AES-256-GCM dumps are
+// sealed in, so that they can be encrypted and verified while streaming
+const gcmChunkSize = 64 * 1024
+
+// Key derivation functions of AES-256-GCM dumps
+const (
+	kdfScrypt = "scrypt"
+	kdfHKDF   = "hkdf-sha256"
+)
+
+// passphraseKeyID is the key ID recorded for dumps encrypted with a
+// passphrase from the request
+const passphraseKeyID = "passphrase"
+
+// ErrDecryptionKeyMissing is returned for encrypted uploads without the
+// passphrase, key or identity needed to decrypt them
+var ErrDecryptionKeyMissing = errors.New("no key available to decrypt dump")
+
+// EncryptionHeader describes how an encrypted dump was encrypted. It is
+// written in the clear ahead of the payload.
+type EncryptionHeader struct {
+	Algorithm string `json:"alg"`
+	// KeyID is the configured key, "passphrase", or the age recipients
+	KeyID string `json:"key_id"`
+	KDF   string `json:"kdf,omitempty"`
+	Salt  []byte `json:"salt,omitempty"`
+}
+
+// DecryptionSecrets are the secrets supplied with an upload to decrypt it,
+// in addition to the configured keys and identities
+type DecryptionSecrets struct {
+	Passphrase  string
+	AgeIdentity string
+}
+
+// EncryptionService encrypts dump streams and decrypts uploaded dumps
+type EncryptionService struct {
+	logger *logrus.Logger
+
+	mu         sync.RWMutex
+	keys       map[string][]byte
+	identities []age.Identity
+}
+
+// NewEncryptionService creates an encryption service with the configured keys
+// and age identities
+func NewEncryptionService(cfg config.Encryption, logger *logrus.Logger) (*EncryptionService, error) {
+	s := &EncryptionService{logger: logger}
+	if err := s.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the configured keys and age identities. The current ones
+// stay in use if the new ones are invalid.
+func (s *EncryptionService) Reload(cfg config.Encryption) error {
+	keys := make(map[string][]byte)
+	for _, key := range cfg.Keys {
+		if key.ID == "" || key.ID == passphraseKeyID {
+			return fmt.Errorf("encryption key needs an id other than %q", passphraseKeyID)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key.Key))
+		if err != nil || len(raw) != 32 {
+			return fmt.Errorf("encryption key %q must be 32 bytes, base64 encoded", key.ID)
+		}
+		keys[key.ID] = raw
+	}
+
+	var identities []age.Identity
+	if cfg.AgeIdentityFile != "" {
+		file, err := os.Open(cfg.AgeIdentityFile)
+		if err != nil {
+			return fmt.Errorf("failed to open age identity file: %w", err)
+		}
+		defer file.Close()
+		identities, err = age.ParseIdentities(file)
+		if err != nil {
+			return fmt.Errorf("failed to parse age identity file: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.identities = identities
+	s.mu.Unlock()
+	return nil
+}
+
+// key returns a configured key by its ID
+func (s *EncryptionService) key(id string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, exists := s.keys[id]
+	return key, exists
+}
+
+// ParseEncryption normalizes an encryption algorithm name and checks that the
+// options carry what the algorithm needs
+func ParseEncryption(options models.DumpOptions) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(options.Encryption)) {
+	case "", models.EncryptionNone:
+		if len(options.Recipients) > 0 || options.KeyID != "" || options.Passphrase != "" {
+			return "", fmt.Errorf("recipients, key and passphrase require an encryption algorithm")
+		}
+		return models.EncryptionNone, nil
+	case models.EncryptionAge:
+		if len(options.Recipients) == 0 {
+			return "", fmt.Errorf("age encryption requires at least one recipient")
+		}
+		if options.KeyID != "" || options.Passphrase != "" {
+			return "", fmt.Errorf("age encryption takes recipients, not a key or passphrase")
+		}
+		if _, err := parseRecipients(options.Recipients); err != nil {
+			return "", err
+		}
+		return models.EncryptionAge, nil
+	case "aes", "aes-gcm", models.EncryptionAESGCM:
+		if len(options.Recipients) > 0 {
+			return "", fmt.Errorf("aes-256-gcm encryption takes a key or passphrase, not recipients")
+		}
+		if (options.KeyID == "") == (options.Passphrase == "") {
+			return "", fmt.Errorf("aes-256-gcm encryption requires either a key_id or a passphrase")
+		}
+		return models.EncryptionAESGCM, nil
+	default:
+		return "", fmt.Errorf("unsupported encryption %q", options.Encryption)
+	}
+}
+
+// EncryptionExtension returns the file extension suffix for an encryption algorithm
+func EncryptionExtension(algorithm string) string {
+	if algorithm == "" || algorithm == models.EncryptionNone {
+		return ""
+	}
+	return ".enc"
+}
+
+// CheckKey returns an error if the key an AES-256-GCM dump asks for is not
+// configured, so that requests fail before any dump starts
+func (s *EncryptionService) CheckKey(options models.DumpOptions) error {
+	if options.Encryption != models.EncryptionAESGCM || options.KeyID == "" {
+		return nil
+	}
+	if _, exists := s.key(options.KeyID); !exists {
+		return fmt.Errorf("unknown encryption key %q", options.KeyID)
+	}
+	return nil
+}
+
+// EncryptStream wraps a dump stream so that reads return the encrypted dump,
+// header first. Closing the returned reader closes the source, so the exit
+// status of the underlying dump command is still reported. The source is
+// closed if the encryptor cannot be created.
+func (s *EncryptionService) EncryptStream(src io.ReadCloser, options models.DumpOptions) (io.ReadCloser, error) {
+	if options.Encryption == "" || options.Encryption == models.EncryptionNone {
+		return src, nil
+	}
+
+	pr, pw := io.Pipe()
+
+	encryptor, err := s.newEncryptor(pw, options)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.Copy(encryptor, src)
+		if closeErr := encryptor.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// The pipeline is the same as for compression
+	return &compressedReader{
+		PipeReader: pr,
+		src:        src,
+		done:       done,
+	}, nil
+}
+
+// newEncryptor writes the header of an encrypted dump to w and returns the
+// writer encrypting the payload. Closing it finishes the payload.
+func (s *EncryptionService) newEncryptor(w io.Writer, options models.DumpOptions) (io.WriteCloser, error) {
+	header := EncryptionHeader{Algorithm: options.Encryption}
+
+	switch options.Encryption {
+	case models.EncryptionAge:
+		recipients, err := parseRecipients(options.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		header.KeyID = strings.Join(options.Recipients, ",")
+		headerLine, err := encodeEncryptionHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		return &headerWriter{w: w, header: headerLine, open: func(w io.Writer) (io.WriteCloser, error) {
+			return age.Encrypt(w, recipients...)
+		}}, nil
+
+	case models.EncryptionAESGCM:
+		header.Salt = make([]byte, 16)
+		if _, err := rand.Read(header.Salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		if options.Passphrase != "" {
+			header.KeyID = passphraseKeyID
+			header.KDF = kdfScrypt
+		} else {
+			header.KeyID = options.KeyID
+			header.KDF = kdfHKDF
+		}
+		aead, err := s.dumpCipher(header, options.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		headerLine, err := encodeEncryptionHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		return &headerWriter{w: w, header: headerLine, open: func(w io.Writer) (io.WriteCloser, error) {
+			return &gcmWriter{w: w, aead: aead, aad: headerLine, buf: make([]byte, 0, gcmChunkSize)}, nil
+		}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported encryption %q", options.Encryption)
+	}
+}
+
+// DecryptInput returns the plaintext of an uploaded dump. Uploads that are
+// not encrypted are returned as they are. Decryption keys are taken from
+// secrets first, then from the configured keys and identities.
+func (s *EncryptionService) DecryptInput(input io.Reader, secrets DecryptionSecrets) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(len(encryptionMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read dump header: %w", err)
+	}
+	if string(magic) != encryptionMagic {
+		return buffered, nil
+	}
+
+	headerLine, err := readHeaderLine(buffered)
+	if err != nil {
+		return nil, err
+	}
+	var header EncryptionHeader
+	if err := json.Unmarshal(headerLine[len(encryptionMagic):], &header); err != nil {
+		return nil, fmt.Errorf("invalid encryption header: %w", err)
+	}
+
+	s.logger.Infof("Decrypting %s encrypted dump (key %s)", header.Algorithm, header.KeyID)
+
+	switch header.Algorithm {
+	case models.EncryptionAge:
+		s.mu.RLock()
+		identities := s.identities
+		s.mu.RUnlock()
+		if secrets.AgeIdentity != "" {
+			identities, err = age.ParseIdentities(strings.NewReader(secrets.AgeIdentity))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age identity: %w", err)
+			}
+		}
+		if len(identities) == 0 {
+			return nil, fmt.Errorf("%w: dump is encrypted to %s, supply an age identity", ErrDecryptionKeyMissing, header.KeyID)
+		}
+		reader, err := age.Decrypt(buffered, identities...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt dump: %w", err)
+		}
+		return reader, nil
+
+	case models.EncryptionAESGCM:
+		aead, err := s.dumpCipher(header, secrets.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &gcmReader{r: buffered, aead: aead, aad: headerLine, buf: make([]byte, gcmChunkSize+aead.Overhead())}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported encryption %q", header.Algorithm)
+	}
+}
+
+// dumpCipher derives the AES-256-GCM cipher of a dump from the passphrase or
+// the configured key named in its header
+func (s *EncryptionService) dumpCipher(header EncryptionHeader, passphrase string) (cipher.AEAD, error) {
+	if len(header.Salt) < 16 {
+		return nil, fmt.Errorf("invalid encryption header: salt missing")
+	}
+
+	var key []byte
+	switch header.KDF {
+	case kdfScrypt:
+		if passphrase == "" {
+			return nil, fmt.Errorf("%w: dump is encrypted with a passphrase", ErrDecryptionKeyMissing)
+		}
+		derived, err := scrypt.Key([]byte(passphrase), header.Salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		key = derived
+	case kdfHKDF:
+		master, exists := s.key(header.KeyID)
+		if !exists {
+			return nil, fmt.Errorf("%w: encryption key %q is not configured", ErrDecryptionKeyMissing, header.KeyID)
+		}
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, master, header.Salt, []byte("pgm dump encryption")), key); err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", header.KDF)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseRecipients parses age X25519 recipients
+func parseRecipients(values []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(values))
+	for _, value := range values {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", value, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// encodeEncryptionHeader renders the header line of an encrypted dump
+func encodeEncryptionHeader(header EncryptionHeader) ([]byte, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode encryption header: %w", err)
+	}
+	return append([]byte(encryptionMagic), append(data, '\n')...), nil
+}
+
+// readHeaderLine reads the header line of an encrypted dump
+func readHeaderLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxEncryptionHeader {
+			return nil, fmt.Errorf("invalid encryption header: too long")
+		}
+		switch {
+		case err == nil:
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return nil, fmt.Errorf("invalid encryption header: %w", err)
+		}
+	}
+}
+
+// headerWriter writes the header of an encrypted dump before the payload,
+// opening the payload encryptor on the first write or on close
+type headerWriter struct {
+	w      io.Writer
+	header []byte
+	open   func(io.Writer) (io.WriteCloser, error)
+	body   io.WriteCloser
+}
+
+func (w *headerWriter) start() error {
+	if w.body != nil {
+		return nil
+	}
+	if _, err := w.w.Write(w.header); err != nil {
+		return err
+	}
+	body, err := w.open(w.w)
+	if err != nil {
+		return fmt.Errorf("failed to start encryption: %w", err)
+	}
+	w.body = body
+	return nil
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return w.body.Write(p)
+}
+
+func (w *headerWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	return w.body.Close()
+}
+
+// gcmWriter seals a stream in AES-256-GCM chunks. The nonce of each chunk is
+// its counter followed by a flag marking the final chunk, which makes
+// reordered, dropped and truncated chunks fail authentication.
+type gcmWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	aad     []byte
+	buf     []byte
+	counter uint64
+}
+
+func (w *gcmWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, the final
+		// chunk is sealed by Close
+		if len(w.buf) == gcmChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):gcmChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *gcmWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *gcmWriter) seal(final bool) error {
+	sealed := w.aead.Seal(nil, gcmNonce(w.counter, final), w.buf, w.aad)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// gcmReader opens a stream sealed by gcmWriter
+type gcmReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	aad     []byte
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (r *gcmReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *gcmReader) open() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	switch {
+	case err == io.EOF:
+		return fmt.Errorf("failed to decrypt dump: it is truncated")
+	case err == io.ErrUnexpectedEOF:
+		// A short chunk can only be the final one
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the final one if nothing follows it
+		if _, peekErr := r.r.Peek(1); peekErr == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	final := err == io.ErrUnexpectedEOF
+
+	plain, openErr := r.aead.Open(r.buf[:0], gcmNonce(r.counter, final), r.buf[:n], r.aad)
+	if openErr != nil {
+		return fmt.Errorf("failed to decrypt dump: wrong key, or the dump is corrupted or was tampered with")
+	}
+	r.counter++
+	r.plain = plain
+	r.done = final
+	return nil
+}
+
+// gcmNonce builds the nonce of a chunk
+func gcmNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// randomBytes returns n random bytes
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	require.NoError(t, err)
+	return buf
+}
+
+// testGCM returns an AES-256-GCM cipher with a random key
+func testGCM(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher(randomBytes(t, 32))
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	return aead
+}
+
+// sealChunks seals plain with a gcmWriter and splits the output into its
+// chunks
+func sealChunks(t *testing.T, aead cipher.AEAD, aad, plain []byte) [][]byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w := &gcmWriter{w: &sealed, aead: aead, aad: aad, buf: make([]byte, 0, gcmChunkSize)}
+	_, err := w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var chunks [][]byte
+	data := sealed.Bytes()
+	for size := gcmChunkSize + aead.Overhead(); len(data) > size; data = data[size:] {
+		chunks = append(chunks, data[:size])
+	}
+	return append(chunks, data)
+}
+
+// openChunks reads chunks back through a gcmReader
+func openChunks(aead cipher.AEAD, aad []byte, chunks [][]byte) ([]byte, error) {
+	r := &gcmReader{
+		r:    bufio.NewReader(bytes.NewReader(bytes.Join(chunks, nil))),
+		aead: aead,
+		aad:  aad,
+		buf:  make([]byte, gcmChunkSize+aead.Overhead()),
+	}
+	return io.ReadAll(r)
+}
+
+func TestGCMStreamRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"short chunk", gcmChunkSize - 1, 1},
+		{"exact chunk", gcmChunkSize, 1},
+		{"chunk and a byte", gcmChunkSize + 1, 2},
+		{"several chunks", 3*gcmChunkSize + 100, 4},
+		{"exact chunks", 2 * gcmChunkSize, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aead := testGCM(t)
+			aad := []byte("header")
+			plain := randomBytes(t, tc.size)
+
+			chunks := sealChunks(t, aead, aad, plain)
+			assert.Len(t, chunks, tc.chunks)
+
+			opened, err := openChunks(aead, aad, chunks)
+			require.NoError(t, err)
+			assert.Equal(t, plain, opened)
+		})
+	}
+}
+
+func TestGCMStreamTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(chunks [][]byte) [][]byte
+	}{
+		{"final chunk dropped", func(chunks [][]byte) [][]byte {
+			return chunks[:len(chunks)-1]
+		}},
+		{"middle chunk dropped", func(chunks [][]byte) [][]byte {
+			return append([][]byte{chunks[0]}, chunks[2:]...)
+		}},
+		{"chunks reordered", func(chunks [][]byte) [][]byte {
+			return append([][]byte{chunks[1], chunks[0]}, chunks[2:]...)
+		}},
+		{"chunk duplicated", func(chunks [][]byte) [][]byte {
+			return append([][]byte{chunks[0], chunks[0]}, chunks[1:]...)
+		}},
+		{"truncated inside a chunk", func(chunks [][]byte) [][]byte {
+			last := chunks[len(chunks)-1]
+			return append(chunks[:len(chunks)-1], last[:len(last)-1])
+		}},
+		{"data appended", func(chunks [][]byte) [][]byte {
+			return append(chunks, []byte("trailing"))
+		}},
+		{"bit flipped", func(chunks [][]byte) [][]byte {
+			flipped := bytes.Clone(chunks[1])
+			flipped[10] ^= 1
+			return append([][]byte{chunks[0], flipped}, chunks[2:]...)
+		}},
+		{"everything dropped", func(chunks [][]byte) [][]byte {
+			return nil
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aead := testGCM(t)
+			aad := []byte("header")
+			chunks := sealChunks(t, aead, aad, randomBytes(t, 3*gcmChunkSize+100))
+			require.Len(t, chunks, 4)
+
+			_, err := openChunks(aead, aad, tc.tamper(chunks))
+			assert.ErrorContains(t, err, "failed to decrypt dump")
+		})
+	}
+}
+
+func TestGCMStreamWrongHeader(t *testing.T) {
+	aead := testGCM(t)
+	chunks := sealChunks(t, aead, []byte("header"), []byte("dump"))
+
+	_, err := openChunks(aead, []byte("other header"), chunks)
+	assert.ErrorContains(t, err, "failed to decrypt dump")
+}
+
+func TestEncryptStreamRoundTrip(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(randomBytes(t, 32))
+	service, err := NewEncryptionService(config.Encryption{Keys: []config.EncryptionKey{{ID: "backups", Key: key}}}, logrus.New())
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		options models.DumpOptions
+		secrets DecryptionSecrets
+		err     string
+	}{
+		{
+			name:    "configured key",
+			options: models.DumpOptions{Encryption: models.EncryptionAESGCM, KeyID: "backups"},
+		},
+		{
+			name:    "passphrase",
+			options: models.DumpOptions{Encryption: models.EncryptionAESGCM, Passphrase: "correct horse"},
+			secrets: DecryptionSecrets{Passphrase: "correct horse"},
+		},
+		{
+			name:    "wrong passphrase",
+			options: models.DumpOptions{Encryption: models.EncryptionAESGCM, Passphrase: "correct horse"},
+			secrets: DecryptionSecrets{Passphrase: "battery staple"},
+			err:     "failed to decrypt dump",
+		},
+		{
+			name:    "no encryption",
+			options: models.DumpOptions{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plain := randomBytes(t, 2*gcmChunkSize+7)
+
+			encrypted, err := service.EncryptStream(io.NopCloser(bytes.NewReader(plain)), tc.options)
+			require.NoError(t, err)
+			sealed, err := io.ReadAll(encrypted)
+			require.NoError(t, err)
+			require.NoError(t, encrypted.Close())
+
+			decrypted, err := service.DecryptInput(bytes.NewReader(sealed), tc.secrets)
+			if err == nil {
+				var opened []byte
+				opened, err = io.ReadAll(decrypted)
+				if tc.err == "" {
+					assert.Equal(t, plain, opened)
+				}
+			}
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
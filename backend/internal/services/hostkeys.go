@@ -0,0 +1,357 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/utils"
+)
+
+// Sources of the accepted host key of a server
+const (
+	HostKeySourcePinned     = "pinned"
+	HostKeySourceKnownHosts = "known_hosts_file"
+	HostKeySourceManaged    = "managed"
+)
+
+// ErrHostKeyNotManaged is returned when rotating the key of a server whose
+// host key is configured in config.yaml rather than in the managed store
+var ErrHostKeyNotManaged = errors.New("host key is configured in config.yaml and cannot be rotated through the API")
+
+// HostKeyMismatchError is returned when a server presents a host key other
+// than the accepted one
+type HostKeyMismatchError struct {
+	Address   string
+	Presented string
+	Expected  []string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: server presented %s but %s is accepted; this may be a man-in-the-middle attack, rotate the accepted key only if the change is legitimate",
+		e.Address, e.Presented, strings.Join(e.Expected, ", "))
+}
+
+// UnknownHostKeyError is returned under strict host key checking for servers
+// that have no accepted host key yet
+type UnknownHostKeyError struct {
+	Address   string
+	Presented string
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("no accepted host key for %s (server presented %s) and strict host key checking is enabled", e.Address, e.Presented)
+}
+
+// HostKeyStore verifies SSH host keys. Servers are checked against a pinned
+// fingerprint or their own known_hosts file when configured, and against a
+// managed known_hosts file otherwise, where unknown hosts are trusted and
+// recorded on first use unless strict checking is enabled.
+type HostKeyStore struct {
+	path   string
+	strict bool
+	logger *logrus.Logger
+
+	mu sync.Mutex
+}
+
+// knownHostEntry is a line of a known_hosts file
+type knownHostEntry struct {
+	line   string
+	marker string
+	hosts  []string
+	key    ssh.PublicKey
+}
+
+// NewHostKeyStore creates a host key store backed by the managed known_hosts file
+func NewHostKeyStore(cfg config.SSH, logger *logrus.Logger) (*HostKeyStore, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.KnownHostsFile), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+
+	file, err := os.OpenFile(cfg.KnownHostsFile, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known_hosts file: %w", err)
+	}
+	file.Close()
+
+	return &HostKeyStore{
+		path:   cfg.KnownHostsFile,
+		strict: cfg.StrictHostKeyChecking,
+		logger: logger,
+	}, nil
+}
+
+// Source returns where the accepted host key of a server comes from
+func (s *HostKeyStore) Source(server *config.Server) string {
+	switch {
+	case server.HostKeyFingerprint != "":
+		return HostKeySourcePinned
+	case server.KnownHostsFile != "":
+		return HostKeySourceKnownHosts
+	default:
+		return HostKeySourceManaged
+	}
+}
+
+// Callback returns the host key callback verifying a server
+func (s *HostKeyStore) Callback(server *config.Server) (ssh.HostKeyCallback, error) {
+	switch s.Source(server) {
+	case HostKeySourcePinned:
+		pinned := normalizeFingerprint(server.HostKeyFingerprint)
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if presented := ssh.FingerprintSHA256(key); presented != pinned {
+				return &HostKeyMismatchError{Address: hostname, Presented: presented, Expected: []string{pinned}}
+			}
+			return nil
+		}, nil
+
+	case HostKeySourceKnownHosts:
+		callback, err := knownhosts.New(server.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts file of server %s: %w", server.ID, err)
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := callback(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if errors.As(err, &keyErr) {
+				presented := ssh.FingerprintSHA256(key)
+				if len(keyErr.Want) == 0 {
+					return &UnknownHostKeyError{Address: hostname, Presented: presented}
+				}
+				var expected []string
+				for _, want := range keyErr.Want {
+					expected = append(expected, ssh.FingerprintSHA256(want.Key))
+				}
+				return &HostKeyMismatchError{Address: hostname, Presented: presented, Expected: expected}
+			}
+			return err
+		}, nil
+
+	default:
+		return s.verifyManaged, nil
+	}
+}
+
+// Accepted returns the host keys currently accepted for a server
+func (s *HostKeyStore) Accepted(server *config.Server) ([]models.HostKey, error) {
+	switch s.Source(server) {
+	case HostKeySourcePinned:
+		return []models.HostKey{{Fingerprint: normalizeFingerprint(server.HostKeyFingerprint)}}, nil
+
+	case HostKeySourceKnownHosts:
+		entries, err := readKnownHosts(server.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		return hostKeysOf(matchingEntries(entries, sshAddress(server))), nil
+
+	default:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		entries, err := readKnownHosts(s.path)
+		if err != nil {
+			return nil, err
+		}
+		return hostKeysOf(matchingEntries(entries, sshAddress(server))), nil
+	}
+}
+
+// Replace makes key the only accepted host key of a server in the managed store
+func (s *HostKeyStore) Replace(server *config.Server, key ssh.PublicKey) error {
+	if s.Source(server) != HostKeySourceManaged {
+		return ErrHostKeyNotManaged
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := readKnownHosts(s.path)
+	if err != nil {
+		return err
+	}
+
+	address := knownhosts.Normalize(sshAddress(server))
+	var content strings.Builder
+	for _, entry := range entries {
+		if entry.key != nil && entry.marker == "" && matchesHost(entry.hosts, address) {
+			continue
+		}
+		content.WriteString(entry.line)
+		content.WriteString("\n")
+	}
+	content.WriteString(knownhosts.Line([]string{address}, key))
+	content.WriteString("\n")
+
+	// Rewrite atomically so a crash never leaves a truncated store behind
+	if err := utils.WriteFileAtomic(s.path, []byte(content.String())); err != nil {
+		return fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+
+	s.logger.Warnf("Rotated accepted host key of %s to %s", address, ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// verifyManaged checks a host key against the managed store, trusting and
+// recording it on first use unless strict checking is enabled
+func (s *HostKeyStore) verifyManaged(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := readKnownHosts(s.path)
+	if err != nil {
+		return err
+	}
+
+	address := knownhosts.Normalize(hostname)
+	presented := ssh.FingerprintSHA256(key)
+	known := matchingEntries(entries, hostname)
+
+	if len(known) == 0 {
+		if s.strict {
+			return &UnknownHostKeyError{Address: address, Presented: presented}
+		}
+
+		file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open known_hosts file: %w", err)
+		}
+		defer file.Close()
+
+		if _, err := file.WriteString(knownhosts.Line([]string{address}, key) + "\n"); err != nil {
+			return fmt.Errorf("failed to record host key: %w", err)
+		}
+
+		s.logger.Warnf("Trusting host key %s of %s on first use", presented, address)
+		return nil
+	}
+
+	var expected []string
+	for _, entry := range known {
+		if bytes.Equal(entry.key.Marshal(), key.Marshal()) {
+			return nil
+		}
+		expected = append(expected, ssh.FingerprintSHA256(entry.key))
+	}
+
+	s.logger.Errorf("Host key mismatch for %s: presented %s", address, presented)
+	return &HostKeyMismatchError{Address: address, Presented: presented, Expected: expected}
+}
+
+// readKnownHosts parses a known_hosts file, keeping every line so the file
+// can be rewritten without losing comments or entries it does not understand
+func readKnownHosts(path string) ([]knownHostEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts file: %w", err)
+	}
+
+	var entries []knownHostEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		entry := knownHostEntry{line: line}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(trimmed))
+			if err == nil {
+				entry.marker = marker
+				entry.hosts = hosts
+				entry.key = key
+			}
+		}
+
+		if line != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts file: %w", err)
+	}
+
+	return entries, nil
+}
+
+// matchingEntries returns the plain host key entries for an address
+func matchingEntries(entries []knownHostEntry, address string) []knownHostEntry {
+	normalized := knownhosts.Normalize(address)
+
+	var matches []knownHostEntry
+	for _, entry := range entries {
+		if entry.key != nil && entry.marker == "" && matchesHost(entry.hosts, normalized) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// matchesHost reports whether a normalized address is one of the host
+// patterns of a known_hosts entry, including hashed ones
+func matchesHost(hosts []string, address string) bool {
+	for _, host := range hosts {
+		if host == address {
+			return true
+		}
+
+		// Hashed entries look like |1|base64(salt)|base64(hmac-sha1(salt, host))
+		if parts := strings.Split(host, "|"); len(parts) == 4 && parts[0] == "" && parts[1] == "1" {
+			salt, err := base64.StdEncoding.DecodeString(parts[2])
+			if err != nil {
+				continue
+			}
+			hash, err := base64.StdEncoding.DecodeString(parts[3])
+			if err != nil {
+				continue
+			}
+			mac := hmac.New(sha1.New, salt)
+			mac.Write([]byte(address))
+			if hmac.Equal(mac.Sum(nil), hash) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostKeysOf returns the API representation of the keys of known_hosts entries
+func hostKeysOf(entries []knownHostEntry) []models.HostKey {
+	keys := []models.HostKey{}
+	for _, entry := range entries {
+		keys = append(keys, hostKeyOf(entry.key))
+	}
+	return keys
+}
+
+// hostKeyOf returns the API representation of a host key
+func hostKeyOf(key ssh.PublicKey) models.HostKey {
+	return models.HostKey{
+		Type:        key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}
+}
+
+// normalizeFingerprint returns a SHA256 fingerprint in the form printed by
+// ssh-keygen -l, accepting it with or without the SHA256: prefix
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimSpace(fingerprint)
+	fingerprint = strings.TrimPrefix(fingerprint, "SHA256:")
+	return "SHA256:" + strings.TrimRight(fingerprint, "=")
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"backend/internal/config"
+)
+
+var (
+	// ErrContainerNotFound is returned for container IDs that are not among
+	// the PostgreSQL containers discovered on a server
+	ErrContainerNotFound = errors.New("container not found")
+	// ErrDatabaseNotFound is returned for database names that are not among
+	// the databases discovered on a server
+	ErrDatabaseNotFound = errors.New("database not found")
+)
+
+// ResolveContainer checks a container reference from a request against the
+// PostgreSQL containers running on a server. The reference may be the short
+// or full ID or the name of the container. It returns the discovered ID,
+// which is what ends up in generated commands, and the container name.
+func (s *DockerService) ResolveContainer(ctx context.Context, server *config.Server, containerID string, sshService *SSHService) (string, string, error) {
+	if containerID == "" {
+		return "", "", ErrContainerNotFound
+	}
+
+	containers, err := s.GetPostgreSQLContainers(ctx, server, sshService)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, container := range containers {
+		if container.ID == "" {
+			continue
+		}
+		if containerID == container.ID || containerID == container.Name ||
+			(len(containerID) > len(container.ID) && strings.HasPrefix(containerID, container.ID)) {
+			return container.ID, container.Name, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%w: %s on server %s", ErrContainerNotFound, containerID, server.ID)
+}
+
+// ResolveDatabase checks a database name from a request against the
+// databases of a container, or of the host for an empty containerID. The
+// container must already have been resolved. Names are checked against
+// everything in pg_database, as the listing leaves out names it does not
+// display.
+func (s *PostgresService) ResolveDatabase(ctx context.Context, server *config.Server, containerID, dbName string, sshService *SSHService) (string, error) {
+	databases, err := s.DatabaseNames(ctx, server, containerID, sshService)
+	if err != nil {
+		return "", err
+	}
+
+	for _, database := range databases {
+		if database == dbName {
+			return database, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s on server %s", ErrDatabaseNotFound, dbName, server.ID)
+}
+
+// ValidateDatabaseName checks that a database name can be used in the file
+// names and storage keys of its dumps. Names of databases that are about to
+// be created, and so cannot be in the inventory yet, are checked against it
+// as well.
+func (s *PostgresService) ValidateDatabaseName(dbName string) error {
+	// PostgreSQL truncates identifiers to 63 bytes. Names with "=" are
+	// refused as well, libpq reads such -d values as connection strings.
+	if dbName == "" || len(dbName) > 63 || strings.Contains(dbName, "=") {
+		return fmt.Errorf("invalid database name %q", dbName)
+	}
+	if strings.ContainsAny(dbName, "/\\\x00") || strings.HasPrefix(dbName, ".") {
+		return fmt.Errorf("database name %q cannot be used in file names", dbName)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDatabaseName(t *testing.T) {
+	s := &PostgresService{}
+
+	for _, tc := range []struct {
+		name string
+		err  string
+	}{
+		{"app", ""},
+		{"postgres", ""},
+		{"My App-2024", ""},
+		{"ünïcode", ""},
+		{"it's", ""},
+		{strings.Repeat("a", 63), ""},
+		{"", "invalid database name"},
+		{strings.Repeat("a", 64), "invalid database name"},
+		{"host=evil user=x", "invalid database name"},
+		{"dbname=other", "invalid database name"},
+		{"a/b", "cannot be used in file names"},
+		{`a\b`, "cannot be used in file names"},
+		{"a\x00b", "cannot be used in file names"},
+		{".hidden", "cannot be used in file names"},
+		{"..", "cannot be used in file names"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.ValidateDatabaseName(tc.name)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,622 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+var (
+	// ErrJobNotFound is returned for unknown or expired job IDs
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotFinished is returned when the artifact of a job is requested
+	// before the job completed
+	ErrJobNotFinished = errors.New("job has not completed")
+	// ErrQueueFull is returned when the job queue cannot take more jobs
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrJobFinished is returned when a job that already finished is
+	// cancelled
+	ErrJobFinished = errors.New("job has already finished")
+	// errJobCancelled is the error of a cancelled job
+	errJobCancelled = errors.New("job was cancelled")
+)
+
+// partialSuffix marks spool files that are still being written
+const partialSuffix = ".part"
+
+// dumpJob is an asynchronous dump and its current state
+type dumpJob struct {
+	id          string
+	owner       string
+	server      *config.Server
+	containerID string
+	// containerName is the name of the container, as audited and cataloged
+	containerName string
+	database      string
+	options       models.DumpOptions
+	filename      string
+	path          string
+	// storage, if set, receives the artifact under key instead of the
+	// spool directory. Stored artifacts outlive their job and are recorded
+	// in the backup catalog.
+	storage       Storage
+	key           string
+	serverVersion string
+	rowEstimates  map[string]int64
+	sha256        string
+	backupID      string
+	// done is called with the final state of the job, if set
+	done func(models.JobResponse)
+	// ctx is cancelled to abort the job
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	bytesWritten atomic.Int64
+
+	mu         sync.Mutex
+	status     string
+	err        string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// JobService runs dump jobs in a bounded worker pool, spooling the
+// artifacts to a local directory
+type JobService struct {
+	config            config.Jobs
+	postgresService   *PostgresService
+	sshService        *SSHService
+	encryptionService *EncryptionService
+	catalog           *BackupCatalog
+	logger            *logrus.Logger
+
+	mu    sync.RWMutex
+	jobs  map[string]*dumpJob
+	queue chan *dumpJob
+}
+
+// NewJobService creates a new job service and prepares its spool directory
+func NewJobService(cfg config.Jobs, postgresService *PostgresService, sshService *SSHService, encryptionService *EncryptionService, catalog *BackupCatalog, logger *logrus.Logger) (*JobService, error) {
+	if err := os.MkdirAll(cfg.SpoolDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	// Jobs are kept in memory, so anything left over from a previous run
+	// can no longer be downloaded
+	entries, err := os.ReadDir(cfg.SpoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isJobID(strings.TrimSuffix(entry.Name(), partialSuffix)) {
+			os.Remove(filepath.Join(cfg.SpoolDir, entry.Name()))
+		}
+	}
+
+	return &JobService{
+		config:            cfg,
+		postgresService:   postgresService,
+		sshService:        sshService,
+		encryptionService: encryptionService,
+		catalog:           catalog,
+		logger:            logger,
+		jobs:              make(map[string]*dumpJob),
+		queue:             make(chan *dumpJob, cfg.QueueSize),
+	}, nil
+}
+
+// Start starts the workers and the cleanup of expired jobs
+func (s *JobService) Start() {
+	for i := 0; i < s.config.Workers; i++ {
+		go s.worker()
+	}
+	go s.janitor()
+
+	s.logger.Infof("Started %d dump job workers, spooling to %s", s.config.Workers, s.config.SpoolDir)
+}
+
+// SubmitDump queues a dump of a database on behalf of owner. An empty
+// containerID refers to a host database. containerName is the name of the
+// container, as recorded in the audit log.
+func (s *JobService) SubmitDump(owner string, server *config.Server, containerID, containerName, database string, options models.DumpOptions) (models.JobResponse, error) {
+	id, err := newJobID()
+	if err != nil {
+		return models.JobResponse{}, err
+	}
+
+	job := &dumpJob{
+		id:            id,
+		owner:         owner,
+		server:        server,
+		containerID:   containerID,
+		containerName: containerName,
+		database:      database,
+		options:       options,
+		filename:      DumpFilename(server.ID, containerID, database, options),
+		path:          filepath.Join(s.config.SpoolDir, id),
+		status:        models.JobStatusQueued,
+		createdAt:     time.Now(),
+	}
+	return s.submit(job)
+}
+
+// SubmitBackup queues a dump like SubmitDump that is streamed to key in a
+// storage rather than the spool directory, kept when the job expires and
+// recorded in the backup catalog, where the container is cataloged as
+// containerName. done, if set, is called with the final state of the job.
+func (s *JobService) SubmitBackup(owner string, server *config.Server, containerID, containerName, database string, options models.DumpOptions, storage Storage, key string, done func(models.JobResponse)) (models.JobResponse, error) {
+	id, err := newJobID()
+	if err != nil {
+		return models.JobResponse{}, err
+	}
+
+	job := &dumpJob{
+		id:            id,
+		owner:         owner,
+		server:        server,
+		containerID:   containerID,
+		database:      database,
+		options:       options,
+		filename:      path.Base(key),
+		storage:       storage,
+		key:           key,
+		containerName: containerName,
+		done:          done,
+		status:        models.JobStatusQueued,
+		createdAt:     time.Now(),
+	}
+	return s.submit(job)
+}
+
+// submit queues a job
+func (s *JobService) submit(job *dumpJob) (models.JobResponse, error) {
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case s.queue <- job:
+	default:
+		job.cancel()
+		return models.JobResponse{}, ErrQueueFull
+	}
+	s.jobs[job.id] = job
+
+	s.logger.Infof("Queued dump job %s for database %s on server %s", job.id, job.database, job.server.ID)
+	return job.snapshot(), nil
+}
+
+// GetJob returns the current state of a job
+func (s *JobService) GetJob(id string) (models.JobResponse, error) {
+	job, err := s.lookup(id)
+	if err != nil {
+		return models.JobResponse{}, err
+	}
+	return job.snapshot(), nil
+}
+
+// ListJobs returns all known jobs, newest first
+func (s *JobService) ListJobs() []models.JobResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]models.JobResponse, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Cancel aborts a queued or running job. A running dump is stopped on its
+// server and its partial artifact removed; the job fails as cancelled once
+// that is done.
+func (s *JobService) Cancel(id string) (models.JobResponse, error) {
+	job, err := s.lookup(id)
+	if err != nil {
+		return models.JobResponse{}, err
+	}
+
+	job.mu.Lock()
+	switch job.status {
+	case models.JobStatusQueued:
+		// The worker skips it when it comes up
+		job.status = models.JobStatusCancelled
+		job.err = errJobCancelled.Error()
+		job.finishedAt = time.Now()
+	case models.JobStatusRunning:
+	default:
+		job.mu.Unlock()
+		return models.JobResponse{}, ErrJobFinished
+	}
+	job.mu.Unlock()
+
+	job.cancel()
+	s.logger.Infof("Cancelled dump job %s", job.id)
+	return job.snapshot(), nil
+}
+
+// JobArtifact is where the dump of a completed job is kept: a spool file at
+// Path, or Key in Storage
+type JobArtifact struct {
+	Filename string
+	Path     string
+	Storage  Storage
+	Key      string
+}
+
+// Artifact returns the artifact of a completed job
+func (s *JobService) Artifact(id string) (JobArtifact, error) {
+	job, err := s.lookup(id)
+	if err != nil {
+		return JobArtifact{}, err
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status != models.JobStatusCompleted {
+		return JobArtifact{}, ErrJobNotFinished
+	}
+	return JobArtifact{Filename: job.filename, Path: job.path, Storage: job.storage, Key: job.key}, nil
+}
+
+// lookup finds a job by ID
+func (s *JobService) lookup(id string) (*dumpJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// worker runs queued jobs one at a time
+func (s *JobService) worker() {
+	for job := range s.queue {
+		s.run(job)
+	}
+}
+
+// run executes a dump job and records its outcome
+func (s *JobService) run(job *dumpJob) {
+	defer job.cancel()
+
+	job.mu.Lock()
+	if job.status == models.JobStatusCancelled {
+		job.mu.Unlock()
+		if job.done != nil {
+			job.done(job.snapshot())
+		}
+		return
+	}
+	job.status = models.JobStatusRunning
+	job.startedAt = time.Now()
+	job.mu.Unlock()
+
+	s.logger.Infof("Running dump job %s", job.id)
+	err := s.dump(job.ctx, job)
+
+	job.mu.Lock()
+	job.finishedAt = time.Now()
+	if err != nil && job.ctx.Err() != nil {
+		s.logger.Infof("Dump job %s was cancelled: %v", job.id, err)
+		job.status = models.JobStatusCancelled
+		job.err = errJobCancelled.Error()
+	} else if err != nil {
+		s.logger.Errorf("Dump job %s failed: %v", job.id, err)
+		job.status = models.JobStatusFailed
+		job.err = err.Error()
+	} else {
+		s.logger.Infof("Dump job %s completed (%d bytes in %s)", job.id, job.bytesWritten.Load(), job.finishedAt.Sub(job.startedAt).Round(time.Second))
+		job.status = models.JobStatusCompleted
+	}
+	job.mu.Unlock()
+
+	if err == nil && job.storage != nil {
+		s.catalogBackup(job)
+	}
+
+	if job.done != nil {
+		job.done(job.snapshot())
+	}
+}
+
+// dump streams the dump of a job into its storage or spool file
+func (s *JobService) dump(ctx context.Context, job *dumpJob) error {
+	if job.storage != nil {
+		// The version is only cataloged, a dump without it is still fine
+		version, err := s.postgresService.GetServerVersion(ctx, job.server, job.containerID, job.database, s.sshService)
+		if err != nil {
+			s.logger.Warnf("Dump job %s: %v", job.id, err)
+		}
+		job.serverVersion = version
+
+		// The estimates are what verifications compare restores with
+		// rather than counting the rows of the source
+		estimates, err := s.postgresService.TableRowEstimates(ctx, job.server, job.containerID, job.database, s.sshService)
+		if err != nil {
+			s.logger.Warnf("Dump job %s: %v", job.id, err)
+		}
+		job.rowEstimates = estimates
+	}
+
+	var dumpReader io.ReadCloser
+	var err error
+	if job.containerID != "" {
+		dumpReader, err = s.postgresService.CreateDumpViaSSH(ctx, job.server, job.containerID, job.database, job.options, s.sshService)
+	} else {
+		dumpReader, err = s.postgresService.CreateHostDumpViaSSH(ctx, job.server, job.database, job.options, s.sshService)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create dump: %w", err)
+	}
+
+	dumpReader, err = CompressStream(dumpReader, job.options.Compression, job.options.CompressionLevel)
+	if err != nil {
+		return fmt.Errorf("failed to compress dump: %w", err)
+	}
+
+	dumpReader, err = s.encryptionService.EncryptStream(dumpReader, job.options)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt dump: %w", err)
+	}
+
+	if job.storage != nil {
+		return s.dumpToStorage(ctx, job, dumpReader)
+	}
+	return s.dumpToSpool(job, dumpReader)
+}
+
+// catalogBackup records the stored artifact of a completed job in the
+// backup catalog. The backup is kept if that fails, it is only not listed.
+func (s *JobService) catalogBackup(job *dumpJob) {
+	job.mu.Lock()
+	backup := models.Backup{
+		ServerID:      job.server.ID,
+		ContainerID:   job.containerID,
+		ContainerName: job.containerName,
+		Database:      job.database,
+		Options:       job.options,
+		Format:        job.options.Format,
+		Compression:   job.options.Compression,
+		Encryption:    job.options.Encryption,
+		Size:          job.bytesWritten.Load(),
+		SHA256:        job.sha256,
+		ServerVersion: job.serverVersion,
+		RowEstimates:  job.rowEstimates,
+		Storage:       job.storage.Name(),
+		Key:           job.key,
+		Owner:         job.owner,
+		JobID:         job.id,
+		StartedAt:     job.startedAt,
+		FinishedAt:    job.finishedAt,
+	}
+	job.mu.Unlock()
+
+	backup, err := s.catalog.Add(backup)
+	if err != nil {
+		s.logger.Errorf("Failed to record backup %s of job %s in the catalog: %v", backup.Key, job.id, err)
+		return
+	}
+
+	job.mu.Lock()
+	job.backupID = backup.ID
+	job.mu.Unlock()
+}
+
+// dumpToStorage streams a dump to the storage of a job
+func (s *JobService) dumpToStorage(ctx context.Context, job *dumpJob, dumpReader io.ReadCloser) error {
+	reader := &exitCheckingReader{ReadCloser: dumpReader}
+	hash := sha256.New()
+	_, err := job.storage.Put(ctx, job.key, &countingReader{Reader: io.TeeReader(reader, hash), count: &job.bytesWritten})
+
+	// Closing early stops the dump, which is no failure of its own then
+	if closeErr := reader.Close(); closeErr != nil && (err == nil || reader.eof) {
+		return fmt.Errorf("dump command failed: %w", closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store dump in %s: %w", job.storage.Name(), err)
+	}
+	job.sha256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// dumpToSpool streams a dump into the spool file of a job. The file only
+// gets its final name once the dump succeeded.
+func (s *JobService) dumpToSpool(job *dumpJob, dumpReader io.ReadCloser) error {
+	partialPath := job.path + partialSuffix
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		dumpReader.Close()
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	_, copyErr := io.Copy(&countingWriter{Writer: file, count: &job.bytesWritten}, dumpReader)
+	closeErr := dumpReader.Close()
+	fileErr := file.Close()
+
+	switch {
+	case copyErr != nil:
+		err = fmt.Errorf("failed to write dump: %w", copyErr)
+	case closeErr != nil:
+		err = fmt.Errorf("dump command failed: %w", closeErr)
+	case fileErr != nil:
+		err = fmt.Errorf("failed to write dump: %w", fileErr)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	if err := os.Rename(partialPath, job.path); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to finalize spool file: %w", err)
+	}
+	return nil
+}
+
+// janitor periodically removes finished jobs older than the retention period
+func (s *JobService) janitor() {
+	interval := s.config.Retention / 4
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.removeExpired(time.Now().Add(-s.config.Retention))
+	}
+}
+
+// removeExpired removes jobs that finished before cutoff along with their artifacts
+func (s *JobService) removeExpired(cutoff time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, job := range s.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && job.finishedAt.Before(cutoff)
+		job.mu.Unlock()
+
+		if expired {
+			// Stored artifacts outlive their job
+			if job.storage == nil {
+				if err := os.Remove(job.path); err != nil && !os.IsNotExist(err) {
+					s.logger.Warnf("Failed to remove artifact of job %s: %v", id, err)
+				}
+			}
+			delete(s.jobs, id)
+			s.logger.Infof("Removed expired dump job %s", id)
+		}
+	}
+}
+
+// snapshot returns the API representation of the job
+func (j *dumpJob) snapshot() models.JobResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	resp := models.JobResponse{
+		ID:            j.id,
+		Status:        j.status,
+		Owner:         j.owner,
+		ServerID:      j.server.ID,
+		ContainerID:   j.containerID,
+		ContainerName: j.containerName,
+		Database:      j.database,
+		Options:       j.options,
+		Filename:      j.filename,
+		Key:           j.key,
+		BackupID:      j.backupID,
+		BytesWritten:  j.bytesWritten.Load(),
+		Error:         j.err,
+		CreatedAt:     j.createdAt,
+	}
+	if j.storage != nil {
+		resp.Storage = j.storage.Name()
+	}
+
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		resp.StartedAt = &startedAt
+
+		end := time.Now()
+		if !j.finishedAt.IsZero() {
+			finishedAt := j.finishedAt
+			resp.FinishedAt = &finishedAt
+			end = finishedAt
+		}
+		resp.Duration = end.Sub(startedAt).Round(time.Second).String()
+	}
+
+	return resp
+}
+
+// newJobID returns a random job identifier
+func newJobID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// isJobID reports whether name has the shape of a generated job ID
+func isJobID(name string) bool {
+	if len(name) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// exitCheckingReader closes a dump once it is read to the end and reports
+// a failed dump command in place of EOF, so that storage discards the upload
+// rather than keeping a broken dump
+type exitCheckingReader struct {
+	io.ReadCloser
+	eof    bool
+	closed bool
+	err    error
+}
+
+func (r *exitCheckingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+		if closeErr := r.Close(); closeErr != nil {
+			return n, fmt.Errorf("dump command failed: %w", closeErr)
+		}
+	}
+	return n, err
+}
+
+// Close closes the dump once and returns the result of that on every call
+func (r *exitCheckingReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.err = r.ReadCloser.Close()
+	}
+	return r.err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	io.Writer
+	count *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
+
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// loginFreeAttempts failed logins are allowed before logins are slowed
+	// down, each further failure doubles the wait up to loginMaxDelay
+	loginFreeAttempts = 5
+	loginBaseDelay    = time.Second
+	loginMaxDelay     = 15 * time.Minute
+	// loginFailureTTL is how long failed logins are remembered after the last
+	// attempt
+	loginFailureTTL = time.Hour
+	// loginPruneSize is the number of tracked keys above which forgotten
+	// ones are removed
+	loginPruneSize = 1024
+)
+
+// LoginThrottledError is returned for logins refused because of too many
+// failed attempts for the user or from the client address
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// loginLimiter slows down password guessing. Attempts are counted per key,
+// the username and the client address, and once a key has failed
+// loginFreeAttempts times further attempts must wait for an exponentially
+// growing delay.
+type loginLimiter struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	attempts map[string]*loginAttempts
+}
+
+// loginAttempts are the recent failed logins of a key
+type loginAttempts struct {
+	failures    int
+	lastAttempt time.Time
+	lockedUntil time.Time
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{now: time.Now, attempts: make(map[string]*loginAttempts)}
+}
+
+// attempt starts a login for keys, refusing it while one of them is locked.
+// The attempt counts as failed until succeeded is called, so that parallel
+// attempts cannot get past the limit.
+func (l *loginLimiter) attempt(keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.attempts) > loginPruneSize {
+		l.prune(now)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		if a, ok := l.attempts[key]; ok && a.lockedUntil.After(now) {
+			wait = max(wait, a.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+
+	for _, key := range keys {
+		a, ok := l.attempts[key]
+		if !ok || now.Sub(a.lastAttempt) > loginFailureTTL {
+			a = &loginAttempts{}
+			l.attempts[key] = a
+		}
+		a.failures++
+		a.lastAttempt = now
+		if excess := a.failures - loginFreeAttempts; excess > 0 {
+			a.lockedUntil = now.Add(loginDelay(excess))
+		}
+	}
+	return nil
+}
+
+// succeeded takes back a successful attempt. The failures of the user are
+// forgotten, while the client address only gets its attempt back, so that
+// logging in to one account does not reset guessing at others.
+func (l *loginLimiter) succeeded(userKey, addressKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, userKey)
+	if a, ok := l.attempts[addressKey]; ok {
+		a.failures--
+		if a.failures <= loginFreeAttempts {
+			a.lockedUntil = time.Time{}
+		}
+	}
+}
+
+// prune removes the keys whose failures are forgotten. The caller must hold
+// l.mu.
+func (l *loginLimiter) prune(now time.Time) {
+	for key, a := range l.attempts {
+		if now.Sub(a.lastAttempt) > loginFailureTTL && !a.lockedUntil.After(now) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// loginDelay returns the wait after the given number of failures beyond the
+// free ones
+func loginDelay(excess int) time.Duration {
+	if excess > 20 {
+		return loginMaxDelay
+	}
+	return min(loginBaseDelay<<(excess-1), loginMaxDelay)
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLoginLimiter returns a limiter on a clock advanced by the returned func
+func testLoginLimiter() (*loginLimiter, func(time.Duration)) {
+	now := time.Date(2024, 9, 10, 12, 0, 0, 0, time.UTC)
+	l := newLoginLimiter()
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+// retryAfter returns how long an attempt has to wait, 0 if it is allowed
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, err, &throttled)
+	return throttled.RetryAfter
+}
+
+func TestLoginDelay(t *testing.T) {
+	for _, tc := range []struct {
+		excess int
+		delay  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{10, 512 * time.Second},
+		{11, loginMaxDelay},
+		{64, loginMaxDelay},
+	} {
+		assert.Equal(t, tc.delay, loginDelay(tc.excess), "excess %d", tc.excess)
+	}
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	l, advance := testLoginLimiter()
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		require.NoError(t, l.attempt("user:alice", "address:203.0.113.1"), "free attempt %d", i+1)
+	}
+
+	// The next failure locks for a second, then two, then four
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		require.NoError(t, l.attempt("user:alice", "address:203.0.113.1"))
+		assert.Equal(t, delay, retryAfter(t, l.attempt("user:alice", "address:203.0.113.1")))
+
+		// Refused attempts do not extend the lock
+		advance(delay - time.Millisecond)
+		assert.Equal(t, time.Millisecond, retryAfter(t, l.attempt("user:alice", "address:203.0.113.1")))
+		advance(time.Millisecond)
+	}
+}
+
+func TestLoginLimiterKeys(t *testing.T) {
+	l, _ := testLoginLimiter()
+	for i := 0; i <= loginFreeAttempts; i++ {
+		require.NoError(t, l.attempt("user:alice", "address:203.0.113.1"))
+	}
+
+	for _, tc := range []struct {
+		name    string
+		keys    []string
+		limited bool
+	}{
+		{"same user and address", []string{"user:alice", "address:203.0.113.1"}, true},
+		{"same user from another address", []string{"user:alice", "address:203.0.113.2"}, true},
+		{"another user from the same address", []string{"user:bob", "address:203.0.113.1"}, true},
+		{"another user and address", []string{"user:bob", "address:203.0.113.2"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.limited, retryAfter(t, l.attempt(tc.keys...)) > 0)
+		})
+	}
+}
+
+func TestLoginLimiterSucceeded(t *testing.T) {
+	l, _ := testLoginLimiter()
+
+	// Logins of other users from a shared address never add up to a lock
+	for i := 0; i < 3*loginFreeAttempts; i++ {
+		require.NoError(t, l.attempt("user:alice", "address:198.51.100.7"))
+		l.succeeded("user:alice", "address:198.51.100.7")
+	}
+
+	// A successful login forgets the failures of the user, but only takes
+	// back its own attempt of the address
+	for i := 0; i < loginFreeAttempts-1; i++ {
+		require.NoError(t, l.attempt("user:bob", "address:203.0.113.1"))
+	}
+	require.NoError(t, l.attempt("user:bob", "address:203.0.113.1"))
+	l.succeeded("user:bob", "address:203.0.113.1")
+	assert.Zero(t, l.attempts["user:bob"])
+	assert.Equal(t, loginFreeAttempts-1, l.attempts["address:203.0.113.1"].failures)
+}
+
+func TestLoginLimiterForgets(t *testing.T) {
+	l, advance := testLoginLimiter()
+	for i := 0; i < loginFreeAttempts+3; i++ {
+		advance(retryAfter(t, l.attempt("user:alice")))
+	}
+	require.Positive(t, retryAfter(t, l.attempt("user:alice")))
+
+	advance(loginFailureTTL + time.Second)
+	for i := 0; i < loginFreeAttempts; i++ {
+		require.NoError(t, l.attempt("user:alice"))
+	}
+}
+
+func TestLoginLimiterPrune(t *testing.T) {
+	l, advance := testLoginLimiter()
+	for i := 0; i <= loginPruneSize; i++ {
+		require.NoError(t, l.attempt("address:"+strconv.Itoa(i)))
+	}
+	advance(loginFailureTTL + time.Second)
+
+	require.NoError(t, l.attempt("address:new"))
+	assert.Len(t, l.attempts, 1)
+}
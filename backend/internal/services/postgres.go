@@ -1,11 +1,17 @@
 package services
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os/exec"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -13,6 +19,37 @@ import (
 	"backend/internal/models"
 )
 
+// listDatabasesQuery lists the non-template databases with their details
+const listDatabasesQuery = "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"
+
+// databaseInfoQuery looks up a single database. The name is passed as the
+// psql variable dbname, which psql quotes as a SQL literal.
+const databaseInfoQuery = "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datname = :'dbname';"
+
+// databaseNamesQuery lists the names of all databases that accept
+// connections as a JSON array, which holds any name psql could print
+const databaseNamesQuery = "SELECT coalesce(json_agg(datname ORDER BY datname), '[]') FROM pg_database WHERE datallowconn AND NOT datistemplate;"
+
+// serverVersionQuery reads the version of the PostgreSQL server
+const serverVersionQuery = "SHOW server_version;"
+
+// tableRowCountsQuery counts the rows of every user table exactly, returned
+// as a JSON object by qualified table name. query_to_xml runs the count of
+// each table without a function of our own in the database. It scans every
+// table, so it is meant for restored copies rather than production sources.
+const tableRowCountsQuery = `SELECT coalesce(json_object_agg(format('%I.%I', n.nspname, c.relname), (xpath('/row/count/text()', query_to_xml(format('SELECT count(*) FROM %I.%I', n.nspname, c.relname), false, true, '')))[1]::text::bigint), '{}') FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%';`
+
+// tableRowEstimatesQuery reads the row estimates the planner keeps for
+// every user table, returned like tableRowCountsQuery. Tables that were
+// never vacuumed or analyzed get -1; before PostgreSQL 14 they show 0 rows
+// in 0 pages, the same as analyzed empty tables.
+const tableRowEstimatesQuery = `SELECT coalesce(json_object_agg(format('%I.%I', n.nspname, c.relname), CASE WHEN c.reltuples < 0 OR (c.reltuples = 0 AND c.relpages = 0) THEN -1 ELSE c.reltuples::bigint END), '{}') FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%';`
+
+// terminateDumpQuery terminates the connections of the dump with the
+// application name substituted for %s and returns their number. The name is
+// generated by dumpApplicationName and needs no quoting.
+const terminateDumpQuery = "SELECT count(pg_terminate_backend(pid)) FROM pg_stat_activity WHERE application_name = '%s' AND pid <> pg_backend_pid();"
+
 // PostgresService handles PostgreSQL operations
 type PostgresService struct {
 	logger *logrus.Logger
@@ -38,7 +75,7 @@ func (s *PostgresService) GetDatabasesViaSSH(ctx context.Context, server *config
 	s.logger.Infof("Getting databases from container %s on server %s via SSH", containerID, server.Host)
 
 	// For local servers
-	if server.Host == "localhost" || server.Host == "127.0.0.1" || server.Host == "" {
+	if server.IsLocal() {
 		return s.getLocalDatabases(ctx, containerID)
 	}
 
@@ -48,39 +85,28 @@ func (s *PostgresService) GetDatabasesViaSSH(ctx context.Context, server *config
 	}
 
 	// Command to list databases with size information using the correct PostgreSQL user
-	dockerCmd := fmt.Sprintf(`docker exec %s psql -U %s -tAc "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"`, containerID, postgresUser)
+	dockerCmd := dockerExec(containerID, false, NewCommand("psql", "-U", postgresUser, "-tAc", listDatabasesQuery))
 
-	output, err := sshService.ExecuteRemoteCommand(server, dockerCmd)
+	output, err := sshService.ExecuteRemoteCommand(ctx, server, dockerCmd.String())
 	if err != nil {
 		// If postgres user doesn't work, try with different approaches
 		s.logger.Warnf("Failed with postgres user, trying alternative methods: %v", err)
 		
-		// Try to find the correct user by inspecting the container
-		inspectCmd := fmt.Sprintf(`docker exec %s env | grep POSTGRES_USER`, containerID)
-		userOutput, userErr := sshService.ExecuteRemoteCommand(server, inspectCmd)
+		// Try to find the correct user by inspecting the container. Only
+		// the one variable is read, the environment also holds passwords.
+		inspectCmd := dockerExec(containerID, false, NewCommand("printenv", "POSTGRES_USER"))
+		userOutput, userErr := sshService.ExecuteRemoteCommand(ctx, server, inspectCmd.String())
 		
-		if userErr == nil && strings.Contains(userOutput, "POSTGRES_USER=") {
-			// Extract username
-			lines := strings.Split(userOutput, "\n")
-			for _, line := range lines {
-				if strings.HasPrefix(line, "POSTGRES_USER=") {
-					username := strings.TrimPrefix(line, "POSTGRES_USER=")
-					username = strings.TrimSpace(username)
-					
-					// Try with the found username
-					dockerCmd = fmt.Sprintf(`docker exec %s psql -U %s -tAc "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"`, containerID, username)
-					output, err = sshService.ExecuteRemoteCommand(server, dockerCmd)
-					if err == nil {
-						break
-					}
-				}
-			}
+		if username := strings.TrimSpace(userOutput); userErr == nil && username != "" {
+			// Try with the found username
+			dockerCmd = dockerExec(containerID, false, NewCommand("psql", "-U", username, "-tAc", listDatabasesQuery))
+			output, err = sshService.ExecuteRemoteCommand(ctx, server, dockerCmd.String())
 		}
 		
 		// If still failing, try without specifying user (uses default)
 		if err != nil {
-			dockerCmd = fmt.Sprintf(`docker exec %s psql -tAc "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"`, containerID)
-			output, err = sshService.ExecuteRemoteCommand(server, dockerCmd)
+			dockerCmd = dockerExec(containerID, false, NewCommand("psql", "-tAc", listDatabasesQuery))
+			output, err = sshService.ExecuteRemoteCommand(ctx, server, dockerCmd.String())
 		}
 
 		if err != nil {
@@ -96,12 +122,14 @@ func (s *PostgresService) GetDatabasesViaSSH(ctx context.Context, server *config
 // getLocalDatabases handles local database discovery
 func (s *PostgresService) getLocalDatabases(ctx context.Context, containerID string) ([]models.DatabaseResponse, error) {
 	// Use local docker command with size information
-	cmd := exec.Command("docker", "exec", containerID, "psql", "-U", "postgres", "-tAc", "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;")
-	
+	args := dockerExec(containerID, false, NewCommand("psql", "-U", "postgres", "-tAc", listDatabasesQuery))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		// Try alternative approaches for local as well
-		cmd = exec.Command("docker", "exec", containerID, "psql", "-tAc", "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;")
+		args = dockerExec(containerID, false, NewCommand("psql", "-tAc", listDatabasesQuery))
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
 		output, err = cmd.CombinedOutput()
 		if err != nil {
 			return nil, fmt.Errorf("failed to get local databases: %w", err)
@@ -227,141 +255,343 @@ func (s *PostgresService) CreateDump(ctx context.Context, dockerHost, containerI
 }
 
 // CreateDumpViaSSH creates a PostgreSQL database dump via SSH
-func (s *PostgresService) CreateDumpViaSSH(ctx context.Context, server *config.Server, containerID, dbName string, options map[string]interface{}, sshService *SSHService) (io.ReadCloser, error) {
+func (s *PostgresService) CreateDumpViaSSH(ctx context.Context, server *config.Server, containerID, dbName string, options models.DumpOptions, sshService *SSHService) (io.ReadCloser, error) {
 	s.logger.Infof("Creating dump for database %s in container %s on server %s", dbName, containerID, server.Host)
 
+	appName, err := dumpApplicationName()
+	if err != nil {
+		return nil, err
+	}
+	abort := s.dumpAborter(server, containerID, dbName, appName, sshService)
+
 	// Build pg_dump command with options
-	dumpCmd := s.buildDumpCommand(server, containerID, dbName, options)
+	dumpCmd := s.buildDumpCommand(server, containerID, dbName, options, appName)
 
 	// For local servers
-	if server.Host == "localhost" || server.Host == "127.0.0.1" || server.Host == "" {
-		return s.createLocalDump(ctx, dumpCmd)
+	if server.IsLocal() {
+		return s.createLocalDump(ctx, dumpCmd, abort)
 	}
 
 	// For remote servers, create a streaming SSH command
-	return s.createRemoteDump(server, dumpCmd, sshService)
+	return s.createRemoteDump(ctx, server, dumpCmd, sshService, abort)
+}
+
+// ParseDumpFormat normalizes a pg_dump output format name, accepting the
+// single-letter aliases pg_dump itself understands
+func ParseDumpFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "p", models.DumpFormatPlain:
+		return models.DumpFormatPlain, nil
+	case "c", models.DumpFormatCustom:
+		return models.DumpFormatCustom, nil
+	case "t", models.DumpFormatTar:
+		return models.DumpFormatTar, nil
+	case "d", models.DumpFormatDirectory:
+		return models.DumpFormatDirectory, nil
+	default:
+		return "", fmt.Errorf("unsupported dump format %q", format)
+	}
+}
+
+// NormalizeDumpOptions validates dump options received as JSON and
+// normalizes their format and compression names
+func NormalizeDumpOptions(options models.DumpOptions) (models.DumpOptions, error) {
+	if err := ValidateDumpSelectors(options); err != nil {
+		return options, err
+	}
+
+	format, err := ParseDumpFormat(options.Format)
+	if err != nil {
+		return options, err
+	}
+	options.Format = format
+
+	compression, err := ParseCompression(options.Compression, options.CompressionLevel)
+	if err != nil {
+		return options, err
+	}
+	options.Compression = compression
+
+	encryption, err := ParseEncryption(options)
+	if err != nil {
+		return options, err
+	}
+	options.Encryption = encryption
+
+	return options, nil
+}
+
+// DumpFileExtension returns the file extension for a dump format. Directory
+// format dumps are delivered as a tarball of the directory.
+func DumpFileExtension(format string) string {
+	switch format {
+	case models.DumpFormatCustom:
+		return ".dump"
+	case models.DumpFormatTar:
+		return ".tar"
+	case models.DumpFormatDirectory:
+		return ".dir.tar"
+	default:
+		return ".sql"
+	}
+}
+
+// DumpFilename builds the file name of a dump. An empty containerID refers
+// to a host database.
+func DumpFilename(serverID, containerID, dbName string, options models.DumpOptions) string {
+	extension := DumpFileExtension(options.Format) + CompressionExtension(options.Compression) + EncryptionExtension(options.Encryption)
+	if containerID == "" {
+		return fmt.Sprintf("%s_host_%s%s", serverID, dbName, extension)
+	}
+	if len(containerID) > 8 {
+		containerID = containerID[:8]
+	}
+	return fmt.Sprintf("%s_%s_%s%s", serverID, containerID, dbName, extension)
 }
 
 // buildDumpCommand builds the pg_dump command with options
-func (s *PostgresService) buildDumpCommand(server *config.Server, containerID, dbName string, options map[string]interface{}) string {
+func (s *PostgresService) buildDumpCommand(server *config.Server, containerID, dbName string, options models.DumpOptions, appName string) string {
 	postgresUser := "postgres"
 	if server.PostgresUser != "" {
 		postgresUser = server.PostgresUser
 	}
-	
-	// This should generate: docker exec -t 26b181849372 pg_dump -U postgres -d srm_hr
-	cmd := fmt.Sprintf("docker exec -t %s pg_dump -U %s -d %s", containerID, postgresUser, dbName)
-	
+
+	// This should generate: docker exec 26b181849372 env PGAPPNAME=pgm-dump-... pg_dump -U postgres -d 'dbname='\''srm_hr'\'''
+	dumpCmd := dumpEnv(appName).With("pg_dump", "-U", postgresUser, "-d", databaseArg(dbName)).With(s.dumpFlags(options)...)
+
+	var cmd Command
+	if options.Format == models.DumpFormatDirectory {
+		cmd = dockerExec(containerID, false, shellScript(directoryDumpScript(dumpCmd, dbName)))
+	} else {
+		cmd = dockerExec(containerID, false, dumpCmd)
+	}
+
+	s.logger.Debugf("Built dump command: %s", cmd)
+	return cmd.String()
+}
+
+// dumpFlags renders the pg_dump flags for the given options
+func (s *PostgresService) dumpFlags(options models.DumpOptions) []string {
+	var flags []string
+
 	// Add dump options
-	if dataOnly, exists := options["data_only"]; exists && dataOnly.(bool) {
-		cmd += " --data-only"
+	if options.DataOnly {
+		flags = append(flags, "--data-only")
 	}
-	
-	if schemaOnly, exists := options["schema_only"]; exists && schemaOnly.(bool) {
-		cmd += " --schema-only"
+
+	if options.SchemaOnly {
+		flags = append(flags, "--schema-only")
 	}
-	
-	s.logger.Infof("Built dump command: %s", cmd)
-	return cmd
+
+	if options.Format != "" && options.Format != models.DumpFormatPlain {
+		flags = append(flags, "--format="+options.Format)
+	}
+
+	// Add table and schema selectors
+	flags = append(flags, selectorFlags(options)...)
+
+	return flags
+}
+
+// directoryDumpScript wraps a directory-format pg_dump so that the dump is
+// written to a scratch directory and streamed back as a tarball on stdout.
+// The exit status of pg_dump (or tar) is preserved and the directory is
+// always removed.
+func directoryDumpScript(dumpCmd Command, dbName string) string {
+	name := quoteArg(dbName)
+	return fmt.Sprintf(`d=$(mktemp -d) || exit 1; %s -f "$d"/%s && tar -C "$d" -cf - %s; rc=$?; rm -rf "$d"; exit $rc`, dumpCmd, name, name)
 }
 
-// createLocalDump creates a dump using local docker command
-func (s *PostgresService) createLocalDump(ctx context.Context, dumpCmd string) (io.ReadCloser, error) {
-	// Split command for exec.CommandContext
-	parts := strings.Fields(dumpCmd)
-	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+// dumpEnv prefixes pg_dump so that its connections carry appName, which
+// identifies them when an unfinished dump has to be stopped
+func dumpEnv(appName string) Command {
+	return NewCommand("env", "PGAPPNAME="+appName)
+}
+
+// dumpApplicationName returns a unique application name for the
+// connections of one dump
+func dumpApplicationName() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate dump name: %w", err)
+	}
+	return "pgm-dump-" + hex.EncodeToString(buf), nil
+}
+
+// dumpAbortTimeout bounds stopping an unfinished dump on its server
+const dumpAbortTimeout = 30 * time.Second
+
+// dumpAborter returns a function that stops the dump with connections named
+// appName on its PostgreSQL server. Killing the local end of a dump does not
+// reach pg_dump: docker exec forwards no signals and SSH sessions without a
+// terminal leave their commands running. Terminating the backends of the
+// dump makes pg_dump fail on its own, wherever it runs.
+func (s *PostgresService) dumpAborter(server *config.Server, containerID, dbName, appName string, sshService *SSHService) func() {
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), dumpAbortTimeout)
+		defer cancel()
+
+		query := fmt.Sprintf(terminateDumpQuery, appName)
+		output, err := s.runQuery(ctx, server, psqlCommand(server, containerID, dbName), query, sshService)
+		if err != nil {
+			s.logger.Errorf("Failed to stop dump %s of database %s on server %s: %v", appName, dbName, server.ID, err)
+			return
+		}
+		s.logger.Infof("Stopped dump %s of database %s on server %s (%s connections terminated)", appName, dbName, server.ID, strings.TrimSpace(output))
+	}
+}
+
+// createLocalDump creates a dump using local docker command. abort is
+// called when the dump is closed before it finished.
+func (s *PostgresService) createLocalDump(ctx context.Context, dumpCmd string, abort func()) (io.ReadCloser, error) {
+	// Run through the shell so quoted arguments and wrapper scripts behave
+	// exactly as they do on remote servers
+	cmd := exec.CommandContext(ctx, "sh", "-c", dumpCmd)
 	
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
+
+	// Capture stderr so a failing dump can be reported with its cause
+	stderr := &tailBuffer{limit: maxDumpStderr}
+	cmd.Stderr = stderr
 	
 	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start dump command: %w", err)
 	}
+
+	// Killing the shell leaves docker exec writing to the pipe, so reads
+	// are stopped by closing it
+	stop := context.AfterFunc(ctx, func() {
+		stdout.Close()
+	})
 	
 	// Return a custom reader that waits for the command to finish
 	return &localDumpReader{
 		ReadCloser: stdout,
 		cmd:        cmd,
+		stderr:     stderr,
+		abort:      abort,
+		stop:       stop,
 		logger:     s.logger,
 	}, nil
 }
 
-// createRemoteDump creates a dump using SSH command
-func (s *PostgresService) createRemoteDump(server *config.Server, dumpCmd string, sshService *SSHService) (io.ReadCloser, error) {
-	// Build SSH target with username
-	var sshTarget string
-	if server.Username != "" {
-		sshTarget = fmt.Sprintf("%s@%s", server.Username, server.Host)
-	} else {
-		sshTarget = server.Host
-	}
-	
-	s.logger.Infof("Creating remote dump via SSH: %s with command: %s", sshTarget, dumpCmd)
-	
-	// Create SSH command with private key authentication
-	var sshCmd *exec.Cmd
-	if server.PrivateKey != "" {
-		sshCmd = exec.Command("ssh", "-i", server.PrivateKey, "-o", "StrictHostKeyChecking=no", sshTarget, dumpCmd)
-	} else {
-		sshCmd = exec.Command("ssh", "-o", "StrictHostKeyChecking=no", sshTarget, dumpCmd)
-	}
-	
-	stdout, err := sshCmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH stdout pipe: %w", err)
-	}
-	
-	// Also capture stderr for debugging
-	stderr, err := sshCmd.StderrPipe()
+// createRemoteDump creates a dump by running the dump command in an SSH
+// session. abort is called when the dump is closed before it finished.
+func (s *PostgresService) createRemoteDump(ctx context.Context, server *config.Server, dumpCmd string, sshService *SSHService, abort func()) (io.ReadCloser, error) {
+	s.logger.Debugf("Creating remote dump via SSH on %s with command: %s", server.Host, dumpCmd)
+
+	// Capture stderr of pg_dump so a failing dump can be reported with its cause
+	stderr := &tailBuffer{limit: maxDumpStderr}
+
+	remoteCmd, err := sshService.StartRemoteCommand(ctx, server, dumpCmd, stderr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH stderr pipe: %w", err)
-	}
-	
-	// Log stderr in a goroutine
-	go func() {
-		stderrOutput := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(stderrOutput)
-			if n > 0 {
-				s.logger.Errorf("SSH stderr: %s", string(stderrOutput[:n]))
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
-	
-	if err := sshCmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start SSH dump command: %w", err)
 	}
-	
-	// Return a custom reader that waits for the SSH command to finish
+
+	// Return a custom reader that waits for the remote command to finish
 	return &remoteDumpReader{
-		ReadCloser: stdout,
-		cmd:        sshCmd,
-		logger:     s.logger,
+		cmd:    remoteCmd,
+		stderr: stderr,
+		abort:  abort,
+		logger: s.logger,
 	}, nil
 }
 
+// maxDumpStderr bounds how much stderr output of a dump command is kept
+const maxDumpStderr = 16 * 1024
+
+// DumpError reports a dump command that exited unsuccessfully, along with
+// what it wrote to stderr
+type DumpError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *DumpError) Error() string {
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		return fmt.Sprintf("%v: %s", e.Err, stderr)
+	}
+	return e.Err.Error()
+}
+
+func (e *DumpError) Unwrap() error {
+	return e.Err
+}
+
+// AwaitDumpOutput blocks until a dump stream produced its first bytes. If the
+// dump ends before that, it is closed and its failure is returned, so callers
+// can report errors before committing to a response.
+func AwaitDumpOutput(dumpReader io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(dumpReader, 64*1024)
+	if _, err := buffered.Peek(1); err != nil {
+		closeErr := dumpReader.Close()
+		if closeErr != nil {
+			return nil, closeErr
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("dump command produced no output")
+		}
+		return nil, fmt.Errorf("failed to read dump: %w", err)
+	}
+
+	return &bufferedDumpReader{Reader: buffered, closer: dumpReader}, nil
+}
+
+// bufferedDumpReader reads through a buffer while closing the underlying dump
+type bufferedDumpReader struct {
+	*bufio.Reader
+	closer io.Closer
+}
+
+func (r *bufferedDumpReader) Close() error {
+	return r.closer.Close()
+}
+
 // localDumpReader wraps local command execution for streaming
 type localDumpReader struct {
 	io.ReadCloser
 	cmd    *exec.Cmd
+	stderr *tailBuffer
+	abort  func()
+	stop   func() bool
+	// eof is set by Read and checked by Close, which compressors call from
+	// another goroutine
+	eof    atomic.Bool
 	logger *logrus.Logger
 }
 
+func (r *localDumpReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof.Store(true)
+	}
+	return n, err
+}
+
 func (r *localDumpReader) Close() error {
-	// Close the pipe first
-	if err := r.ReadCloser.Close(); err != nil {
-		r.logger.Errorf("Error closing dump reader: %v", err)
+	// Close the pipe first, unless cancellation already did
+	if r.stop() {
+		if err := r.ReadCloser.Close(); err != nil {
+			r.logger.Errorf("Error closing dump reader: %v", err)
+		}
 	}
 	
 	// Wait for command to finish
-	if err := r.cmd.Wait(); err != nil {
-		r.logger.Errorf("Dump command failed: %v", err)
-		return err
+	err := r.cmd.Wait()
+	if !r.eof.Load() {
+		r.abort()
+	}
+	if err != nil {
+		dumpErr := &DumpError{Err: err, Stderr: r.stderr.String()}
+		r.logger.Errorf("Dump command failed: %v", dumpErr)
+		return dumpErr
+	}
+
+	if stderr := strings.TrimSpace(r.stderr.String()); stderr != "" {
+		r.logger.Warnf("Dump command stderr: %s", stderr)
 	}
 	
 	return nil
@@ -369,21 +599,37 @@ func (r *localDumpReader) Close() error {
 
 // remoteDumpReader wraps SSH command execution for streaming
 type remoteDumpReader struct {
-	io.ReadCloser
-	cmd    *exec.Cmd
+	cmd    *RemoteCommand
+	stderr *tailBuffer
+	abort  func()
+	// eof is atomic for the same reason as in localDumpReader
+	eof    atomic.Bool
 	logger *logrus.Logger
 }
 
+func (r *remoteDumpReader) Read(p []byte) (int, error) {
+	n, err := r.cmd.Read(p)
+	if err == io.EOF {
+		r.eof.Store(true)
+	}
+	return n, err
+}
+
 func (r *remoteDumpReader) Close() error {
-	// Close the pipe first
-	if err := r.ReadCloser.Close(); err != nil {
-		r.logger.Errorf("Error closing SSH dump reader: %v", err)
+	// Wait for the remote command to finish, which closing the session
+	// early does not stop on the server
+	err := r.cmd.Close()
+	if !r.eof.Load() {
+		r.abort()
 	}
-	
-	// Wait for SSH command to finish
-	if err := r.cmd.Wait(); err != nil {
-		r.logger.Errorf("SSH dump command failed: %v", err)
-		return err
+	if err != nil {
+		dumpErr := &DumpError{Err: err, Stderr: r.stderr.String()}
+		r.logger.Errorf("SSH dump command failed: %v", dumpErr)
+		return dumpErr
+	}
+
+	if stderr := strings.TrimSpace(r.stderr.String()); stderr != "" {
+		r.logger.Warnf("SSH dump command stderr: %s", stderr)
 	}
 	
 	return nil
@@ -391,28 +637,142 @@ func (r *remoteDumpReader) Close() error {
 
 // GetDatabaseInfo gets detailed information about a specific database
 func (s *PostgresService) GetDatabaseInfo(ctx context.Context, server *config.Server, containerID, dbName string, sshService *SSHService) (*models.DatabaseResponse, error) {
-	query := fmt.Sprintf(`SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datname = '%s'`, dbName)
-	
 	postgresUser := "postgres"
 	if server.PostgresUser != "" {
 		postgresUser = server.PostgresUser
 	}
-	
-	if server.Host == "localhost" || server.Host == "127.0.0.1" || server.Host == "" {
-		execCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "psql", "-U", postgresUser, "-tAc", query)
-		output, err := execCmd.CombinedOutput()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get database info: %w", err)
-		}
-		return s.parseDatabaseInfo(string(output))
+
+	// psql only expands variables in input it reads, not in -c, so the
+	// query is sent on stdin
+	psql := NewCommand("psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", "-v", "dbname="+dbName, "-U", postgresUser, "-tA")
+	output, err := s.runQuery(ctx, server, dockerExec(containerID, true, psql), databaseInfoQuery, sshService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database info: %w", err)
+	}
+	return s.parseDatabaseInfo(output)
+}
+
+// DatabaseNames returns the names of all databases of a container, or of
+// host PostgreSQL for an empty containerID. Unlike the database listing it
+// leaves out no names.
+func (s *PostgresService) DatabaseNames(ctx context.Context, server *config.Server, containerID string, sshService *SSHService) ([]string, error) {
+	// template1 is the one database that is always there to connect to
+	output, err := s.runQuery(ctx, server, psqlCommand(server, containerID, "template1"), databaseNamesQuery, sshService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &names); err != nil {
+		return nil, fmt.Errorf("unexpected database list output: %w", err)
+	}
+	return names, nil
+}
+
+// GetServerVersion returns the version of the PostgreSQL server holding a
+// database, of host PostgreSQL for an empty containerID
+func (s *PostgresService) GetServerVersion(ctx context.Context, server *config.Server, containerID, dbName string, sshService *SSHService) (string, error) {
+	output, err := s.runQuery(ctx, server, psqlCommand(server, containerID, dbName), serverVersionQuery, sshService)
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// TableRowCounts returns the exact number of rows of every user table of a
+// database by qualified table name
+func (s *PostgresService) TableRowCounts(ctx context.Context, server *config.Server, containerID, dbName string, sshService *SSHService) (map[string]int64, error) {
+	output, err := s.runQuery(ctx, server, psqlCommand(server, containerID, dbName), tableRowCountsQuery, sshService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows: %w", err)
+	}
+	return parseTableRows(output)
+}
+
+// TableRowEstimates returns the planner's estimate of the rows of every user
+// table of a database by qualified table name, -1 if there is none. Unlike
+// counting the rows, it only reads the system catalog.
+func (s *PostgresService) TableRowEstimates(ctx context.Context, server *config.Server, containerID, dbName string, sshService *SSHService) (map[string]int64, error) {
+	output, err := s.runQuery(ctx, server, psqlCommand(server, containerID, dbName), tableRowEstimatesQuery, sshService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read row estimates: %w", err)
+	}
+	return parseTableRows(output)
+}
+
+// parseTableRows parses the JSON object of rows by table name returned by
+// tableRowCountsQuery and tableRowEstimatesQuery
+func parseTableRows(output string) (map[string]int64, error) {
+	rows := make(map[string]int64)
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &rows); err != nil {
+		return nil, fmt.Errorf("unexpected row count output: %w", err)
+	}
+	return rows, nil
+}
+
+// DumpRoles returns the roles of a PostgreSQL server as SQL, without their
+// passwords, so that restores elsewhere find the owners of the objects
+func (s *PostgresService) DumpRoles(ctx context.Context, server *config.Server, containerID string, sshService *SSHService) (string, error) {
+	postgresUser := "postgres"
+	if server.PostgresUser != "" {
+		postgresUser = server.PostgresUser
+	}
+
+	dumpall := NewCommand("pg_dumpall", "--roles-only", "--no-role-passwords")
+	var cmd Command
+	if containerID != "" {
+		cmd = dockerExec(containerID, false, dumpall.With("-U", postgresUser))
 	} else {
-		cmd := fmt.Sprintf(`docker exec %s psql -U %s -tAc "%s"`, containerID, postgresUser, query)
-		output, err := sshService.ExecuteRemoteCommand(server, cmd)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get database info via SSH: %w", err)
+		cmd = sudoAs(postgresUser, dumpall)
+	}
+
+	output, err := s.runQuery(ctx, server, cmd, "", sshService)
+	if err != nil {
+		return "", fmt.Errorf("failed to dump roles: %w", err)
+	}
+	return output, nil
+}
+
+// psqlCommand builds a psql invocation reading a query from stdin in a
+// database of a container, or of host PostgreSQL for an empty containerID
+func psqlCommand(server *config.Server, containerID, dbName string) Command {
+	postgresUser := "postgres"
+	if server.PostgresUser != "" {
+		postgresUser = server.PostgresUser
+	}
+
+	psql := NewCommand("psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", "-d", databaseArg(dbName), "-tA")
+	if containerID != "" {
+		return dockerExec(containerID, true, psql.With("-U", postgresUser))
+	}
+	// Host PostgreSQL is reached as the OS user through peer authentication
+	return sudoAs(postgresUser, psql)
+}
+
+// runQuery runs a psql command locally or over SSH, feeding it query on
+// stdin, and returns what it printed
+func (s *PostgresService) runQuery(ctx context.Context, server *config.Server, cmd Command, query string, sshService *SSHService) (string, error) {
+	var stdout strings.Builder
+	stderr := &tailBuffer{limit: maxDumpStderr}
+
+	var err error
+	if server.IsLocal() {
+		execCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+		execCmd.Stdin = strings.NewReader(query)
+		execCmd.Stdout = &stdout
+		execCmd.Stderr = stderr
+		err = execCmd.Run()
+	} else {
+		err = sshService.RunRemoteCommand(ctx, server, cmd.String(), strings.NewReader(query), &stdout, stderr)
+	}
+	if err != nil {
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return "", fmt.Errorf("%w: %s", err, output)
 		}
-		return s.parseDatabaseInfo(output)
+		return "", err
 	}
+
+	return stdout.String(), nil
 }
 
 // parseDatabaseInfo parses detailed database information
@@ -441,7 +801,7 @@ func (s *PostgresService) GetHostPostgreSQLDatabases(ctx context.Context, server
     s.logger.Infof("Getting host PostgreSQL databases from server %s", server.Host)
 
     // For local servers
-    if server.Host == "localhost" || server.Host == "127.0.0.1" || server.Host == "" {
+    if server.IsLocal() {
         return s.getLocalHostDatabases(ctx)
     }
 
@@ -452,19 +812,19 @@ func (s *PostgresService) GetHostPostgreSQLDatabases(ctx context.Context, server
     }
 
     // Command to list databases from host PostgreSQL with proper working directory
-    cmd := fmt.Sprintf(`cd /tmp && sudo -u %s psql -tAc "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"`, postgresUser)
+    cmd := "cd /tmp && " + sudoAs(postgresUser, NewCommand("psql", "-tAc", listDatabasesQuery)).String()
 
-    output, err := sshService.ExecuteRemoteCommand(server, cmd)
+    output, err := sshService.ExecuteRemoteCommand(ctx, server, cmd)
     if err != nil {
         s.logger.Warnf("First attempt failed: %v. Trying alternative method...", err)
         // Try alternative methods if sudo doesn't work
-        cmd = fmt.Sprintf(`cd /tmp && psql -U %s -tAc "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"`, postgresUser)
-        output, err = sshService.ExecuteRemoteCommand(server, cmd)
+        cmd = "cd /tmp && " + NewCommand("psql", "-U", postgresUser, "-tAc", listDatabasesQuery).String()
+        output, err = sshService.ExecuteRemoteCommand(ctx, server, cmd)
         if err != nil {
             s.logger.Warnf("Alternative method failed: %v. Trying without user specification...", err)
             // Final fallback - try with default connection
-            cmd = `cd /tmp && psql -tAc "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;"`
-            output, err = sshService.ExecuteRemoteCommand(server, cmd)
+            cmd = "cd /tmp && " + NewCommand("psql", "-tAc", listDatabasesQuery).String()
+            output, err = sshService.ExecuteRemoteCommand(ctx, server, cmd)
             if err != nil {
                 s.logger.Errorf("All PostgreSQL connection attempts failed: %v", err)
                 return nil, fmt.Errorf("PostgreSQL not found on host or access denied: %w", err)
@@ -493,42 +853,47 @@ func (s *PostgresService) GetHostPostgreSQLDatabases(ctx context.Context, server
 }
 
 // CreateHostDumpViaSSH creates a dump from host PostgreSQL
-func (s *PostgresService) CreateHostDumpViaSSH(ctx context.Context, server *config.Server, dbName string, options map[string]interface{}, sshService *SSHService) (io.ReadCloser, error) {
+func (s *PostgresService) CreateHostDumpViaSSH(ctx context.Context, server *config.Server, dbName string, options models.DumpOptions, sshService *SSHService) (io.ReadCloser, error) {
     s.logger.Infof("Creating host dump for database %s on server %s", dbName, server.Host)
 
+    appName, err := dumpApplicationName()
+    if err != nil {
+        return nil, err
+    }
+    abort := s.dumpAborter(server, "", dbName, appName, sshService)
+
     // Build host pg_dump command
-    dumpCmd := s.buildHostDumpCommand(server, dbName, options)
+    dumpCmd := s.buildHostDumpCommand(server, dbName, options, appName)
 
     // For local servers
-    if server.Host == "localhost" || server.Host == "127.0.0.1" || server.Host == "" {
-        return s.createLocalDump(ctx, dumpCmd)
+    if server.IsLocal() {
+        return s.createLocalDump(ctx, dumpCmd, abort)
     }
 
     // For remote servers, create a streaming SSH command
-    return s.createRemoteDump(server, dumpCmd, sshService)
+    return s.createRemoteDump(ctx, server, dumpCmd, sshService, abort)
 }
 
 // buildHostDumpCommand builds pg_dump command for host PostgreSQL
-func (s *PostgresService) buildHostDumpCommand(server *config.Server, dbName string, options map[string]interface{}) string {
+func (s *PostgresService) buildHostDumpCommand(server *config.Server, dbName string, options models.DumpOptions, appName string) string {
     postgresUser := "postgres"
     if server.PostgresUser != "" {
         postgresUser = server.PostgresUser
     }
 
     // Host PostgreSQL command (no docker exec)
-    cmd := fmt.Sprintf("sudo -u %s pg_dump -d %s", postgresUser, dbName)
-
-    // Add dump options
-    if dataOnly, exists := options["data_only"]; exists && dataOnly.(bool) {
-        cmd += " --data-only"
-    }
-
-    if schemaOnly, exists := options["schema_only"]; exists && schemaOnly.(bool) {
-        cmd += " --schema-only"
+    dumpCmd := dumpEnv(appName).With("pg_dump", "-d", databaseArg(dbName)).With(s.dumpFlags(options)...)
+
+    var cmd Command
+    if options.Format == models.DumpFormatDirectory {
+        // The scratch directory must be writable by the postgres user
+        cmd = sudoAs(postgresUser, shellScript(directoryDumpScript(dumpCmd, dbName)))
+    } else {
+        cmd = sudoAs(postgresUser, dumpCmd)
     }
 
-    s.logger.Infof("Built host dump command: %s", cmd)
-    return cmd
+    s.logger.Debugf("Built host dump command: %s", cmd)
+    return cmd.String()
 }
 
 // containsErrorMessages checks if output contains common error patterns
@@ -557,7 +922,7 @@ func (s *PostgresService) containsErrorMessages(output string) bool {
 
 // getLocalHostDatabases handles local host PostgreSQL
 func (s *PostgresService) getLocalHostDatabases(ctx context.Context) ([]models.DatabaseResponse, error) {
-    cmd := exec.Command("psql", "-U", "postgres", "-tAc", "SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), pg_size_pretty(pg_database_size(d.datname)) FROM pg_database d JOIN pg_roles r ON d.datdba = r.oid WHERE d.datistemplate = false;")
+    cmd := exec.CommandContext(ctx, "psql", "-U", "postgres", "-tAc", listDatabasesQuery)
     output, err := cmd.CombinedOutput()
     if err != nil {
         s.logger.Errorf("Failed to execute local PostgreSQL command: %v", err)
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"backend/internal/config"
+)
+
+// Permissions granted by roles. Every permission includes PermissionList for
+// the resources it applies to, PermissionDump includes
+// PermissionSchemaOnly and PermissionAdmin includes everything.
+const (
+	PermissionList       = "list"
+	PermissionDump       = "dump"
+	PermissionSchemaOnly = "schema_only"
+	PermissionRestore    = "restore"
+	PermissionAdmin      = "admin"
+)
+
+// HostContainerName is the container name host PostgreSQL is matched as in
+// role patterns. Docker container names cannot start with "@".
+const HostContainerName = "@host"
+
+// AdminRole is the built-in role granting every permission on everything,
+// unless a role of the same name is configured
+const AdminRole = "admin"
+
+// Resource is what a permission is checked against. An empty container or
+// database matches if the role grants the permission on any of them, which
+// is used before the container or database of a request is known.
+type Resource struct {
+	ServerID  string
+	Container string
+	Database  string
+}
+
+// role is a configured role with its permissions expanded
+type role struct {
+	permissions map[string]bool
+	servers     []string
+	containers  []string
+	databases   []string
+}
+
+// RBAC decides which permissions callers have on which resources, based on
+// the roles assigned to their token or user
+type RBAC struct {
+	roles map[string]role
+}
+
+// NewRBAC creates the access control from the configured roles
+func NewRBAC(roles []config.Role) (*RBAC, error) {
+	r := &RBAC{roles: map[string]role{
+		AdminRole: {permissions: map[string]bool{PermissionAdmin: true}},
+	}}
+
+	for _, cfg := range roles {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("role without a name")
+		}
+
+		permissions := make(map[string]bool)
+		for _, permission := range cfg.Permissions {
+			switch permission {
+			case PermissionList, PermissionDump, PermissionSchemaOnly, PermissionRestore, PermissionAdmin:
+				permissions[permission] = true
+			default:
+				return nil, fmt.Errorf("role %q: unknown permission %q", cfg.Name, permission)
+			}
+		}
+
+		for _, patterns := range [][]string{cfg.Servers, cfg.Containers, cfg.Databases} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("role %q: invalid pattern %q: %w", cfg.Name, pattern, err)
+				}
+			}
+		}
+
+		r.roles[cfg.Name] = role{
+			permissions: permissions,
+			servers:     cfg.Servers,
+			containers:  cfg.Containers,
+			databases:   cfg.Databases,
+		}
+	}
+
+	return r, nil
+}
+
+// CheckRoles returns an error for role names that are not defined
+func (r *RBAC) CheckRoles(roles []string) error {
+	for _, name := range roles {
+		if _, exists := r.roles[name]; !exists {
+			return fmt.Errorf("unknown role %q", name)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether any of the roles grants permission on resource
+func (r *RBAC) Allowed(roles []string, permission string, resource Resource) bool {
+	for _, name := range roles {
+		role, exists := r.roles[name]
+		if !exists || !role.grants(permission) {
+			continue
+		}
+		if !config.MatchesAny(role.servers, resource.ServerID) {
+			continue
+		}
+		if resource.Container != "" && !config.MatchesAny(role.containers, resource.Container) {
+			continue
+		}
+		if resource.Database != "" && !config.MatchesAny(role.databases, resource.Database) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// AllowedEverywhere reports whether any of the roles grants permission on
+// every server, container and database, as needed for actions that are not
+// bound to one of them. Unlike Allowed with an empty resource, roles limited
+// to some containers or databases do not count.
+func (r *RBAC) AllowedEverywhere(roles []string, permission string) bool {
+	for _, name := range roles {
+		role, exists := r.roles[name]
+		if !exists || !role.grants(permission) {
+			continue
+		}
+		if matchesEverything(role.servers) && matchesEverything(role.containers) && matchesEverything(role.databases) {
+			return true
+		}
+	}
+	return false
+}
+
+// grants reports whether the role includes a permission
+func (r role) grants(permission string) bool {
+	switch {
+	case r.permissions[PermissionAdmin] || r.permissions[permission]:
+		return true
+	case permission == PermissionList:
+		return len(r.permissions) > 0
+	case permission == PermissionSchemaOnly:
+		return r.permissions[PermissionDump]
+	default:
+		return false
+	}
+}
+
+// matchesEverything reports whether a pattern list matches every value
+func matchesEverything(patterns []string) bool {
+	return len(patterns) == 0 || slices.Contains(patterns, "*")
+}
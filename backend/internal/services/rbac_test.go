@@ -0,0 +1,137 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+)
+
+func testRBAC(t *testing.T) *RBAC {
+	t.Helper()
+	rbac, err := NewRBAC([]config.Role{
+		{Name: "viewer", Permissions: []string{PermissionList}},
+		{Name: "staging-dumps", Permissions: []string{PermissionDump}, Servers: []string{"staging-*"}, Databases: []string{"app_*"}},
+		{Name: "schema", Permissions: []string{PermissionSchemaOnly}, Servers: []string{"prod-1"}},
+		{Name: "restorer", Permissions: []string{PermissionRestore}, Servers: []string{"dev"}, Containers: []string{"pg-?", HostContainerName}},
+		{Name: "prod-admin", Permissions: []string{PermissionAdmin}, Servers: []string{"prod-*"}},
+		{Name: "app-admin", Permissions: []string{PermissionAdmin}, Servers: []string{"*"}, Containers: []string{"app"}},
+		{Name: "star-admin", Permissions: []string{PermissionAdmin}, Servers: []string{"*"}, Databases: []string{"*"}},
+		{Name: "nothing"},
+	})
+	require.NoError(t, err)
+	return rbac
+}
+
+func TestNewRBAC(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		roles []config.Role
+		err   string
+	}{
+		{"valid", []config.Role{{Name: "dumps", Permissions: []string{PermissionDump}, Servers: []string{"prod-[0-9]"}}}, ""},
+		{"no name", []config.Role{{Permissions: []string{PermissionList}}}, "role without a name"},
+		{"unknown permission", []config.Role{{Name: "r", Permissions: []string{"write"}}}, `unknown permission "write"`},
+		{"invalid server pattern", []config.Role{{Name: "r", Servers: []string{"prod-["}}}, `invalid pattern "prod-["`},
+		{"invalid database pattern", []config.Role{{Name: "r", Databases: []string{`app\`}}}, "invalid pattern"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRBAC(tc.roles)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestRBACCheckRoles(t *testing.T) {
+	rbac := testRBAC(t)
+	assert.NoError(t, rbac.CheckRoles([]string{AdminRole, "viewer"}))
+	assert.ErrorContains(t, rbac.CheckRoles([]string{"viewer", "editor"}), `unknown role "editor"`)
+}
+
+func TestRBACAllowed(t *testing.T) {
+	rbac := testRBAC(t)
+
+	for _, tc := range []struct {
+		name       string
+		roles      []string
+		permission string
+		resource   Resource
+		allowed    bool
+	}{
+		// Empty pattern lists match everything
+		{"viewer lists anything", []string{"viewer"}, PermissionList, Resource{ServerID: "prod-1", Container: "db", Database: "hr"}, true},
+		{"viewer cannot dump", []string{"viewer"}, PermissionDump, Resource{ServerID: "prod-1"}, false},
+
+		// Glob patterns
+		{"server glob", []string{"staging-dumps"}, PermissionDump, Resource{ServerID: "staging-eu", Database: "app_main"}, true},
+		{"server glob mismatch", []string{"staging-dumps"}, PermissionDump, Resource{ServerID: "prod-eu", Database: "app_main"}, false},
+		{"database glob mismatch", []string{"staging-dumps"}, PermissionDump, Resource{ServerID: "staging-eu", Database: "hr"}, false},
+		{"single character glob", []string{"restorer"}, PermissionRestore, Resource{ServerID: "dev", Container: "pg-1"}, true},
+		{"single character glob is one character", []string{"restorer"}, PermissionRestore, Resource{ServerID: "dev", Container: "pg-10"}, false},
+		{"host container name", []string{"restorer"}, PermissionRestore, Resource{ServerID: "dev", Container: HostContainerName}, true},
+
+		// Exact patterns and *
+		{"exact server", []string{"schema"}, PermissionSchemaOnly, Resource{ServerID: "prod-1"}, true},
+		{"exact server is no prefix", []string{"schema"}, PermissionSchemaOnly, Resource{ServerID: "prod-10"}, false},
+		{"star server", []string{"app-admin"}, PermissionRestore, Resource{ServerID: "anything", Container: "app"}, true},
+		{"star does not match a slash", []string{"star-admin"}, PermissionDump, Resource{ServerID: "prod", Database: "a/b"}, false},
+
+		// An empty container or database matches if any of them is granted
+		{"unknown container", []string{"app-admin"}, PermissionAdmin, Resource{ServerID: "prod-1"}, true},
+		{"other container", []string{"app-admin"}, PermissionAdmin, Resource{ServerID: "prod-1", Container: "web"}, false},
+
+		// Permission order
+		{"any permission includes list", []string{"restorer"}, PermissionList, Resource{ServerID: "dev", Container: "pg-2"}, true},
+		{"list only where granted", []string{"restorer"}, PermissionList, Resource{ServerID: "prod-1"}, false},
+		{"dump includes schema_only", []string{"staging-dumps"}, PermissionSchemaOnly, Resource{ServerID: "staging-1", Database: "app_x"}, true},
+		{"schema_only does not include dump", []string{"schema"}, PermissionDump, Resource{ServerID: "prod-1"}, false},
+		{"dump does not include restore", []string{"staging-dumps"}, PermissionRestore, Resource{ServerID: "staging-1", Database: "app_x"}, false},
+		{"restore does not include dump", []string{"restorer"}, PermissionDump, Resource{ServerID: "dev", Container: "pg-1"}, false},
+		{"admin includes restore", []string{"prod-admin"}, PermissionRestore, Resource{ServerID: "prod-2", Container: "db", Database: "hr"}, true},
+		{"admin includes dump", []string{"prod-admin"}, PermissionDump, Resource{ServerID: "prod-2"}, true},
+		{"admin only on its servers", []string{"prod-admin"}, PermissionList, Resource{ServerID: "staging-1"}, false},
+		{"role without permissions", []string{"nothing"}, PermissionList, Resource{ServerID: "prod-1"}, false},
+
+		// Roles
+		{"built-in admin", []string{AdminRole}, PermissionAdmin, Resource{ServerID: "any", Container: "c", Database: "d"}, true},
+		{"any role may grant", []string{"viewer", "staging-dumps"}, PermissionDump, Resource{ServerID: "staging-1", Database: "app_a"}, true},
+		{"unknown role", []string{"editor"}, PermissionList, Resource{ServerID: "prod-1"}, false},
+		{"no roles", nil, PermissionList, Resource{ServerID: "prod-1"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, rbac.Allowed(tc.roles, tc.permission, tc.resource))
+		})
+	}
+}
+
+func TestRBACAllowedEverywhere(t *testing.T) {
+	rbac := testRBAC(t)
+
+	for _, tc := range []struct {
+		name    string
+		roles   []string
+		allowed bool
+	}{
+		{"built-in admin", []string{AdminRole}, true},
+		{"star patterns", []string{"star-admin"}, true},
+		{"admin of some servers", []string{"prod-admin"}, false},
+		{"admin of some containers", []string{"app-admin"}, false},
+		{"unrestricted without admin", []string{"viewer"}, false},
+		{"any role may grant", []string{"viewer", "star-admin"}, true},
+		{"no roles", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, rbac.AllowedEverywhere(tc.roles, PermissionAdmin))
+		})
+	}
+
+	// Allowed with an empty resource lets container admins through, which is
+	// why global checks must not use it
+	assert.True(t, rbac.Allowed([]string{"app-admin"}, PermissionAdmin, Resource{}))
+}
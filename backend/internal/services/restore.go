@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// maxRestoreOutput bounds how much psql/pg_restore output is kept for the response
+const maxRestoreOutput = 64 * 1024
+
+// customFormatMagic is the header every pg_dump custom format archive starts with
+var customFormatMagic = []byte("PGDMP")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ParseRestoreFormat normalizes the format of an uploaded dump. An empty
+// format means the format is detected from the upload itself.
+func ParseRestoreFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "auto":
+		return "", nil
+	case "p", models.DumpFormatPlain, "sql":
+		return models.DumpFormatPlain, nil
+	case "c", models.DumpFormatCustom:
+		return models.DumpFormatCustom, nil
+	default:
+		return "", fmt.Errorf("unsupported restore format %q", format)
+	}
+}
+
+// ValidateRestoreOptions checks that the restore options can be combined
+func ValidateRestoreOptions(options models.RestoreOptions) error {
+	if options.Jobs < 0 {
+		return fmt.Errorf("jobs must be a positive number")
+	}
+	if options.Jobs > 1 && options.SingleTransaction {
+		return fmt.Errorf("single_transaction cannot be combined with parallel jobs")
+	}
+	if options.Format == models.DumpFormatPlain {
+		if options.Clean {
+			return fmt.Errorf("clean requires a custom format dump")
+		}
+		if options.Jobs > 1 {
+			return fmt.Errorf("parallel jobs require a custom format dump")
+		}
+	}
+	return nil
+}
+
+// PrepareRestoreInput transparently decompresses gzip or zstd uploads and
+// detects the dump format when none was given. It returns the reader to
+// restore from and the resolved format.
+func PrepareRestoreInput(input io.Reader, format string) (io.Reader, string, error) {
+	buffered := bufio.NewReader(input)
+	header, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read dump header: %w", err)
+	}
+
+	var reader io.Reader = buffered
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read gzip dump: %w", err)
+		}
+		reader = gz
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read zstd dump: %w", err)
+		}
+		reader = zr.IOReadCloser()
+	}
+
+	if format != "" {
+		return reader, format, nil
+	}
+
+	buffered = bufio.NewReader(reader)
+	header, err = buffered.Peek(len(customFormatMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read dump header: %w", err)
+	}
+	if bytes.Equal(header, customFormatMagic) {
+		return buffered, models.DumpFormatCustom, nil
+	}
+	return buffered, models.DumpFormatPlain, nil
+}
+
+// RestoreDumpViaSSH restores a dump into a database of a PostgreSQL container
+func (s *PostgresService) RestoreDumpViaSSH(ctx context.Context, server *config.Server, containerID, dbName string, input io.Reader, options models.RestoreOptions, sshService *SSHService) (string, error) {
+	s.logger.Infof("Restoring %s dump into database %s in container %s on server %s", options.Format, dbName, containerID, server.Host)
+
+	restoreCmd := s.buildRestoreCommand(server, containerID, dbName, options)
+	return s.runRestore(ctx, server, restoreCmd, input, sshService)
+}
+
+// RestoreHostDumpViaSSH restores a dump into a database of host PostgreSQL
+func (s *PostgresService) RestoreHostDumpViaSSH(ctx context.Context, server *config.Server, dbName string, input io.Reader, options models.RestoreOptions, sshService *SSHService) (string, error) {
+	s.logger.Infof("Restoring %s dump into host database %s on server %s", options.Format, dbName, server.Host)
+
+	restoreCmd := s.buildHostRestoreCommand(server, dbName, options)
+	return s.runRestore(ctx, server, restoreCmd, input, sshService)
+}
+
+// buildRestoreCommand builds the psql/pg_restore command run inside the container
+func (s *PostgresService) buildRestoreCommand(server *config.Server, containerID, dbName string, options models.RestoreOptions) string {
+	postgresUser := "postgres"
+	if server.PostgresUser != "" {
+		postgresUser = server.PostgresUser
+	}
+
+	// Same docker exec pattern as dumps, with -i so the dump can be piped in
+	restoreCmd := s.restoreClientCommand(postgresUser, dbName, options)
+
+	var cmd string
+	if options.Jobs > 1 {
+		cmd = dockerExec(containerID, true, shellScript(parallelRestoreScript(restoreCmd))).String()
+	} else {
+		cmd = dockerExec(containerID, true, restoreCmd).String()
+	}
+
+	if options.CreateDatabase {
+		createCmd := dockerExec(containerID, false, NewCommand("createdb", "-U", postgresUser, "--", dbName))
+		cmd = createCmd.String() + " && " + cmd
+	}
+
+	s.logger.Debugf("Built restore command: %s", cmd)
+	return cmd
+}
+
+// buildHostRestoreCommand builds the psql/pg_restore command for host PostgreSQL
+func (s *PostgresService) buildHostRestoreCommand(server *config.Server, dbName string, options models.RestoreOptions) string {
+	postgresUser := "postgres"
+	if server.PostgresUser != "" {
+		postgresUser = server.PostgresUser
+	}
+
+	// Connect as the OS user through peer authentication, as for host dumps
+	restoreCmd := s.restoreClientCommand("", dbName, options)
+
+	var cmd string
+	if options.Jobs > 1 {
+		cmd = sudoAs(postgresUser, shellScript(parallelRestoreScript(restoreCmd))).String()
+	} else {
+		cmd = sudoAs(postgresUser, restoreCmd).String()
+	}
+
+	if options.CreateDatabase {
+		cmd = sudoAs(postgresUser, NewCommand("createdb", "--", dbName)).String() + " && " + cmd
+	}
+
+	s.logger.Debugf("Built host restore command: %s", cmd)
+	return cmd
+}
+
+// restoreClientCommand builds the psql or pg_restore invocation reading the
+// dump from stdin. An empty postgresUser omits -U.
+func (s *PostgresService) restoreClientCommand(postgresUser, dbName string, options models.RestoreOptions) Command {
+	var cmd Command
+	if options.Format == models.DumpFormatCustom {
+		cmd = NewCommand("pg_restore", "--exit-on-error")
+		if options.Clean {
+			cmd = cmd.With("--clean", "--if-exists")
+		}
+		if options.Jobs > 1 {
+			cmd = cmd.With(fmt.Sprintf("--jobs=%d", options.Jobs))
+		}
+	} else {
+		// Quiet mode keeps the command tags of every statement out of the output
+		cmd = NewCommand("psql", "-q", "-v", "ON_ERROR_STOP=1")
+	}
+
+	if options.SingleTransaction {
+		cmd = cmd.With("--single-transaction")
+	}
+	if postgresUser != "" {
+		cmd = cmd.With("-U", postgresUser)
+	}
+	return cmd.With("-d", databaseArg(dbName))
+}
+
+// parallelRestoreScript spools stdin to a scratch file first, as pg_restore
+// cannot run parallel jobs against standard input. The exit status of
+// pg_restore is preserved and the file is always removed.
+func parallelRestoreScript(restoreCmd Command) string {
+	return fmt.Sprintf(`f=$(mktemp) || exit 1; cat > "$f" && %s "$f"; rc=$?; rm -f "$f"; exit $rc`, restoreCmd)
+}
+
+// runRestore runs the restore command locally or over SSH, feeding it input
+func (s *PostgresService) runRestore(ctx context.Context, server *config.Server, restoreCmd string, input io.Reader, sshService *SSHService) (string, error) {
+	output := &tailBuffer{limit: maxRestoreOutput}
+
+	var err error
+	if server.IsLocal() {
+		cmd := exec.CommandContext(ctx, "sh", "-c", restoreCmd)
+		cmd.Stdin = input
+		cmd.Stdout = output
+		cmd.Stderr = output
+		err = cmd.Run()
+	} else {
+		err = sshService.RunRemoteCommand(ctx, server, restoreCmd, input, output, output)
+	}
+
+	if err != nil {
+		s.logger.Errorf("Restore command failed: %v\nOutput: %s", err, output.String())
+		return output.String(), fmt.Errorf("restore command failed: %w", err)
+	}
+
+	return output.String(), nil
+}
+
+// tailBuffer keeps the last limit bytes written to it. It is safe for
+// concurrent writers, such as the stdout and stderr of an SSH session.
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return string(b.buf)
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"backend/internal/config"
+)
+
+const (
+	// defaultS3PartSize is the default size of multipart upload parts in MiB
+	defaultS3PartSize = 16
+	// minS3PartSize is the smallest part S3 accepts, except for the last one
+	minS3PartSize = 5
+)
+
+// S3Storage keeps backups in a bucket of an S3-compatible object store.
+// Uploads larger than one part are streamed as multipart uploads.
+type S3Storage struct {
+	name     string
+	bucket   string
+	prefix   string
+	partSize uint64
+	client   *minio.Client
+}
+
+// NewS3Storage creates a storage in an S3 bucket
+func NewS3Storage(cfg config.Storage) (*S3Storage, error) {
+	return newS3Storage(cfg, nil)
+}
+
+// newS3Storage creates a storage in an S3 bucket, sending requests with
+// transport or the default transport if nil
+func newS3Storage(cfg config.Storage, transport http.RoundTripper) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access_key_id and secret_access_key are required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") ||
+		strings.Trim(endpointURL.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid endpoint %q, must be an http or https URL without a path", endpoint)
+	}
+
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = defaultS3PartSize
+	}
+	if partSize < minS3PartSize {
+		return nil, fmt.Errorf("part_size must be at least %d MiB", minS3PartSize)
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	lookup := minio.BucketLookupDNS
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:       endpointURL.Scheme == "https",
+		Region:       region,
+		BucketLookup: lookup,
+		Transport:    transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+
+	return &S3Storage{
+		name:     cfg.Name,
+		bucket:   cfg.Bucket,
+		prefix:   prefix,
+		partSize: uint64(partSize) << 20,
+		client:   client,
+	}, nil
+}
+
+// Name returns the name of the storage
+func (s *S3Storage) Name() string {
+	return s.name
+}
+
+// Put uploads r to key. Uploads that fit in one part are sent with a single
+// PUT, larger ones as a multipart upload, which is aborted if it fails.
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := checkStorageKey(key); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, buf)
+	size := int64(-1)
+	switch err {
+	case nil:
+		r = io.MultiReader(bytes.NewReader(buf), r)
+	case io.EOF, io.ErrUnexpectedEOF:
+		r, size = bytes.NewReader(buf[:n]), int64(n)
+	default:
+		return 0, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	info, err := s.client.PutObject(ctx, s.bucket, s.prefix+key, r, size, minio.PutObjectOptions{PartSize: s.partSize})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return info.Size, nil
+}
+
+// Open downloads the object of key
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkStorageKey(key); err != nil {
+		return nil, err
+	}
+	// Unlike the client, the core requests the object right away, so
+	// missing objects are reported here
+	core := minio.Core{Client: s.client}
+	body, _, _, err := core.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, s.notFound(err))
+	}
+	return body, nil
+}
+
+// Delete removes the object of key. Like S3 itself, it does not report
+// keys that do not exist.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	if err := checkStorageKey(key); err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// List lists the objects under prefix
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	var objects []StorageObject
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list bucket %s: %w", s.bucket, object.Err)
+		}
+		key := strings.TrimPrefix(object.Key, s.prefix)
+		// Skip the markers some tools create for directories
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		objects = append(objects, StorageObject{Key: key, Size: object.Size, ModTime: object.LastModified})
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+// notFound converts the S3 error for missing objects to ErrObjectNotFound
+func (s *S3Storage) notFound(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%w: %v", ErrObjectNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+)
+
+// testS3Storage returns a storage under the prefix db of the bucket backups
+// of an in-memory S3 server. The server uses HTTPS, as the S3 client streams
+// signed chunks over HTTP, which the server only reads for single PUTs.
+func testS3Storage(t *testing.T) (*S3Storage, gofakes3.Backend) {
+	t.Helper()
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("backups"))
+	server := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	s, err := newS3Storage(config.Storage{
+		Name:            "offsite",
+		Type:            StorageTypeS3,
+		Endpoint:        server.URL,
+		Bucket:          "backups",
+		Prefix:          "/db/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		PartSize:        minS3PartSize,
+	}, server.Client().Transport)
+	require.NoError(t, err)
+	return s, backend
+}
+
+func TestNewS3Storage(t *testing.T) {
+	valid := config.Storage{Name: "offsite", Type: StorageTypeS3, Bucket: "backups", AccessKeyID: "key", SecretAccessKey: "secret"}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(cfg *config.Storage)
+		err    string
+	}{
+		{"valid", func(cfg *config.Storage) {}, ""},
+		{"custom endpoint", func(cfg *config.Storage) { cfg.Endpoint = "http://minio.internal:9000/" }, ""},
+		{"no bucket", func(cfg *config.Storage) { cfg.Bucket = "" }, "bucket is required"},
+		{"no secret", func(cfg *config.Storage) { cfg.SecretAccessKey = "" }, "access_key_id and secret_access_key are required"},
+		{"endpoint without scheme", func(cfg *config.Storage) { cfg.Endpoint = "minio.internal:9000" }, "invalid endpoint"},
+		{"endpoint with path", func(cfg *config.Storage) { cfg.Endpoint = "https://example.com/s3" }, "invalid endpoint"},
+		{"small parts", func(cfg *config.Storage) { cfg.PartSize = 4 }, "part_size must be at least 5 MiB"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := valid
+			tc.modify(&cfg)
+			_, err := NewS3Storage(cfg)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestS3StoragePut(t *testing.T) {
+	s, backend := testS3Storage(t)
+	ctx := context.Background()
+	key := "nightly/remote-1/hr/20260101T000000Z_hr.dump"
+
+	// Empty, a single PUT and a multipart upload
+	for _, size := range []int{0, 1 << 10, minS3PartSize<<20 + 1<<10} {
+		data := bytes.Repeat([]byte{'d'}, size)
+		written, err := s.Put(ctx, key, bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), written)
+		assert.Equal(t, string(data), readObject(t, s, key))
+	}
+
+	// Keys are stored under the prefix
+	object, err := backend.HeadObject("backups", "db/"+key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(minS3PartSize<<20+1<<10), object.Size)
+
+	_, err = s.Put(ctx, "../outside.dump", strings.NewReader("dump"))
+	assert.ErrorContains(t, err, "invalid storage key")
+}
+
+func TestS3StoragePutFailed(t *testing.T) {
+	s, _ := testS3Storage(t)
+	ctx := context.Background()
+	key := "nightly/remote-1/hr/20260101T000000Z_hr.dump"
+
+	// Failing in the first part and in a multipart upload
+	_, err := s.Put(ctx, key, io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	assert.ErrorContains(t, err, "failed to read upload")
+	_, err = s.Put(ctx, key, io.MultiReader(bytes.NewReader(make([]byte, minS3PartSize<<20)), failingReader{}))
+	assert.ErrorContains(t, err, "failed to upload "+key)
+
+	_, err = s.Open(ctx, key)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+}
+
+func TestS3StorageList(t *testing.T) {
+	s, backend := testS3Storage(t)
+	ctx := context.Background()
+
+	for _, key := range []string{
+		"nightly/remote-1/hr/b.dump",
+		"nightly/remote-1/hr/a.dump",
+		"nightly/remote-1/crm/a.dump",
+		"weekly/remote-1/hr/a.dump",
+	} {
+		_, err := s.Put(ctx, key, strings.NewReader(key))
+		require.NoError(t, err)
+	}
+	// A directory marker and an object outside of the prefix
+	for _, key := range []string{"db/nightly/", "other/nightly/remote-1/hr/a.dump"} {
+		_, err := backend.PutObject("backups", key, nil, strings.NewReader(""), 0, nil)
+		require.NoError(t, err)
+	}
+
+	for _, tc := range []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"nightly/remote-1/crm/a.dump", "nightly/remote-1/hr/a.dump", "nightly/remote-1/hr/b.dump", "weekly/remote-1/hr/a.dump"}},
+		{"nightly/remote-1/h", []string{"nightly/remote-1/hr/a.dump", "nightly/remote-1/hr/b.dump"}},
+		{"monthly/", nil},
+	} {
+		t.Run(tc.prefix, func(t *testing.T) {
+			objects, err := s.List(ctx, tc.prefix)
+			require.NoError(t, err)
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+				assert.Equal(t, int64(len(object.Key)), object.Size)
+			}
+			assert.Equal(t, tc.keys, keys)
+		})
+	}
+}
+
+func TestS3StorageDelete(t *testing.T) {
+	s, _ := testS3Storage(t)
+	ctx := context.Background()
+	key := "nightly/remote-1/hr/a.dump"
+
+	_, err := s.Put(ctx, key, strings.NewReader("dump"))
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(ctx, key))
+
+	_, err = s.Open(ctx, key)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	assert.NoError(t, s.Delete(ctx, key))
+}
+
+func TestS3StorageMissingBucket(t *testing.T) {
+	s, backend := testS3Storage(t)
+	require.NoError(t, backend.DeleteBucket("backups"))
+
+	// A missing bucket is a configuration error rather than a missing object
+	_, err := s.Open(context.Background(), "nightly/remote-1/hr/a.dump")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrObjectNotFound)
+}
@@ -0,0 +1,550 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// ErrScheduleRunning is returned when a schedule is started while its
+// previous run has not finished
+var ErrScheduleRunning = errors.New("schedule is already running")
+
+// backupTimeFormat prefixes the file names of backups in storage with
+// the time they were started, in UTC
+const backupTimeFormat = "20060102T150405Z"
+
+// BackupKey returns the key of a backup started at t in a directory of a
+// storage
+func BackupKey(dir string, t time.Time, filename string) string {
+	return path.Join(dir, t.UTC().Format(backupTimeFormat)+"_"+filename)
+}
+
+// pruneTimeout bounds listing and deleting the backups of a database
+const pruneTimeout = 5 * time.Minute
+
+// ScheduleOwner is the owner of the jobs and the actor in the audit log of
+// a schedule's backups
+func ScheduleOwner(name string) string {
+	return "schedule:" + name
+}
+
+// backupTarget is a database a schedule backs up
+type backupTarget struct {
+	server        *config.Server
+	containerID   string
+	containerName string
+	database      string
+}
+
+// Scheduler runs the backup schedules of the configuration. Every run
+// discovers the databases matched by the schedule, dumps them through the
+// job queue into the backup storage of the schedule and prunes the backups of each
+// database by the retention policy of the schedule.
+type Scheduler struct {
+	configWatcher     *config.Watcher
+	storageService    *StorageService
+	catalog           *BackupCatalog
+	dockerService     *DockerService
+	postgresService   *PostgresService
+	sshService        *SSHService
+	jobService        *JobService
+	encryptionService *EncryptionService
+	auditLog          *AuditLog
+	logger            *logrus.Logger
+
+	// cancel stops the ticking started by Start, which closes done once it
+	// has stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	runs map[string]*models.ScheduleRun
+	// fired is when each schedule was last started by its cron expression,
+	// in UTC
+	fired map[string]time.Time
+}
+
+// NewScheduler creates a scheduler writing backups to the storages of
+// storageService
+func NewScheduler(
+	configWatcher *config.Watcher,
+	storageService *StorageService,
+	catalog *BackupCatalog,
+	dockerService *DockerService,
+	postgresService *PostgresService,
+	sshService *SSHService,
+	jobService *JobService,
+	encryptionService *EncryptionService,
+	auditLog *AuditLog,
+	logger *logrus.Logger,
+) (*Scheduler, error) {
+	s := &Scheduler{
+		configWatcher:     configWatcher,
+		storageService:    storageService,
+		catalog:           catalog,
+		dockerService:     dockerService,
+		postgresService:   postgresService,
+		sshService:        sshService,
+		jobService:        jobService,
+		encryptionService: encryptionService,
+		auditLog:          auditLog,
+		logger:            logger,
+		runs:              make(map[string]*models.ScheduleRun),
+		fired:             make(map[string]time.Time),
+	}
+	if err := s.Reload(configWatcher.Current()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload checks the schedules of a configuration. Schedules are read from
+// the current configuration on every tick, so there is nothing to apply.
+func (s *Scheduler) Reload(cfg *config.Config) error {
+	for _, schedule := range cfg.GetSchedules() {
+		if err := s.CheckSchedule(schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSchedule validates a schedule, including its cron expression, dump
+// options and storage
+func (s *Scheduler) CheckSchedule(schedule config.Schedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	if _, err := ParseCron(schedule.Cron); err != nil {
+		return fmt.Errorf("schedule %q: %w", schedule.Name, err)
+	}
+	if _, err := s.storageService.Get(schedule.Storage); err != nil {
+		return fmt.Errorf("schedule %q: %w", schedule.Name, err)
+	}
+	options, err := NormalizeDumpOptions(ScheduleDumpOptions(schedule.Options))
+	if err == nil {
+		err = s.encryptionService.CheckKey(options)
+	}
+	if err != nil {
+		return fmt.Errorf("schedule %q: %w", schedule.Name, err)
+	}
+	return nil
+}
+
+// Start runs schedules when their cron expression matches, checked at the
+// start of every minute until Stop is called. Runs missed while the backend
+// was down are not made up for.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		for {
+			next := time.Now().Truncate(time.Minute).Add(time.Minute)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				s.tick(next)
+			}
+		}
+	}()
+
+	s.logger.Info("Started backup scheduler")
+}
+
+// Stop stops starting schedules and waits for a tick in progress. Runs that
+// already started finish on their own.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.logger.Info("Stopped backup scheduler")
+}
+
+// tick starts the schedules due in the minute of t
+func (s *Scheduler) tick(t time.Time) {
+	for _, schedule := range s.configWatcher.Current().GetSchedules() {
+		if schedule.Disabled {
+			continue
+		}
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			continue
+		}
+		location, err := schedule.Location()
+		if err != nil || !cron.Matches(t.In(location)) || !s.claim(schedule.Name, t, location) {
+			continue
+		}
+
+		if err := s.Run(schedule); err != nil {
+			s.logger.Warnf("Skipping run of schedule %s: %v", schedule.Name, err)
+		}
+	}
+}
+
+// claim records that a schedule fires at t by its cron expression. It
+// reports false if the schedule already fired at the same or a later time
+// on the clock of its time zone, as happens in the hour repeated when clocks
+// go back.
+func (s *Scheduler) claim(name string, t time.Time, location *time.Location) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, exists := s.fired[name]; exists && !wallClock(t.In(location)).After(wallClock(last.In(location))) {
+		return false
+	}
+	s.fired[name] = t.UTC()
+	return true
+}
+
+// wallClock returns the date and time a clock in the time zone of t shows,
+// as the same date and time in UTC so that clock readings compare in order
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// Run starts a run of a schedule in the background
+func (s *Scheduler) Run(schedule config.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, exists := s.runs[schedule.Name]; exists && last.FinishedAt == nil {
+		return ErrScheduleRunning
+	}
+	run := &models.ScheduleRun{Status: models.JobStatusRunning, StartedAt: time.Now()}
+	s.runs[schedule.Name] = run
+
+	go s.execute(schedule, run)
+	return nil
+}
+
+// LastRun returns the current or last run of a schedule since the backend
+// started, or nil
+func (s *Scheduler) LastRun(name string) *models.ScheduleRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, exists := s.runs[name]
+	if !exists {
+		return nil
+	}
+	snapshot := *run
+	snapshot.Errors = slices.Clone(run.Errors)
+	return &snapshot
+}
+
+// NextRun returns when a schedule runs next, or nil if it never does
+func (s *Scheduler) NextRun(schedule config.Schedule) *time.Time {
+	if schedule.Disabled {
+		return nil
+	}
+	cron, err := ParseCron(schedule.Cron)
+	if err != nil {
+		return nil
+	}
+	location, err := schedule.Location()
+	if err != nil {
+		return nil
+	}
+	next := cron.Next(time.Now().In(location))
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// execute backs up every database matched by a schedule and waits for the
+// backups to finish
+func (s *Scheduler) execute(schedule config.Schedule, run *models.ScheduleRun) {
+	s.logger.Infof("Running backup schedule %s", schedule.Name)
+
+	// CheckSchedule accepted the options, but the keys may have changed
+	options, err := NormalizeDumpOptions(ScheduleDumpOptions(schedule.Options))
+	if err == nil {
+		err = s.encryptionService.CheckKey(options)
+	}
+	var storage Storage
+	if err == nil {
+		storage, err = s.storageService.Get(schedule.Storage)
+	}
+	if err != nil {
+		s.recordFailure(run, err)
+		s.finish(schedule, run)
+		return
+	}
+
+	targets := s.targets(schedule, run)
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		dir := path.Join(schedule.Name, target.server.ID, target.containerName, target.database)
+		key := BackupKey(dir, run.StartedAt, DumpFilename(target.server.ID, target.containerID, target.database, options))
+
+		wg.Add(1)
+		_, err := s.jobService.SubmitBackup(ScheduleOwner(schedule.Name), target.server, target.containerID, target.containerName, target.database, options, storage, key, func(job models.JobResponse) {
+			defer wg.Done()
+			s.backupFinished(schedule, run, target, job)
+			if job.Status == models.JobStatusCompleted {
+				s.prune(storage, dir, schedule)
+			}
+		})
+		if err != nil {
+			wg.Done()
+			s.recordFailure(run, fmt.Errorf("%s: %w", backupTargetName(target), err))
+		}
+	}
+	wg.Wait()
+
+	s.finish(schedule, run)
+}
+
+// targets discovers the databases matched by a schedule. Servers that fail
+// discovery are recorded as failures of the run.
+func (s *Scheduler) targets(schedule config.Schedule, run *models.ScheduleRun) []backupTarget {
+	containers := schedule.Containers
+	if len(containers) == 0 {
+		containers = []string{"*"}
+	}
+
+	var targets []backupTarget
+	for _, server := range s.configWatcher.Current().SelectServers(schedule.Servers) {
+		server := server
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+
+		found, err := s.dockerService.GetPostgreSQLContainers(ctx, &server, s.sshService)
+		if err != nil {
+			s.recordFailure(run, fmt.Errorf("server %s: %w", server.ID, err))
+		}
+		for _, container := range found {
+			if !config.MatchesAny(containers, container.Name) {
+				continue
+			}
+			databases, err := s.postgresService.DatabaseNames(ctx, &server, container.ID, s.sshService)
+			if err != nil {
+				s.recordFailure(run, fmt.Errorf("server %s, container %s: %w", server.ID, container.Name, err))
+				continue
+			}
+			targets = append(targets, s.matchDatabases(schedule, &server, container.ID, container.Name, databases)...)
+		}
+
+		// Patterns only match containers, host PostgreSQL has to be listed
+		// by name. Otherwise the default of every container would fail on
+		// each server without PostgreSQL on the host.
+		if slices.Contains(containers, HostContainerName) {
+			databases, err := s.postgresService.DatabaseNames(ctx, &server, "", s.sshService)
+			if err != nil {
+				s.recordFailure(run, fmt.Errorf("server %s, host PostgreSQL: %w", server.ID, err))
+			} else {
+				targets = append(targets, s.matchDatabases(schedule, &server, "", HostContainerName, databases)...)
+			}
+		}
+
+		cancel()
+	}
+	return targets
+}
+
+// matchDatabases returns the targets of the databases matched by a schedule
+func (s *Scheduler) matchDatabases(schedule config.Schedule, server *config.Server, containerID, containerName string, databases []string) []backupTarget {
+	var targets []backupTarget
+	for _, database := range databases {
+		if !config.MatchesAny(schedule.Databases, database) {
+			continue
+		}
+		// Database names end up in paths of the backup directory
+		if err := s.postgresService.ValidateDatabaseName(database); err != nil {
+			s.logger.Warnf("Schedule %s skips database %q on %s: %v", schedule.Name, database, server.ID, err)
+			continue
+		}
+		targets = append(targets, backupTarget{server: server, containerID: containerID, containerName: containerName, database: database})
+	}
+	return targets
+}
+
+// backupFinished records the outcome of a backup in the run and the audit log
+func (s *Scheduler) backupFinished(schedule config.Schedule, run *models.ScheduleRun, target backupTarget, job models.JobResponse) {
+	entry := models.AuditEntry{
+		Time:      job.CreatedAt,
+		Actor:     ScheduleOwner(schedule.Name),
+		Action:    AuditActionBackup,
+		ServerID:  target.server.ID,
+		Container: target.containerName,
+		Database:  target.database,
+		Bytes:     job.BytesWritten,
+		Duration:  job.Duration,
+		Outcome:   AuditOutcomeSuccess,
+	}
+	if options, err := json.Marshal(job.Options); err == nil {
+		entry.Options = options
+	}
+
+	if job.Status == models.JobStatusCompleted {
+		s.mu.Lock()
+		run.Backups++
+		s.mu.Unlock()
+	} else {
+		entry.Outcome = AuditOutcomeFailure
+		entry.Error = job.Error
+		s.recordFailure(run, fmt.Errorf("%s: %s", backupTargetName(target), job.Error))
+	}
+
+	if err := s.auditLog.Record(entry); err != nil {
+		s.logger.Errorf("Failed to write audit log entry for backup of %s: %v", backupTargetName(target), err)
+	}
+}
+
+// recordFailure records a failed backup or discovery in the run
+func (s *Scheduler) recordFailure(run *models.ScheduleRun, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.Failed++
+	run.Errors = append(run.Errors, err.Error())
+}
+
+// finish marks a run as finished
+func (s *Scheduler) finish(schedule config.Schedule, run *models.ScheduleRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Status = models.JobStatusCompleted
+	if run.Failed > 0 {
+		run.Status = models.JobStatusFailed
+		s.logger.Errorf("Backup schedule %s finished with %d backups and %d failures", schedule.Name, run.Backups, run.Failed)
+		return
+	}
+	s.logger.Infof("Backup schedule %s finished with %d backups", schedule.Name, run.Backups)
+}
+
+// prune removes the backups in a directory of a storage that the retention
+// policy of the schedule does not keep
+func (s *Scheduler) prune(storage Storage, dir string, schedule config.Schedule) {
+	if schedule.Retention.IsZero() {
+		return
+	}
+	location, err := schedule.Location()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pruneTimeout)
+	defer cancel()
+
+	objects, err := storage.List(ctx, dir+"/")
+	if err != nil {
+		s.logger.Warnf("Failed to list backups in %s of %s: %v", dir, storage.Name(), err)
+		return
+	}
+
+	var keys []string
+	var times []time.Time
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, dir+"/")
+		if strings.Contains(name, "/") {
+			continue
+		}
+		prefix, _, _ := strings.Cut(name, "_")
+		t, err := time.Parse(backupTimeFormat, prefix)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, object.Key)
+		times = append(times, t.In(location))
+	}
+
+	keep := retainedBackups(times, schedule.Retention)
+	for i, key := range keys {
+		if keep[i] {
+			continue
+		}
+		if err := storage.Delete(ctx, key); err != nil {
+			s.logger.Warnf("Failed to prune backup %s of %s: %v", key, storage.Name(), err)
+			continue
+		}
+		if err := s.catalog.Remove(storage.Name(), key); err != nil {
+			s.logger.Warnf("Failed to remove pruned backup %s from the catalog: %v", key, err)
+		}
+		s.logger.Infof("Pruned backup %s of schedule %s", key, schedule.Name)
+	}
+}
+
+// retainedBackups returns the indexes of the backups, given by their times,
+// kept by a retention policy: the newest backup of each of the newest days,
+// weeks and months, as many as the policy asks for
+func retainedBackups(times []time.Time, retention config.Retention) map[int]bool {
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return times[order[a]].After(times[order[b]]) })
+
+	keep := make(map[int]bool)
+	for _, period := range []struct {
+		count int
+		key   func(time.Time) string
+	}{
+		{retention.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{retention.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{retention.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	} {
+		seen := make(map[string]bool)
+		for _, i := range order {
+			if len(seen) >= period.count {
+				break
+			}
+			key := period.key(times[i])
+			if !seen[key] {
+				seen[key] = true
+				keep[i] = true
+			}
+		}
+	}
+	return keep
+}
+
+// backupTargetName describes a backup target for log and error messages
+func backupTargetName(target backupTarget) string {
+	return fmt.Sprintf("%s/%s/%s", target.server.ID, target.containerName, target.database)
+}
+
+// ScheduleDumpOptions converts the options of a schedule to dump options
+func ScheduleDumpOptions(options config.ScheduleOptions) models.DumpOptions {
+	return models.DumpOptions{
+		DataOnly:         options.DataOnly,
+		SchemaOnly:       options.SchemaOnly,
+		Format:           options.Format,
+		Tables:           options.Tables,
+		ExcludeTables:    options.ExcludeTables,
+		Schemas:          options.Schemas,
+		ExcludeSchemas:   options.ExcludeSchemas,
+		ExcludeTableData: options.ExcludeTableData,
+		Compression:      options.Compression,
+		CompressionLevel: options.CompressionLevel,
+		Encryption:       options.Encryption,
+		Recipients:       options.Recipients,
+		KeyID:            options.KeyID,
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+)
+
+func TestRetainedBackups(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	// Deliberately not in order, the newest backup of a period is kept
+	times := []time.Time{
+		at("2024-09-10 02:00"), // 0: Tuesday, ISO week 37
+		at("2024-09-10 14:00"), // 1: newest
+		at("2024-09-09 02:00"), // 2: Monday, ISO week 37
+		at("2024-09-08 02:00"), // 3: Sunday, ISO week 36
+		at("2024-09-01 02:00"), // 4: Sunday, ISO week 35
+		at("2024-08-15 02:00"), // 5
+		at("2024-07-31 02:00"), // 6
+	}
+
+	for _, tc := range []struct {
+		name      string
+		retention config.Retention
+		keep      []int
+	}{
+		{"nothing", config.Retention{}, nil},
+		{"daily", config.Retention{Daily: 2}, []int{1, 2}},
+		{"more days than backups", config.Retention{Daily: 30}, []int{1, 2, 3, 4, 5, 6}},
+		{"weekly", config.Retention{Weekly: 2}, []int{1, 3}},
+		{"monthly", config.Retention{Monthly: 3}, []int{1, 5, 6}},
+		{"combined", config.Retention{Daily: 1, Weekly: 2, Monthly: 2}, []int{1, 3, 5}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keep := retainedBackups(times, tc.retention)
+
+			var kept []int
+			for i := range times {
+				if keep[i] {
+					kept = append(kept, i)
+				}
+			}
+			assert.Equal(t, tc.keep, kept)
+		})
+	}
+}
+
+func TestRetainedBackupsISOWeeks(t *testing.T) {
+	// 2024-12-30 belongs to week 1 of 2025, along with 2025-01-02
+	times := []time.Time{
+		time.Date(2024, 12, 29, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 30, 2, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 2, 2, 0, 0, 0, time.UTC),
+	}
+
+	keep := retainedBackups(times, config.Retention{Weekly: 2})
+	assert.Equal(t, map[int]bool{0: true, 2: true}, keep)
+}
+
+func TestSchedulerClaimFallBack(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	s := &Scheduler{fired: make(map[string]time.Time)}
+
+	// Clocks went back from 02:00 EDT to 01:00 EST on 2024-11-03, so
+	// 01:30 came twice, at 05:30 and 06:30 UTC
+	for _, tc := range []struct {
+		utc     string
+		claimed bool
+	}{
+		{"2024-11-03T05:30:00Z", true},
+		{"2024-11-03T05:30:00Z", false},
+		{"2024-11-03T05:59:00Z", true},
+		{"2024-11-03T06:30:00Z", false},
+		{"2024-11-03T06:59:00Z", false},
+		{"2024-11-03T07:00:00Z", true},
+		{"2024-11-04T06:30:00Z", true},
+	} {
+		at, err := time.Parse(time.RFC3339, tc.utc)
+		require.NoError(t, err)
+		assert.Equal(t, tc.claimed, s.claim("nightly", at, newYork), tc.utc)
+	}
+
+	// Schedules are claimed separately, and in UTC the hour is not repeated
+	repeated := time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC)
+	assert.True(t, s.claim("other", repeated, newYork))
+	assert.True(t, s.claim("utc", repeated.Add(-time.Hour), time.UTC))
+	assert.True(t, s.claim("utc", repeated, time.UTC))
+}
+
+func TestSchedulerClaimSpringForward(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	s := &Scheduler{fired: make(map[string]time.Time)}
+
+	// Clocks went forward from 02:00 EST to 03:00 EDT on 2024-03-10
+	assert.True(t, s.claim("nightly", time.Date(2024, 3, 10, 6, 59, 0, 0, time.UTC), newYork))
+	assert.True(t, s.claim("nightly", time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), newYork))
+}
+
+func TestSchedulerStop(t *testing.T) {
+	s := &Scheduler{logger: logrus.New()}
+	s.logger.SetOutput(io.Discard)
+
+	// Stopping a scheduler that never started does nothing
+	s.Stop()
+
+	s.Start()
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
+
+func TestScheduleContainerPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		containers []string
+		err        string
+	}{
+		{nil, ""},
+		{[]string{"*", "@host"}, ""},
+		{[]string{"pg-[0-9]*"}, ""},
+		{[]string{"pg-["}, `invalid container pattern "pg-["`},
+		{[]string{"@Host"}, `container pattern "@Host" matches nothing, list "@host"`},
+		{[]string{"@*"}, `container pattern "@*" matches nothing`},
+	} {
+		schedule := config.Schedule{Name: "nightly", Cron: "@daily", Containers: tc.containers}
+		err := schedule.Validate()
+		if tc.err == "" {
+			assert.NoError(t, err, "%v", tc.containers)
+		} else {
+			assert.ErrorContains(t, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"backend/internal/models"
+)
+
+// maxIdentifierLength is PostgreSQL's NAMEDATALEN - 1
+const maxIdentifierLength = 63
+
+// ValidateDumpSelectors checks every table and schema selector of a dump
+// request. Table selectors may be qualified with a schema ("schema.table")
+// and any part may be the "*" wildcard.
+func ValidateDumpSelectors(options models.DumpOptions) error {
+	// A slice rather than a map, so the first invalid selector reported is
+	// always the same one
+	selectors := []struct {
+		kind     string
+		values   []string
+		maxParts int
+	}{
+		{"schema", options.Schemas, 1},
+		{"exclude_schema", options.ExcludeSchemas, 1},
+		{"table", options.Tables, 2},
+		{"exclude_table", options.ExcludeTables, 2},
+		{"exclude_table_data", options.ExcludeTableData, 2},
+	}
+
+	for _, selector := range selectors {
+		for _, value := range selector.values {
+			if err := validateSelector(value, selector.maxParts); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", selector.kind, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSelector validates a dot separated selector of at most maxParts
+// identifiers
+func validateSelector(selector string, maxParts int) error {
+	parts := strings.Split(selector, ".")
+	if len(parts) > maxParts {
+		return fmt.Errorf("too many name parts")
+	}
+
+	for _, part := range parts {
+		if part == "*" {
+			continue
+		}
+		if err := validateIdentifier(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateIdentifier accepts identifiers made of letters, digits, underscores,
+// dollar signs and hyphens, starting with a letter or underscore
+func validateIdentifier(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty identifier")
+	}
+	if len(name) > maxIdentifierLength {
+		return fmt.Errorf("identifier exceeds %d characters", maxIdentifierLength)
+	}
+
+	for i, char := range name {
+		switch {
+		case (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || char == '_':
+		case i > 0 && ((char >= '0' && char <= '9') || char == '$' || char == '-'):
+		default:
+			return fmt.Errorf("invalid character %q in identifier", char)
+		}
+	}
+	return nil
+}
+
+// selectorPattern renders a validated selector as a pg_dump pattern. Every
+// identifier is double quoted so it is matched exactly, case included, while
+// "*" wildcards are passed through unquoted.
+func selectorPattern(selector string) string {
+	parts := strings.Split(selector, ".")
+	for i, part := range parts {
+		if part != "*" {
+			parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// selectorFlags renders the include/exclude selectors as pg_dump arguments
+func selectorFlags(options models.DumpOptions) []string {
+	var flags []string
+
+	selectors := []struct {
+		flag   string
+		values []string
+	}{
+		{"--schema", options.Schemas},
+		{"--exclude-schema", options.ExcludeSchemas},
+		{"--table", options.Tables},
+		{"--exclude-table", options.ExcludeTables},
+		{"--exclude-table-data", options.ExcludeTableData},
+	}
+
+	for _, selector := range selectors {
+		for _, value := range selector.values {
+			flags = append(flags, selector.flag+"="+selectorPattern(value))
+		}
+	}
+
+	return flags
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"backend/internal/models"
+)
+
+func TestValidateDumpSelectors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options models.DumpOptions
+		err     string
+	}{
+		{"none", models.DumpOptions{}, ""},
+		{"valid", models.DumpOptions{
+			Tables:           []string{"public.users", "*.audit_log", "orders"},
+			ExcludeTables:    []string{"public.*"},
+			ExcludeTableData: []string{"logs.events_2024"},
+			Schemas:          []string{"public", "tenant$1"},
+			ExcludeSchemas:   []string{"*"},
+		}, ""},
+		{"too many parts", models.DumpOptions{Tables: []string{"db.public.users"}}, `invalid table "db.public.users": too many name parts`},
+		{"qualified schema", models.DumpOptions{Schemas: []string{"public.users"}}, `invalid schema "public.users": too many name parts`},
+		{"empty part", models.DumpOptions{ExcludeTables: []string{"public."}}, `invalid exclude_table "public.": empty identifier`},
+		{"quote", models.DumpOptions{Tables: []string{`users"; drop`}}, `invalid table "users\"; drop": invalid character '"' in identifier`},
+		{"leading digit", models.DumpOptions{ExcludeSchemas: []string{"1st"}}, `invalid exclude_schema "1st": invalid character '1' in identifier`},
+		{"partial wildcard", models.DumpOptions{ExcludeTableData: []string{"events_*"}}, `invalid exclude_table_data "events_*": invalid character '*' in identifier`},
+		{"too long", models.DumpOptions{Tables: []string{"a123456789012345678901234567890123456789012345678901234567890123"}}, "identifier exceeds 63 characters"},
+		// Schemas are checked first, whatever else is invalid
+		{"several invalid", models.DumpOptions{
+			Tables:           []string{"a.b.c"},
+			ExcludeTableData: []string{"-x"},
+			ExcludeSchemas:   []string{"x;y"},
+		}, `invalid exclude_schema "x;y"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateDumpSelectors(tc.options)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestSelectorFlags(t *testing.T) {
+	flags := selectorFlags(models.DumpOptions{
+		Tables:         []string{"public.Users", "*.audit"},
+		ExcludeSchemas: []string{"tmp"},
+	})
+	assert.Equal(t, []string{`--exclude-schema="tmp"`, `--table="public"."Users"`, `--table=*."audit"`}, flags)
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"backend/internal/config"
+)
+
+const (
+	// sftpMaxInFlight caps the writes sent ahead of their replies
+	sftpMaxInFlight = 64
+	// sftpPosixRename is the OpenSSH extension renaming over existing files
+	sftpPosixRename = "posix-rename@openssh.com"
+)
+
+// SFTPStorage keeps backups in a directory of a server, reached over SFTP
+// with the SSH settings of the server
+type SFTPStorage struct {
+	name          string
+	dir           string
+	serverID      string
+	configWatcher *config.Watcher
+	sshService    *SSHService
+	// startSFTP starts the SFTP subsystem the client talks to
+	startSFTP func(ctx context.Context) (io.ReadWriteCloser, error)
+}
+
+// NewSFTPStorage creates a storage in a directory of a configured server
+func NewSFTPStorage(cfg config.Storage, configWatcher *config.Watcher, sshService *SSHService) (*SFTPStorage, error) {
+	if cfg.Server == "" || cfg.Path == "" {
+		return nil, fmt.Errorf("server and path are required")
+	}
+	s := &SFTPStorage{
+		name:          cfg.Name,
+		dir:           cfg.Path,
+		serverID:      cfg.Server,
+		configWatcher: configWatcher,
+		sshService:    sshService,
+	}
+	s.startSFTP = s.startSubsystem
+	return s, nil
+}
+
+// Name returns the name of the storage
+func (s *SFTPStorage) Name() string {
+	return s.name
+}
+
+// Put writes r to a partial file next to the path of key and renames it
+// into place once complete
+func (s *SFTPStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := checkStorageKey(key); err != nil {
+		return 0, err
+	}
+	client, err := s.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	target := path.Join(s.dir, key)
+	if err := sftpMkdirAll(client, path.Dir(target)); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	partialPath := target + partialSuffix
+	file, err := client.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", partialPath, err)
+	}
+
+	var written int64
+	err = file.Chmod(0o600)
+	if err == nil {
+		written, err = file.ReadFromWithConcurrency(r, sftpMaxInFlight)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = sftpRename(client, partialPath, target)
+	}
+	if err != nil {
+		client.Remove(partialPath)
+		return written, fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return written, nil
+}
+
+// Open opens the file of key. The SFTP session stays open until the returned
+// reader is closed.
+func (s *SFTPStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkStorageKey(key); err != nil {
+		return nil, err
+	}
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := client.Open(path.Join(s.dir, key))
+	if err != nil {
+		client.Close()
+		return nil, s.notFound(key, err)
+	}
+	return &sftpFileReader{File: file, client: client}, nil
+}
+
+// Delete removes the file of key
+func (s *SFTPStorage) Delete(ctx context.Context, key string) error {
+	if err := checkStorageKey(key); err != nil {
+		return err
+	}
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return s.notFound(key, client.Remove(path.Join(s.dir, key)))
+}
+
+// List walks the directory of prefix for files, leaving out partial files
+// that are still being written
+func (s *SFTPStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var objects []StorageObject
+	dir := path.Clean(s.dir)
+	walker := client.Walk(path.Join(dir, listPrefixDir(prefix)))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// The directory of prefix does not exist yet, or an entry
+				// was removed since its directory was read
+				continue
+			}
+			return nil, fmt.Errorf("failed to list %s on %s: %w", s.dir, s.serverID, err)
+		}
+		info := walker.Stat()
+		if info.IsDir() || strings.HasSuffix(info.Name(), partialSuffix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), dir), "/")
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, StorageObject{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+// connect starts an SFTP session on the server of the storage
+func (s *SFTPStorage) connect(ctx context.Context) (*sftp.Client, error) {
+	conn, err := s.startSFTP(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.serverID, err)
+	}
+	// Closing the client closes conn
+	client, err := sftp.NewClientPipe(conn, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP on %s: %w", s.serverID, err)
+	}
+	return client, nil
+}
+
+// startSubsystem starts the SFTP subsystem in an SSH session to the server
+// of the storage
+func (s *SFTPStorage) startSubsystem(ctx context.Context) (io.ReadWriteCloser, error) {
+	server, err := s.configWatcher.Current().GetServerByID(s.serverID)
+	if err != nil {
+		return nil, err
+	}
+	if server.IsLocal() {
+		return nil, fmt.Errorf("server %s of SFTP storage %s is not a remote server", s.serverID, s.name)
+	}
+	return s.sshService.StartSubsystem(ctx, server, "sftp")
+}
+
+// notFound converts the SFTP error for missing files to ErrObjectNotFound
+func (s *SFTPStorage) notFound(key string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return err
+}
+
+// sftpMkdirAll creates dir and its missing parents. Unlike the MkdirAll of
+// the client it keeps new directories private to the SSH user.
+func sftpMkdirAll(client *sftp.Client, dir string) error {
+	if info, err := client.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := sftpMkdirAll(client, parent); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mkdir(dir); err != nil {
+		// Someone else may have created it in the meantime
+		if info, statErr := client.Stat(dir); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return client.Chmod(dir, 0o700)
+}
+
+// sftpRename moves a file over another, atomically if the server supports
+// the OpenSSH extension for it
+func sftpRename(client *sftp.Client, from, to string) error {
+	if _, ok := client.HasExtension(sftpPosixRename); ok {
+		return client.PosixRename(from, to)
+	}
+
+	// Plain SFTP renames fail if the target exists
+	if err := client.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+// sftpFileReader reads a file of an SFTP storage and ends its session once
+// closed
+type sftpFileReader struct {
+	*sftp.File
+	client *sftp.Client
+}
+
+// Close closes the file and the session
+func (r *sftpFileReader) Close() error {
+	err := r.File.Close()
+	if closeErr := r.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
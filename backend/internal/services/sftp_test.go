@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"backend/internal/config"
+)
+
+// testSFTPStorage returns a storage in a temporary directory served by an
+// SFTP server of the test
+func testSFTPStorage(t *testing.T) (*SFTPStorage, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewSFTPStorage(config.Storage{Name: "offsite", Type: StorageTypeSFTP, Server: "backup-1", Path: dir}, nil, nil)
+	require.NoError(t, err)
+
+	s.startSFTP = func(ctx context.Context) (io.ReadWriteCloser, error) {
+		conn, serverConn := net.Pipe()
+		server, err := sftp.NewServer(serverConn)
+		if err != nil {
+			return nil, err
+		}
+		go server.Serve()
+		t.Cleanup(func() { server.Close() })
+		return conn, nil
+	}
+	return s, dir
+}
+
+func readObject(t *testing.T, s Storage, key string) string {
+	t.Helper()
+	r, err := s.Open(context.Background(), key)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestSFTPStoragePut(t *testing.T) {
+	s, dir := testSFTPStorage(t)
+	ctx := context.Background()
+	key := "nightly/remote-1/hr/20260101T000000Z_hr.dump"
+
+	// Larger than a packet, so several writes are in flight
+	data := strings.Repeat("dump data ", 100<<10)
+	written, err := s.Put(ctx, key, strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), written)
+	assert.Equal(t, data, readObject(t, s, key))
+
+	// Putting again replaces the file
+	_, err = s.Put(ctx, key, strings.NewReader("newer"))
+	require.NoError(t, err)
+	assert.Equal(t, "newer", readObject(t, s, key))
+
+	// Only the SSH user can read the dumps
+	info, err := os.Stat(filepath.Join(dir, "nightly", "remote-1"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0o700, info.Mode())
+	info, err = os.Stat(filepath.Join(dir, filepath.FromSlash(key)))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode())
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(key)+partialSuffix))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSFTPStoragePutFailed(t *testing.T) {
+	s, dir := testSFTPStorage(t)
+	ctx := context.Background()
+	key := "nightly/remote-1/hr/20260101T000000Z_hr.dump"
+
+	_, err := s.Put(ctx, key, io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	assert.ErrorContains(t, err, "failed to write "+key)
+
+	// Neither the file nor the partial file are left behind
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(key)))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(key)+partialSuffix))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = s.Put(ctx, "../outside.dump", strings.NewReader("dump"))
+	assert.ErrorContains(t, err, "invalid storage key")
+}
+
+func TestSFTPStorageList(t *testing.T) {
+	s, dir := testSFTPStorage(t)
+	ctx := context.Background()
+
+	objects, err := s.List(ctx, "nightly/")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+
+	for _, key := range []string{
+		"nightly/remote-1/hr/b.dump",
+		"nightly/remote-1/hr/a.dump",
+		"nightly/remote-1/crm/a.dump",
+		"nightly/remote-2/hr/a.dump",
+		"weekly/remote-1/hr/a.dump",
+	} {
+		_, err := s.Put(ctx, key, strings.NewReader(key))
+		require.NoError(t, err)
+	}
+	// A dump still being written
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nightly", "remote-1", "hr", "c.dump"+partialSuffix), nil, 0o600))
+
+	for _, tc := range []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"nightly/remote-1/crm/a.dump", "nightly/remote-1/hr/a.dump", "nightly/remote-1/hr/b.dump", "nightly/remote-2/hr/a.dump", "weekly/remote-1/hr/a.dump"}},
+		{"nightly/remote-1/", []string{"nightly/remote-1/crm/a.dump", "nightly/remote-1/hr/a.dump", "nightly/remote-1/hr/b.dump"}},
+		{"nightly/remote-1/hr/a", []string{"nightly/remote-1/hr/a.dump"}},
+		{"nightly/remote-1/h", []string{"nightly/remote-1/hr/a.dump", "nightly/remote-1/hr/b.dump"}},
+		{"monthly/", nil},
+	} {
+		t.Run(tc.prefix, func(t *testing.T) {
+			objects, err := s.List(ctx, tc.prefix)
+			require.NoError(t, err)
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+				assert.Equal(t, int64(len(object.Key)), object.Size)
+			}
+			assert.Equal(t, tc.keys, keys)
+		})
+	}
+}
+
+func TestSFTPStorageDelete(t *testing.T) {
+	s, _ := testSFTPStorage(t)
+	ctx := context.Background()
+	key := "nightly/remote-1/hr/a.dump"
+
+	_, err := s.Put(ctx, key, strings.NewReader("dump"))
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(ctx, key))
+
+	_, err = s.Open(ctx, key)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	assert.ErrorIs(t, s.Delete(ctx, key), ErrObjectNotFound)
+}
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
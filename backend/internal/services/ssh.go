@@ -1,69 +1,236 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
     "backend/internal/config"
+    "backend/internal/models"
 )
 
-// SSHConfig represents SSH connection configuration
-type SSHConfig struct {
-	Host       string
-	Port       int
-	Username   string
-	Password   string
-	PrivateKey string
+const (
+	// maxSessionsPerConn stays below OpenSSH's default MaxSessions of 10
+	maxSessionsPerConn = 8
+	// connIdleTimeout is how long an unused connection is kept open
+	connIdleTimeout = 5 * time.Minute
+	// sshDialTimeout bounds the TCP connect and SSH handshake
+	sshDialTimeout = 30 * time.Second
+)
+
+// pooledConn is a pooled SSH connection multiplexing several sessions
+type pooledConn struct {
+	key      string
+	client   *ssh.Client
+	sessions int
+	lastUsed time.Time
 }
 
-// SSHService handles SSH operations
+// SSHService handles SSH operations. Connections are pooled per server and
+// every command runs in its own session on a shared connection.
 type SSHService struct {
-	logger *logrus.Logger
-}
-
-// NewSSHService creates a new SSH service
-func NewSSHService(logger *logrus.Logger) *SSHService {
-	return &SSHService{
-		logger: logger,
-	}
-}
-
-// ExecuteRemoteCommand executes a command on a remote server using system SSH
-func (s *SSHService) ExecuteRemoteCommand(serverConfig *config.Server, command string) (string, error) {
-    // Build SSH connection string with username
-    var sshTarget string
-    if serverConfig.Username != "" {
-        sshTarget = fmt.Sprintf("%s@%s", serverConfig.Username, serverConfig.Host)
-    } else {
-        sshTarget = serverConfig.Host
-    }
-    
-    s.logger.Infof("Executing command on %s: %s", sshTarget, command)
-    
-    // Build SSH command with private key
-    var cmd *exec.Cmd
-    if serverConfig.PrivateKey != "" {
-        // Use private key for authentication
-        cmd = exec.Command("ssh", "-i", serverConfig.PrivateKey, "-o", "StrictHostKeyChecking=no", sshTarget, command)
-    } else {
-        // Use default SSH authentication
-        cmd = exec.Command("ssh", "-o", "StrictHostKeyChecking=no", sshTarget, command)
-    }
-    
-    output, err := cmd.CombinedOutput()
-    if err != nil {
-        s.logger.Errorf("SSH command failed on %s: %v\nOutput: %s", sshTarget, err, string(output))
-        return "", fmt.Errorf("SSH command failed: %w\nOutput: %s", err, string(output))
-    }
-    
-    s.logger.Debugf("Command output from %s: %s", sshTarget, string(output))
-    return string(output), nil
+	hostKeys *HostKeyStore
+	logger   *logrus.Logger
+
+	mu    sync.Mutex
+	pools map[string][]*pooledConn
+}
+
+// NewSSHService creates a new SSH service verifying host keys with hostKeys
+func NewSSHService(hostKeys *HostKeyStore, logger *logrus.Logger) *SSHService {
+	s := &SSHService{
+		hostKeys: hostKeys,
+		logger:   logger,
+		pools:    make(map[string][]*pooledConn),
+	}
+	go s.closeIdleConnections()
+	return s
+}
+
+// ExecuteRemoteCommand executes a command on a remote server and returns its
+// combined output. Cancelling ctx closes the session.
+func (s *SSHService) ExecuteRemoteCommand(ctx context.Context, serverConfig *config.Server, command string) (string, error) {
+	sshTarget := s.target(serverConfig)
+	s.logger.Debugf("Executing command on %s: %s", sshTarget, command)
+
+	session, release, err := s.newSession(ctx, serverConfig)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	defer session.Close()
+
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	output, err := session.CombinedOutput(command)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		s.logger.Errorf("SSH command failed on %s: %v\nOutput: %s", sshTarget, err, string(output))
+		return "", fmt.Errorf("SSH command failed: %w\nOutput: %s", err, string(output))
+	}
+
+	s.logger.Debugf("Command output from %s: %s", sshTarget, string(output))
+	return string(output), nil
 }
 
+// RunRemoteCommand runs a command on a remote server to completion, wiring up
+// the given streams. Cancelling ctx closes the session.
+func (s *SSHService) RunRemoteCommand(ctx context.Context, serverConfig *config.Server, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	s.logger.Debugf("Running command on %s: %s", s.target(serverConfig), command)
 
+	session, release, err := s.newSession(ctx, serverConfig)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer session.Close()
+
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Run(command); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// StartRemoteCommand starts a command on a remote server and returns it with
+// its stdout available for reading. Stderr is written to stderr. Cancelling
+// ctx closes the session.
+func (s *SSHService) StartRemoteCommand(ctx context.Context, serverConfig *config.Server, command string, stderr io.Writer) (*RemoteCommand, error) {
+	s.logger.Debugf("Starting command on %s: %s", s.target(serverConfig), command)
+
+	session, release, err := s.newSession(ctx, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		release()
+		return nil, fmt.Errorf("failed to create SSH stdout pipe: %w", err)
+	}
+	session.Stderr = stderr
+
+	if err := session.Start(command); err != nil {
+		session.Close()
+		release()
+		return nil, fmt.Errorf("failed to start SSH command: %w", err)
+	}
+
+	return &RemoteCommand{
+		session: session,
+		stdout:  stdout,
+		release: release,
+		stop:    context.AfterFunc(ctx, func() { session.Close() }),
+	}, nil
+}
+
+// RemoteCommand is a command running in a pooled SSH session
+type RemoteCommand struct {
+	session *ssh.Session
+	stdout  io.Reader
+	release func()
+	stop    func() bool
+	eof     atomic.Bool
+}
+
+// Read reads the stdout of the command
+func (c *RemoteCommand) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF {
+		c.eof.Store(true)
+	}
+	return n, err
+}
+
+// Close waits for the command to exit and returns its session to the pool. If
+// stdout was not read to the end the session is closed first, which stops
+// the remote command from producing more output.
+func (c *RemoteCommand) Close() error {
+	defer c.release()
+	defer c.stop()
+
+	if !c.eof.Load() {
+		c.session.Close()
+	}
+	err := c.session.Wait()
+	c.session.Close()
+	return err
+}
+
+// StartSubsystem starts a subsystem like "sftp" on a remote server and
+// returns the stream of its protocol. Cancelling ctx closes the session.
+func (s *SSHService) StartSubsystem(ctx context.Context, serverConfig *config.Server, subsystem string) (*RemoteSubsystem, error) {
+	s.logger.Debugf("Starting %s subsystem on %s", subsystem, s.target(serverConfig))
+
+	session, release, err := s.newSession(ctx, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	var stdout io.Reader
+	if err == nil {
+		stdout, err = session.StdoutPipe()
+	}
+	if err == nil {
+		err = session.RequestSubsystem(subsystem)
+	}
+	if err != nil {
+		session.Close()
+		release()
+		return nil, fmt.Errorf("failed to start %s subsystem: %w", subsystem, err)
+	}
+
+	return &RemoteSubsystem{
+		Reader:  stdout,
+		Writer:  stdin,
+		session: session,
+		release: release,
+		stop:    context.AfterFunc(ctx, func() { session.Close() }),
+	}, nil
+}
+
+// RemoteSubsystem is a subsystem running in a pooled SSH session. It reads
+// from and writes to the subsystem.
+type RemoteSubsystem struct {
+	io.Reader
+	io.Writer
+	session *ssh.Session
+	release func()
+	stop    func() bool
+}
+
+// Close ends the subsystem and returns its session to the pool
+func (c *RemoteSubsystem) Close() error {
+	defer c.release()
+	c.stop()
+	return c.session.Close()
+}
 
 // ExecuteCommand executes a command over SSH (keeping for backward compatibility)
 func (s *SSHService) ExecuteCommand(client *ssh.Client, command string) (string, error) {
@@ -82,45 +249,631 @@ func (s *SSHService) ExecuteCommand(client *ssh.Client, command string) (string,
 }
 
 // TestConnection tests SSH connection to a server
-func (s *SSHService) TestConnection(config SSHConfig) error {
-	// Simple test using system ssh
-	cmd := exec.Command("ssh", "-o", "ConnectTimeout=10", config.Host, "echo 'test'")
-	_, err := cmd.CombinedOutput()
-	return err
+func (s *SSHService) TestConnection(serverConfig *config.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sshDialTimeout)
+	defer cancel()
+
+	return s.RunRemoteCommand(ctx, serverConfig, "true", nil, io.Discard, io.Discard)
 }
 
-// createSSHClient creates an SSH client from SSHConfig (if needed for other operations)
-func (s *SSHService) createSSHClient(config SSHConfig) (*ssh.Client, error) {
-	var auth []ssh.AuthMethod
+// newSession opens a session on a pooled connection to the server. The
+// returned release function must be called once the session is closed.
+func (s *SSHService) newSession(ctx context.Context, serverConfig *config.Server) (*ssh.Session, func(), error) {
+	var lastErr error
 
-	// Use private key if provided
-	if config.PrivateKey != "" {
-		key, err := ssh.ParsePrivateKey([]byte(config.PrivateKey))
+	// A pooled connection may have died since it was last used, so retry
+	// once on a fresh connection
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err := s.acquire(ctx, serverConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
+			return nil, nil, err
 		}
-		auth = append(auth, ssh.PublicKeys(key))
+
+		session, err := conn.client.NewSession()
+		if err == nil && serverConfig.ForwardAgent {
+			if err = agent.RequestAgentForwarding(session); err != nil {
+				session.Close()
+			}
+		}
+		if err == nil {
+			return session, func() { s.releaseConn(conn) }, nil
+		}
+
+		s.logger.Warnf("Failed to open SSH session to %s, reconnecting: %v", s.target(serverConfig), err)
+		s.discard(conn)
+		lastErr = err
 	}
 
-	// Use password if provided
-	if config.Password != "" {
-		auth = append(auth, ssh.Password(config.Password))
+	return nil, nil, fmt.Errorf("failed to create SSH session: %w", lastErr)
+}
+
+// acquire returns the least busy pooled connection to the server with a free
+// session slot, dialing a new one if there is none
+func (s *SSHService) acquire(ctx context.Context, serverConfig *config.Server) (*pooledConn, error) {
+	key := poolKey(serverConfig)
+
+	s.mu.Lock()
+	var best *pooledConn
+	for _, conn := range s.pools[key] {
+		if conn.sessions < maxSessionsPerConn && (best == nil || conn.sessions < best.sessions) {
+			best = conn
+		}
+	}
+	if best != nil {
+		best.sessions++
+		s.mu.Unlock()
+		return best, nil
 	}
+	s.mu.Unlock()
 
-	// SSH client configuration
-	sshConfig := &ssh.ClientConfig{
-		User:            config.Username,
+	// Dial without holding the lock so other servers are not held up
+	client, err := s.createSSHClient(ctx, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &pooledConn{key: key, client: client, sessions: 1}
+	s.mu.Lock()
+	s.pools[key] = append(s.pools[key], conn)
+	s.mu.Unlock()
+
+	// Drop the connection from the pool as soon as it dies
+	go func() {
+		client.Wait()
+		s.discard(conn)
+	}()
+
+	s.logger.Debugf("Opened pooled SSH connection to %s", s.target(serverConfig))
+	return conn, nil
+}
+
+// releaseConn frees the session slot taken by acquire
+func (s *SSHService) releaseConn(conn *pooledConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn.sessions--
+	conn.lastUsed = time.Now()
+}
+
+// discard removes a connection from its pool and closes it
+func (s *SSHService) discard(conn *pooledConn) {
+	s.mu.Lock()
+	pool := s.pools[conn.key]
+	for i, pooled := range pool {
+		if pooled == conn {
+			s.pools[conn.key] = append(pool[:i:i], pool[i+1:]...)
+			break
+		}
+	}
+	if len(s.pools[conn.key]) == 0 {
+		delete(s.pools, conn.key)
+	}
+	s.mu.Unlock()
+
+	conn.client.Close()
+}
+
+// closeIdleConnections periodically closes connections that have had no
+// sessions for longer than connIdleTimeout
+func (s *SSHService) closeIdleConnections() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var idle []*pooledConn
+
+		s.mu.Lock()
+		for _, pool := range s.pools {
+			for _, conn := range pool {
+				if conn.sessions == 0 && time.Since(conn.lastUsed) > connIdleTimeout {
+					idle = append(idle, conn)
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		for _, conn := range idle {
+			s.logger.Debugf("Closing idle SSH connection %s", conn.key)
+			s.discard(conn)
+		}
+	}
+}
+
+// dialFunc opens a network connection to an address
+type dialFunc func(ctx context.Context, address string) (net.Conn, error)
+
+// createSSHClient connects to the server, through its jump hosts if it has
+// any, and performs the SSH handshake
+func (s *SSHService) createSSHClient(ctx context.Context, serverConfig *config.Server) (*ssh.Client, error) {
+	// The agent is only needed while authenticating, forwarded sessions
+	// connect to it on their own
+	agentConn, agentClient := s.dialAgent()
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+
+	sshConfig, err := s.clientConfig(serverConfig, agentClient)
+	if err != nil {
+		return nil, err
+	}
+
+	netConn, jumps, err := s.dialServer(ctx, serverConfig, agentClient)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.handshake(ctx, netConn, sshAddress(serverConfig), sshConfig)
+	if err != nil {
+		closeClients(jumps)
+		return nil, err
+	}
+
+	// The jump host connections live as long as the tunnelled connection
+	if len(jumps) > 0 {
+		go func() {
+			client.Wait()
+			closeClients(jumps)
+		}()
+	}
+
+	if serverConfig.ForwardAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			client.Close()
+			return nil, fmt.Errorf("agent forwarding is enabled for server %s but SSH_AUTH_SOCK is not set", serverConfig.ID)
+		}
+		if err := agent.ForwardToRemote(client, socket); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to set up agent forwarding: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// clientConfig builds the SSH client configuration for a server
+func (s *SSHService) clientConfig(serverConfig *config.Server, agentClient agent.ExtendedAgent) (*ssh.ClientConfig, error) {
+	auth, err := s.authMethods(serverConfig, agentClient)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := s.hostKeys.Callback(serverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            sshUser(serverConfig),
 		Auth:            auth,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use proper host key verification
-		Timeout:         30 * time.Second,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// dialServer opens a network connection to the SSH port of a server. For
+// servers behind jump hosts the connection is tunnelled through each hop in
+// turn, and the returned jump host clients must be closed once the
+// connection is done with.
+func (s *SSHService) dialServer(ctx context.Context, serverConfig *config.Server, agentClient agent.ExtendedAgent) (net.Conn, []*ssh.Client, error) {
+	var jumps []*ssh.Client
+	dial := dialTCP
+
+	for i := range serverConfig.JumpHosts {
+		hop := jumpServer(serverConfig, i)
+		address := sshAddress(hop)
+
+		sshConfig, err := s.clientConfig(hop, agentClient)
+		if err != nil {
+			closeClients(jumps)
+			return nil, nil, fmt.Errorf("jump host %s: %w", address, err)
+		}
+
+		netConn, err := dial(ctx, address)
+		if err != nil {
+			closeClients(jumps)
+			return nil, nil, fmt.Errorf("jump host %s: failed to connect: %w", address, err)
+		}
+
+		client, err := s.handshake(ctx, netConn, address, sshConfig)
+		if err != nil {
+			closeClients(jumps)
+			return nil, nil, fmt.Errorf("jump host %s: %w", address, err)
+		}
+
+		s.logger.Debugf("Connected to jump host %s for server %s", address, serverConfig.ID)
+		jumps = append(jumps, client)
+		dial = tunnelDialer(client)
 	}
 
-	// Connect to the remote server
-	address := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	client, err := ssh.Dial("tcp", address, sshConfig)
+	address := sshAddress(serverConfig)
+	netConn, err := dial(ctx, address)
 	if err != nil {
+		closeClients(jumps)
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	return netConn, jumps, nil
+}
+
+// handshake performs the SSH handshake on an established connection, which
+// is closed if the handshake fails
+func (s *SSHService) handshake(ctx context.Context, netConn net.Conn, address string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	// Bound the handshake as well, which the dial timeout does not cover.
+	// Tunnelled connections do not support deadlines, so close the
+	// connection instead.
+	timer := time.AfterFunc(sshDialTimeout, func() { netConn.Close() })
+	stop := context.AfterFunc(ctx, func() { netConn.Close() })
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(netConn, address, sshConfig)
+	timedOut := !timer.Stop()
+	cancelled := !stop()
+	if err == nil && (timedOut || cancelled) {
+		clientConn.Close()
+		err = errors.New("handshake timed out")
+		if cancelled {
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		netConn.Close()
 		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
 	}
 
-	return client, nil
-}
\ No newline at end of file
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// dialTCP opens a direct TCP connection
+func dialTCP(ctx context.Context, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: sshDialTimeout}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// tunnelDialer opens connections through an SSH connection, like ssh -J
+func tunnelDialer(client *ssh.Client) dialFunc {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+
+		// Dial does not take a context, so stop waiting for it on
+		// cancellation and close whatever it returns afterwards
+		done := make(chan result, 1)
+		go func() {
+			conn, err := client.Dial("tcp", address)
+			done <- result{conn, err}
+		}()
+
+		select {
+		case r := <-done:
+			return r.conn, r.err
+		case <-ctx.Done():
+			go func() {
+				if r := <-done; r.conn != nil {
+					r.conn.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// closeClients closes SSH clients, the last one first
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+// jumpServer returns the i-th jump host of a server as a server
+// configuration, so hops authenticate and verify host keys like servers
+func jumpServer(serverConfig *config.Server, i int) *config.Server {
+	hop := serverConfig.JumpHosts[i]
+	return &config.Server{
+		ID:                   fmt.Sprintf("%s/jump-%d", serverConfig.ID, i+1),
+		Host:                 hop.Host,
+		Port:                 hop.Port,
+		Username:             hop.Username,
+		Password:             hop.Password,
+		PrivateKey:           hop.PrivateKey,
+		PrivateKeyPassphrase: hop.PrivateKeyPassphrase,
+		HostKeyFingerprint:   hop.HostKeyFingerprint,
+		KnownHostsFile:       hop.KnownHostsFile,
+	}
+}
+
+// errHostKeyScanned aborts the handshake of a host key scan once the key is known
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanHostKey connects to a server just long enough to learn the host key it
+// presents, without verifying or authenticating. Jump hosts are verified and
+// authenticated as usual.
+func (s *SSHService) ScanHostKey(ctx context.Context, serverConfig *config.Server) (ssh.PublicKey, error) {
+	var presented ssh.PublicKey
+	sshConfig := &ssh.ClientConfig{
+		User: sshUser(serverConfig),
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			presented = key
+			return errHostKeyScanned
+		},
+		Timeout: sshDialTimeout,
+	}
+
+	var agentClient agent.ExtendedAgent
+	if len(serverConfig.JumpHosts) > 0 {
+		var agentConn net.Conn
+		agentConn, agentClient = s.dialAgent()
+		if agentConn != nil {
+			defer agentConn.Close()
+		}
+	}
+
+	netConn, jumps, err := s.dialServer(ctx, serverConfig, agentClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan host key: %w", err)
+	}
+	defer closeClients(jumps)
+
+	client, err := s.handshake(ctx, netConn, sshAddress(serverConfig), sshConfig)
+	if client != nil {
+		client.Close()
+	}
+	if presented == nil {
+		return nil, fmt.Errorf("failed to scan host key: %w", err)
+	}
+	return presented, nil
+}
+
+// HostKeyInfo reports the accepted host keys of a server and whether the key
+// it currently presents matches them
+func (s *SSHService) HostKeyInfo(ctx context.Context, serverConfig *config.Server) (models.HostKeyResponse, error) {
+	info := models.HostKeyResponse{
+		ServerID: serverConfig.ID,
+		Address:  sshAddress(serverConfig),
+		Source:   s.hostKeys.Source(serverConfig),
+	}
+
+	accepted, err := s.hostKeys.Accepted(serverConfig)
+	if err != nil {
+		return info, err
+	}
+	info.Accepted = accepted
+
+	presented, err := s.ScanHostKey(ctx, serverConfig)
+	if err != nil {
+		info.Error = err.Error()
+		return info, nil
+	}
+	presentedKey := hostKeyOf(presented)
+	info.Presented = &presentedKey
+
+	for _, key := range accepted {
+		if key.Fingerprint == presentedKey.Fingerprint {
+			info.Matches = true
+		}
+	}
+	return info, nil
+}
+
+// RotateHostKey accepts the host key a server currently presents in place of
+// the previously accepted one. The expected fingerprint must match the
+// presented key, so the new key has to be confirmed out of band.
+func (s *SSHService) RotateHostKey(ctx context.Context, serverConfig *config.Server, fingerprint string) (models.HostKeyResponse, error) {
+	if s.hostKeys.Source(serverConfig) != HostKeySourceManaged {
+		return models.HostKeyResponse{}, ErrHostKeyNotManaged
+	}
+
+	presented, err := s.ScanHostKey(ctx, serverConfig)
+	if err != nil {
+		return models.HostKeyResponse{}, err
+	}
+
+	if got := ssh.FingerprintSHA256(presented); got != normalizeFingerprint(fingerprint) {
+		return models.HostKeyResponse{}, fmt.Errorf("server presented host key %s, not the confirmed %s", got, normalizeFingerprint(fingerprint))
+	}
+
+	if err := s.hostKeys.Replace(serverConfig, presented); err != nil {
+		return models.HostKeyResponse{}, err
+	}
+
+	// Connections verified against the old key must not be reused
+	s.discardServer(serverConfig)
+
+	return s.HostKeyInfo(ctx, serverConfig)
+}
+
+// discardServer closes all pooled connections of a server
+func (s *SSHService) discardServer(serverConfig *config.Server) {
+	s.mu.Lock()
+	pool := append([]*pooledConn(nil), s.pools[poolKey(serverConfig)]...)
+	s.mu.Unlock()
+
+	for _, conn := range pool {
+		s.discard(conn)
+	}
+}
+
+// authMethods builds the authentication methods for a server, in the order
+// ssh tries them: public keys from the SSH agent and the configured private
+// key (or the default identity files), then password and keyboard-interactive
+// authentication. agentClient may be nil when no agent is available.
+func (s *SSHService) authMethods(serverConfig *config.Server, agentClient agent.ExtendedAgent) ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+
+	var signers []ssh.Signer
+	if agentClient != nil {
+		agentSigners, err := agentClient.Signers()
+		if err != nil {
+			s.logger.Warnf("Failed to list SSH agent keys: %v", err)
+		}
+		signers = append(signers, agentSigners...)
+	}
+
+	if serverConfig.PrivateKey != "" {
+		signer, err := loadPrivateKey(serverConfig.PrivateKey, serverConfig.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else {
+		for _, keyFile := range defaultIdentityFiles() {
+			key, err := os.ReadFile(keyFile)
+			if err != nil {
+				continue
+			}
+
+			signer, err := ssh.ParsePrivateKey(key)
+			if err != nil {
+				// Encrypted default keys are only usable through the agent
+				s.logger.Debugf("Skipping identity file %s: %v", keyFile, err)
+				continue
+			}
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	// Use password if provided, for servers that only allow it through
+	// keyboard-interactive authentication as well
+	if serverConfig.Password != "" {
+		password := serverConfig.Password
+		auth = append(auth, ssh.Password(password))
+		auth = append(auth, ssh.KeyboardInteractive(passwordChallenge(password)))
+	}
+
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no SSH credentials available for server %s", serverConfig.ID)
+	}
+	return auth, nil
+}
+
+// passwordChallenge answers keyboard-interactive prompts asking for the
+// password. Any other prompt, such as a one-time code, fails the challenge
+// rather than being sent the password.
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if !strings.Contains(strings.ToLower(question), "password") {
+				return nil, fmt.Errorf("unsupported keyboard-interactive prompt %q", question)
+			}
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
+
+// loadPrivateKey parses a private key given either inline as PEM or as the
+// path of a key file, decrypting it with passphrase if it is encrypted
+func loadPrivateKey(privateKey, passphrase string) (ssh.Signer, error) {
+	var key []byte
+	if isInlineKey(privateKey) {
+		key = []byte(privateKey)
+	} else {
+		path := privateKey
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, rest)
+			}
+		}
+
+		var err error
+		key, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+	}
+
+	var signer ssh.Signer
+	var err error
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key is encrypted, set private_key_passphrase")
+		}
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// isInlineKey reports whether a private_key setting holds the key itself
+// rather than the path of a key file
+func isInlineKey(privateKey string) bool {
+	return strings.Contains(privateKey, "-----BEGIN ")
+}
+
+// dialAgent connects to the SSH agent at SSH_AUTH_SOCK, returning nil if
+// there is none
+func (s *SSHService) dialAgent() (net.Conn, agent.ExtendedAgent) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		s.logger.Warnf("Failed to connect to SSH agent at %s: %v", socket, err)
+		return nil, nil
+	}
+	return conn, agent.NewClient(conn)
+}
+
+// defaultIdentityFiles returns the identity files ssh would try by default
+func defaultIdentityFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		files = append(files, filepath.Join(home, ".ssh", name))
+	}
+	return files
+}
+
+// target returns the user@host form of a server for log messages
+func (s *SSHService) target(serverConfig *config.Server) string {
+	if serverConfig.Username != "" {
+		return fmt.Sprintf("%s@%s", serverConfig.Username, serverConfig.Host)
+	}
+	return serverConfig.Host
+}
+
+// poolKey identifies the connection pool of a server. Connection settings
+// are part of the key so changed settings never reuse stale connections.
+// Credentials and jump hosts are hashed, as the key shows up in log messages.
+func poolKey(serverConfig *config.Server) string {
+	settings := sha256.Sum256([]byte(strings.Join([]string{serverConfig.PrivateKey, serverConfig.PrivateKeyPassphrase,
+		serverConfig.Password, strconv.FormatBool(serverConfig.ForwardAgent), fmt.Sprint(serverConfig.JumpHosts)}, "\x00")))
+	return fmt.Sprintf("%s|%s@%s|%x|%s|%s", serverConfig.ID, sshUser(serverConfig), sshAddress(serverConfig),
+		settings[:8], serverConfig.HostKeyFingerprint, serverConfig.KnownHostsFile)
+}
+
+// sshUser returns the login user, defaulting to the local user like ssh does
+func sshUser(serverConfig *config.Server) string {
+	if serverConfig.Username != "" {
+		return serverConfig.Username
+	}
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "root"
+}
+
+// sshAddress returns the host:port to connect to
+func sshAddress(serverConfig *config.Server) string {
+	port := serverConfig.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(serverConfig.Host, strconv.Itoa(port))
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordChallenge(t *testing.T) {
+	challenge := passwordChallenge("secret")
+
+	for _, tc := range []struct {
+		name      string
+		questions []string
+		answers   []string
+		err       string
+	}{
+		{"no prompts", nil, []string{}, ""},
+		{"password", []string{"Password: "}, []string{"secret"}, ""},
+		{"user and host in prompt", []string{"alice@db1's password: "}, []string{"secret"}, ""},
+		{"upper case", []string{"PASSWORD:"}, []string{"secret"}, ""},
+		{"one-time code", []string{"Verification code: "}, nil, `unsupported keyboard-interactive prompt "Verification code: "`},
+		{"password then code", []string{"Password: ", "OTP: "}, nil, `unsupported keyboard-interactive prompt "OTP: "`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			answers, err := challenge("", "", tc.questions, make([]bool, len(tc.questions)))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				assert.Nil(t, answers)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.answers, answers)
+		})
+	}
+}
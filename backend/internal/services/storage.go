@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"backend/internal/config"
+)
+
+var (
+	// ErrStorageNotFound is returned for unknown storage names
+	ErrStorageNotFound = errors.New("storage not found")
+	// ErrObjectNotFound is returned for keys that do not exist in a storage
+	ErrObjectNotFound = errors.New("object not found")
+)
+
+// LocalStorageName is the name of the storage in the backup directory
+const LocalStorageName = "local"
+
+// Storage types of the storage configuration
+const (
+	StorageTypeLocal = "local"
+	StorageTypeS3    = "s3"
+	StorageTypeSFTP  = "sftp"
+)
+
+// Storage is a place backups are kept. Keys are slash separated relative
+// paths like "nightly/remote-1/db/20260101T000000Z_db.dump".
+type Storage interface {
+	// Name returns the configured name of the storage
+	Name() string
+	// Put streams r to key, replacing what was there. The object only shows
+	// up under key once it was written completely.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open opens the object at key for reading
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object at key
+	Delete(ctx context.Context, key string) error
+	// List returns the objects with keys starting with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]StorageObject, error)
+}
+
+// StorageObject describes an object in a storage
+type StorageObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// StorageService holds the configured storages by name
+type StorageService struct {
+	storages map[string]Storage
+	logger   *logrus.Logger
+}
+
+// NewStorageService creates the local storage in the backup directory and
+// the storages of the configuration
+func NewStorageService(cfg config.Backups, configWatcher *config.Watcher, sshService *SSHService, logger *logrus.Logger) (*StorageService, error) {
+	local, err := NewLocalStorage(LocalStorageName, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &StorageService{
+		storages: map[string]Storage{LocalStorageName: local},
+		logger:   logger,
+	}
+
+	for _, storageConfig := range cfg.Storage {
+		if storageConfig.Name == "" {
+			return nil, fmt.Errorf("storage without a name")
+		}
+		if _, exists := s.storages[storageConfig.Name]; exists {
+			return nil, fmt.Errorf("duplicate storage %q", storageConfig.Name)
+		}
+
+		var storage Storage
+		switch storageConfig.Type {
+		case StorageTypeLocal:
+			storage, err = NewLocalStorage(storageConfig.Name, storageConfig.Path)
+		case StorageTypeS3:
+			storage, err = NewS3Storage(storageConfig)
+		case StorageTypeSFTP:
+			storage, err = NewSFTPStorage(storageConfig, configWatcher, sshService)
+		default:
+			err = fmt.Errorf("unknown type %q, must be local, s3 or sftp", storageConfig.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("storage %q: %w", storageConfig.Name, err)
+		}
+		s.storages[storageConfig.Name] = storage
+	}
+
+	logger.Infof("Configured backup storage: %s", strings.Join(s.Names(), ", "))
+	return s, nil
+}
+
+// Get returns a storage by its name, the local storage for an empty name
+func (s *StorageService) Get(name string) (Storage, error) {
+	if name == "" {
+		name = LocalStorageName
+	}
+	storage, exists := s.storages[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrStorageNotFound, name)
+	}
+	return storage, nil
+}
+
+// Names returns the names of all storages, sorted
+func (s *StorageService) Names() []string {
+	names := make([]string, 0, len(s.storages))
+	for name := range s.storages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkStorageKey rejects keys that could escape the storage, like
+// "../etc/passwd" or "/etc/passwd"
+func checkStorageKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	return nil
+}
+
+// listPrefixDir returns the directory part of a list prefix, the directory
+// a walk for the prefix has to start in
+func listPrefixDir(prefix string) string {
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// LocalStorage keeps backups in a local directory
+type LocalStorage struct {
+	name string
+	dir  string
+}
+
+// NewLocalStorage creates a storage in dir, creating the directory
+func NewLocalStorage(name, dir string) (*LocalStorage, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStorage{name: name, dir: dir}, nil
+}
+
+// Name returns the name of the storage
+func (s *LocalStorage) Name() string {
+	return s.name
+}
+
+// Put writes r to a partial file next to the path of key and renames it
+// into place once complete
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := checkStorageKey(key); err != nil {
+		return 0, err
+	}
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	partialPath := target + partialSuffix
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	written, err := io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partialPath, target)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return written, fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return written, nil
+}
+
+// Open opens the file of key
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkStorageKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return file, err
+}
+
+// Delete removes the file of key
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := checkStorageKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return err
+}
+
+// List walks the directory of prefix for files, leaving out partial files
+// that are still being written
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	root := filepath.Join(s.dir, filepath.FromSlash(listPrefixDir(prefix)))
+
+	var objects []StorageObject
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), partialSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			return nil
+		}
+		objects = append(objects, StorageObject{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.dir, err)
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+// sortObjects sorts objects by key
+func sortObjects(objects []StorageObject) {
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+}
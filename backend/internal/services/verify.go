@@ -0,0 +1,593 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"backend/internal/config"
+	"backend/internal/models"
+)
+
+// ErrVerificationRunning is returned when a backup is verified while its
+// previous verification has not finished
+var ErrVerificationRunning = errors.New("backup is already being verified")
+
+const (
+	// maxParallelVerifications bounds the throwaway containers running at
+	// once, further verifications wait for a slot
+	maxParallelVerifications = 2
+	// verifyReadyTimeout bounds the start of PostgreSQL in a container
+	verifyReadyTimeout = 2 * time.Minute
+	// verifyDir is the scratch directory in the container the dump is
+	// copied to
+	verifyDir = "/tmp/verify"
+	// maxCheckDetails caps the differences listed in a check message
+	maxCheckDetails = 5
+	// rowEstimateTolerance and rowEstimateSlack bound how far row estimates
+	// may be off. Autovacuum analyzes a table again once 10% of its rows
+	// plus 50 have changed.
+	rowEstimateTolerance = 0.1
+	rowEstimateSlack     = 50
+)
+
+// postgresVersionPattern matches the PostgreSQL major versions images are
+// tagged with, like 16 or 9.6
+var postgresVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// VerificationService verifies backups of the catalog by restoring them
+// into throwaway PostgreSQL containers and comparing the result with the
+// row estimates recorded at backup time, or the source database
+type VerificationService struct {
+	configWatcher     *config.Watcher
+	dockerService     *DockerService
+	postgresService   *PostgresService
+	sshService        *SSHService
+	storageService    *StorageService
+	encryptionService *EncryptionService
+	catalog           *BackupCatalog
+	logger            *logrus.Logger
+
+	slots chan struct{}
+
+	mu      sync.Mutex
+	running map[string]*models.BackupVerification
+}
+
+// NewVerificationService creates a new verification service
+func NewVerificationService(
+	configWatcher *config.Watcher,
+	dockerService *DockerService,
+	postgresService *PostgresService,
+	sshService *SSHService,
+	storageService *StorageService,
+	encryptionService *EncryptionService,
+	catalog *BackupCatalog,
+	logger *logrus.Logger,
+) *VerificationService {
+	return &VerificationService{
+		configWatcher:     configWatcher,
+		dockerService:     dockerService,
+		postgresService:   postgresService,
+		sshService:        sshService,
+		storageService:    storageService,
+		encryptionService: encryptionService,
+		catalog:           catalog,
+		logger:            logger,
+		slots:             make(chan struct{}, maxParallelVerifications),
+		running:           make(map[string]*models.BackupVerification),
+	}
+}
+
+// Start queues a verification of a backup in a container on server. secrets
+// decrypt backups encrypted with a passphrase or an identity that is not
+// configured.
+func (s *VerificationService) Start(backup models.Backup, server *config.Server, req models.VerifyRequest, secrets DecryptionSecrets, requestedBy string) (models.BackupVerification, error) {
+	if req.RowTolerance < 0 || req.RowTolerance >= 1 {
+		return models.BackupVerification{}, fmt.Errorf("row_tolerance must be at least 0 and below 1")
+	}
+	version := req.Version
+	if version == "" {
+		var err error
+		version, err = postgresMajorVersion(backup.ServerVersion)
+		if err != nil {
+			return models.BackupVerification{}, fmt.Errorf("%w, give the version to verify with", err)
+		}
+	}
+	if !postgresVersionPattern.MatchString(version) {
+		return models.BackupVerification{}, fmt.Errorf("invalid PostgreSQL version %q", version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.running[backup.ID]; exists {
+		return models.BackupVerification{}, ErrVerificationRunning
+	}
+	verification := &models.BackupVerification{
+		Status:      models.JobStatusQueued,
+		ServerID:    server.ID,
+		Image:       s.configWatcher.Current().Backups.VerifyImage + ":" + version,
+		RequestedBy: requestedBy,
+		StartedAt:   time.Now(),
+	}
+	s.running[backup.ID] = verification
+
+	go s.run(backup, server, req, secrets, verification)
+	return *verification, nil
+}
+
+// Current returns the verification of a backup in progress
+func (s *VerificationService) Current(backupID string) (models.BackupVerification, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	verification, exists := s.running[backupID]
+	if !exists {
+		return models.BackupVerification{}, false
+	}
+	return *verification, true
+}
+
+// run waits for a slot, verifies a backup and records the result in the
+// catalog
+func (s *VerificationService) run(backup models.Backup, server *config.Server, req models.VerifyRequest, secrets DecryptionSecrets, verification *models.BackupVerification) {
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+
+	s.mu.Lock()
+	verification.Status = models.JobStatusRunning
+	image := verification.Image
+	s.mu.Unlock()
+
+	s.logger.Infof("Verifying backup %s (%s) on server %s with %s", backup.ID, backup.Key, server.ID, image)
+	ctx, cancel := context.WithTimeout(context.Background(), s.configWatcher.Current().Backups.VerifyTimeout)
+	checks, err := s.verify(ctx, backup, server, image, req, secrets)
+	cancel()
+
+	s.mu.Lock()
+	finishedAt := time.Now()
+	verification.Checks = checks
+	verification.FinishedAt = &finishedAt
+	verification.Duration = finishedAt.Sub(verification.StartedAt).Round(time.Second).String()
+	verification.Status = models.VerificationPassed
+	if err != nil {
+		verification.Status = models.VerificationFailed
+		verification.Error = err.Error()
+	}
+	for _, check := range checks {
+		if check.Status == models.CheckFailed {
+			verification.Status = models.VerificationFailed
+		}
+	}
+	result := *verification
+	s.mu.Unlock()
+
+	if result.Status == models.VerificationPassed {
+		s.logger.Infof("Backup %s passed verification in %s", backup.ID, result.Duration)
+	} else {
+		s.logger.Warnf("Backup %s failed verification: %s", backup.ID, verificationFailure(result))
+	}
+
+	if err := s.catalog.SetVerification(backup.ID, result); err != nil {
+		s.logger.Errorf("Failed to record verification of backup %s: %v", backup.ID, err)
+	}
+
+	s.mu.Lock()
+	delete(s.running, backup.ID)
+	s.mu.Unlock()
+}
+
+// verify restores a backup into a throwaway container on server and runs
+// the checks. The container is removed again in any case.
+func (s *VerificationService) verify(ctx context.Context, backup models.Backup, server *config.Server, image string, req models.VerifyRequest, secrets DecryptionSecrets) ([]models.VerificationCheck, error) {
+	// Local connections in the container need no password, and it has no
+	// network to be reached from otherwise
+	name := "pgm-verify-" + backup.ID[:12]
+	containerID, err := s.dockerService.RunContainer(ctx, server, name, image, []string{"POSTGRES_HOST_AUTH_METHOD=trust"}, s.sshService)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.dockerService.RemoveContainer(ctx, server, containerID, s.sshService); err != nil {
+			s.logger.Warnf("Failed to remove verification container %s: %v", name, err)
+		}
+	}()
+
+	if err := s.waitReady(ctx, server, containerID); err != nil {
+		return nil, err
+	}
+	check, err := s.copyBackup(ctx, backup, server, containerID, secrets)
+	if err != nil {
+		return nil, err
+	}
+	checks := []models.VerificationCheck{check}
+	if check.Status == models.CheckFailed {
+		return checks, nil
+	}
+
+	// The container is only ever used as postgres
+	target := *server
+	target.PostgresUser = "postgres"
+
+	source, sourceContainer, sourceErr := s.source(backup)
+
+	rolesCopied := false
+	if sourceErr == nil {
+		var roles string
+		roles, err = s.postgresService.DumpRoles(ctx, source, sourceContainer, s.sshService)
+		if err == nil {
+			// Without ON_ERROR_STOP, roles that exist already are skipped
+			_, err = s.dockerService.Exec(ctx, server, containerID, NewCommand("psql", "-X", "-q", "-U", "postgres", "-d", "postgres"), strings.NewReader(roles), s.sshService)
+		}
+		if err != nil {
+			checks = append(checks, models.VerificationCheck{Name: "roles", Status: models.CheckSkipped, Message: err.Error()})
+		} else {
+			rolesCopied = true
+			checks = append(checks, models.VerificationCheck{Name: "roles", Status: models.CheckPassed, Message: "copied the roles of the source"})
+		}
+	} else {
+		checks = append(checks, models.VerificationCheck{Name: "roles", Status: models.CheckSkipped, Message: sourceErr.Error()})
+	}
+
+	var archive string
+	archive, check = s.checkArchive(ctx, backup, server, containerID)
+	checks = append(checks, check)
+	if check.Status == models.CheckFailed {
+		return checks, nil
+	}
+
+	check = s.restore(ctx, backup, server, containerID, archive, rolesCopied)
+	checks = append(checks, check)
+	if check.Status == models.CheckFailed {
+		return checks, nil
+	}
+
+	restored, err := s.postgresService.TableRowCounts(ctx, &target, containerID, backup.Database, s.sshService)
+	if err != nil {
+		return checks, err
+	}
+
+	// Counting the rows of the source scans all of its tables and sees rows
+	// written since the backup, so the estimates of the backup are the
+	// default, with the tolerance they need
+	var expected map[string]int64
+	var reference string
+	tolerance, slack := math.Max(req.RowTolerance, rowEstimateTolerance), int64(rowEstimateSlack)
+	switch {
+	case req.CompareSource:
+		if sourceErr == nil {
+			expected, sourceErr = s.postgresService.TableRowCounts(ctx, source, sourceContainer, backup.Database, s.sshService)
+		}
+		if sourceErr != nil {
+			return append(checks, skippedRowChecks(len(restored), "source not available: "+sourceErr.Error())...), nil
+		}
+		reference = "in the source"
+		tolerance, slack = req.RowTolerance, 0
+	case backup.RowEstimates != nil:
+		expected, reference = backup.RowEstimates, "at backup"
+	default:
+		return append(checks, skippedRowChecks(len(restored), "no row estimates were recorded with the backup, set compare_source to compare with the source")...), nil
+	}
+
+	checks = append(checks, checkTables(backup.Options, restored, expected, reference), checkRows(backup.Options, restored, expected, reference, tolerance, slack))
+	return checks, nil
+}
+
+// waitReady waits until PostgreSQL in a container accepts TCP connections.
+// The entrypoint only listens on TCP once initialization is done.
+func (s *VerificationService) waitReady(ctx context.Context, server *config.Server, containerID string) error {
+	ctx, cancel := context.WithTimeout(ctx, verifyReadyTimeout)
+	defer cancel()
+
+	ready := NewCommand("pg_isready", "-q", "-h", "127.0.0.1", "-U", "postgres")
+	for {
+		_, err := s.dockerService.Exec(ctx, server, containerID, ready, nil, s.sshService)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("PostgreSQL did not start in the container: %w", err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// copyBackup streams a backup from its storage into the scratch directory
+// of a container, decrypted and decompressed. The stored bytes are hashed on
+// the way and checked against the checksum of the catalog.
+func (s *VerificationService) copyBackup(ctx context.Context, backup models.Backup, server *config.Server, containerID string, secrets DecryptionSecrets) (models.VerificationCheck, error) {
+	check := models.VerificationCheck{Name: "checksum"}
+	storage, err := s.storageService.Get(backup.Storage)
+	if err != nil {
+		return check, err
+	}
+	reader, err := storage.Open(ctx, backup.Key)
+	if err != nil {
+		return check, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	stored := io.TeeReader(reader, hash)
+	input, err := s.encryptionService.DecryptInput(stored, secrets)
+	if err == nil {
+		input, _, err = PrepareRestoreInput(input, backup.Format)
+	}
+	if err != nil {
+		return check, err
+	}
+
+	script := fmt.Sprintf("mkdir -p %[1]s && cat > %[1]s/dump", verifyDir)
+	if _, err := s.dockerService.Exec(ctx, server, containerID, shellScript(script), input, s.sshService); err != nil {
+		return check, fmt.Errorf("failed to copy backup into the container: %w", err)
+	}
+	// Data after the end of the dump is part of the stored file as well
+	if _, err := io.Copy(io.Discard, stored); err != nil {
+		return check, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	switch {
+	case backup.SHA256 == "":
+		check.Status = models.CheckSkipped
+		check.Message = "no checksum was recorded for the backup"
+	case sum != backup.SHA256:
+		check.Status = models.CheckFailed
+		check.Message = fmt.Sprintf("stored backup has SHA-256 %s instead of %s", sum, backup.SHA256)
+	default:
+		check.Status = models.CheckPassed
+		check.Message = "stored backup matches its SHA-256"
+	}
+	return check, nil
+}
+
+// checkArchive lists the table of contents of an archive with pg_restore
+// and returns the path of the archive in the container. Plain SQL dumps
+// have none.
+func (s *VerificationService) checkArchive(ctx context.Context, backup models.Backup, server *config.Server, containerID string) (string, models.VerificationCheck) {
+	check := models.VerificationCheck{Name: "archive"}
+	archive := verifyDir + "/dump"
+
+	switch backup.Format {
+	case models.DumpFormatPlain:
+		check.Status = models.CheckSkipped
+		check.Message = "plain SQL dumps have no table of contents"
+		return archive, check
+	case models.DumpFormatDirectory:
+		// Directory dumps are stored as a tarball of the directory
+		if _, err := s.dockerService.Exec(ctx, server, containerID, NewCommand("tar", "-C", verifyDir, "-xf", archive), nil, s.sshService); err != nil {
+			check.Status = models.CheckFailed
+			check.Message = "failed to unpack directory dump: " + err.Error()
+			return "", check
+		}
+		archive = verifyDir + "/" + backup.Database
+	}
+
+	output, err := s.dockerService.Exec(ctx, server, containerID, NewCommand("pg_restore", "--list", archive), nil, s.sshService)
+	if err != nil {
+		check.Status = models.CheckFailed
+		check.Message = err.Error()
+		return "", check
+	}
+
+	entries := 0
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, ";") {
+			entries++
+		}
+	}
+	check.Status = models.CheckPassed
+	check.Message = fmt.Sprintf("%d entries in the table of contents", entries)
+	return archive, check
+}
+
+// restore creates the database in the container and restores the archive
+// into it. Without the roles of the source, ownership and privileges of
+// archives are skipped.
+func (s *VerificationService) restore(ctx context.Context, backup models.Backup, server *config.Server, containerID, archive string, rolesCopied bool) models.VerificationCheck {
+	check := models.VerificationCheck{Name: "restore"}
+	started := time.Now()
+
+	var err error
+	if backup.Database != "postgres" {
+		_, err = s.dockerService.Exec(ctx, server, containerID, NewCommand("createdb", "-U", "postgres", "--", backup.Database), nil, s.sshService)
+	}
+	if err == nil {
+		var cmd Command
+		if backup.Format == models.DumpFormatPlain {
+			cmd = NewCommand("psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", "-U", "postgres", "-d", databaseArg(backup.Database), "-f", archive)
+		} else {
+			cmd = NewCommand("pg_restore", "--exit-on-error", "-U", "postgres", "-d", databaseArg(backup.Database))
+			if !rolesCopied {
+				cmd = cmd.With("--no-owner", "--no-privileges")
+			}
+			cmd = cmd.With(archive)
+		}
+		_, err = s.dockerService.Exec(ctx, server, containerID, cmd, nil, s.sshService)
+	}
+	if err != nil {
+		check.Status = models.CheckFailed
+		check.Message = err.Error()
+		return check
+	}
+
+	check.Status = models.CheckPassed
+	check.Message = fmt.Sprintf("restored in %s", time.Since(started).Round(time.Second))
+	return check
+}
+
+// source returns the server and container the backup was taken from, an
+// empty container for host PostgreSQL. Containers are addressed by name,
+// which survives the container being recreated.
+func (s *VerificationService) source(backup models.Backup) (*config.Server, string, error) {
+	server, err := s.configWatcher.Current().GetServerByID(backup.ServerID)
+	if err != nil {
+		return nil, "", err
+	}
+	switch backup.ContainerName {
+	case HostContainerName:
+		return server, "", nil
+	case "":
+		return server, backup.ContainerID, nil
+	default:
+		return server, backup.ContainerName, nil
+	}
+}
+
+// skippedRowChecks returns the tables and rows checks skipped for lack of
+// something to compare with
+func skippedRowChecks(tables int, message string) []models.VerificationCheck {
+	return []models.VerificationCheck{
+		{Name: "tables", Status: models.CheckSkipped, Message: fmt.Sprintf("%d tables restored, %s", tables, message)},
+		{Name: "rows", Status: models.CheckSkipped, Message: message},
+	}
+}
+
+// checkTables compares the tables restored with the tables expected, like
+// "in the source" as given by reference. Dumps of selected tables or
+// schemas are not expected to have them all.
+func checkTables(options models.DumpOptions, restored, expected map[string]int64, reference string) models.VerificationCheck {
+	check := models.VerificationCheck{Name: "tables"}
+	if len(options.Tables) > 0 || len(options.ExcludeTables) > 0 || len(options.Schemas) > 0 || len(options.ExcludeSchemas) > 0 {
+		check.Status = models.CheckSkipped
+		check.Message = fmt.Sprintf("%d tables restored, the dump selects tables or schemas", len(restored))
+		return check
+	}
+
+	var missing, extra []string
+	for table := range expected {
+		if _, ok := restored[table]; !ok {
+			missing = append(missing, table)
+		}
+	}
+	for table := range restored {
+		if _, ok := expected[table]; !ok {
+			extra = append(extra, table)
+		}
+	}
+	if len(missing) == 0 && len(extra) == 0 {
+		check.Status = models.CheckPassed
+		check.Message = fmt.Sprintf("%d tables restored", len(restored))
+		return check
+	}
+
+	check.Status = models.CheckFailed
+	check.Message = fmt.Sprintf("%d tables restored, %d %s", len(restored), len(expected), reference)
+	if len(missing) > 0 {
+		check.Message += ", missing " + listDetails(missing)
+	}
+	if len(extra) > 0 {
+		check.Message += fmt.Sprintf(", not %s %s", reference, listDetails(extra))
+	}
+	return check
+}
+
+// checkRows compares the row counts of the tables restored with the rows
+// expected, allowing them to differ by tolerance as a fraction of the larger
+// count plus slack rows. Tables expected with -1 rows have no estimate.
+func checkRows(options models.DumpOptions, restored, expected map[string]int64, reference string, tolerance float64, slack int64) models.VerificationCheck {
+	check := models.VerificationCheck{Name: "rows"}
+	switch {
+	case options.SchemaOnly:
+		check.Status = models.CheckSkipped
+		check.Message = "the dump has no data"
+		return check
+	case len(options.ExcludeTableData) > 0:
+		check.Status = models.CheckSkipped
+		check.Message = "the dump leaves out the data of some tables"
+		return check
+	}
+
+	var total int64
+	var differences []string
+	for table, rows := range restored {
+		total += rows
+		expectedRows, ok := expected[table]
+		if !ok || expectedRows < 0 {
+			continue
+		}
+		allowed := tolerance*math.Max(float64(rows), float64(expectedRows)) + float64(slack)
+		if diff := math.Abs(float64(rows - expectedRows)); diff > allowed {
+			differences = append(differences, fmt.Sprintf("%s (%d restored, %d %s)", table, rows, expectedRows, reference))
+		}
+	}
+	if len(differences) == 0 {
+		check.Status = models.CheckPassed
+		check.Message = fmt.Sprintf("%d rows restored", total)
+		return check
+	}
+
+	check.Status = models.CheckFailed
+	check.Message = fmt.Sprintf("row counts differ in %d tables: %s", len(differences), listDetails(differences))
+	return check
+}
+
+// listDetails joins the first few of a list of differences, sorted
+func listDetails(items []string) string {
+	sort.Strings(items)
+	if len(items) > maxCheckDetails {
+		return strings.Join(items[:maxCheckDetails], ", ") + fmt.Sprintf(" and %d more", len(items)-maxCheckDetails)
+	}
+	return strings.Join(items, ", ")
+}
+
+// postgresMajorVersion returns the major version of a server version like
+// "16.4 (Debian 16.4-1.pgdg120+1)" or "9.6.24", as images are tagged
+func postgresMajorVersion(version string) (string, error) {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("the server version of the backup is unknown")
+	}
+	parts := strings.SplitN(fields[0], ".", 3)
+	major, err := strconv.Atoi(leadingDigits(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("unrecognized server version %q", version)
+	}
+	// From 10 on the first number alone is the major version
+	if major >= 10 {
+		return strconv.Itoa(major), nil
+	}
+	if len(parts) < 2 || leadingDigits(parts[1]) == "" {
+		return "", fmt.Errorf("unrecognized server version %q", version)
+	}
+	return fmt.Sprintf("%d.%s", major, leadingDigits(parts[1])), nil
+}
+
+// leadingDigits returns the digits s starts with, like 16 of "16beta1"
+func leadingDigits(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+// verificationFailure describes why a verification failed
+func verificationFailure(verification models.BackupVerification) string {
+	if verification.Error != "" {
+		return verification.Error
+	}
+	var failed []string
+	for _, check := range verification.Checks {
+		if check.Status == models.CheckFailed {
+			failed = append(failed, check.Name+": "+check.Message)
+		}
+	}
+	return strings.Join(failed, "; ")
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"backend/internal/models"
+)
+
+func TestCheckTables(t *testing.T) {
+	restored := map[string]int64{"public.users": 10, "public.orders": 20}
+
+	for _, tc := range []struct {
+		name     string
+		options  models.DumpOptions
+		expected map[string]int64
+		status   string
+		message  string
+	}{
+		{"same tables", models.DumpOptions{}, map[string]int64{"public.users": -1, "public.orders": 18}, models.CheckPassed, "2 tables restored"},
+		{"missing table", models.DumpOptions{}, map[string]int64{"public.users": 10, "public.orders": 20, "public.items": 5}, models.CheckFailed, "2 tables restored, 3 at backup, missing public.items"},
+		{"extra table", models.DumpOptions{}, map[string]int64{"public.users": 10}, models.CheckFailed, "2 tables restored, 1 at backup, not at backup public.orders"},
+		{"selected tables", models.DumpOptions{Tables: []string{"public.users"}}, map[string]int64{"public.items": 5}, models.CheckSkipped, "the dump selects tables or schemas"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			check := checkTables(tc.options, restored, tc.expected, "at backup")
+			assert.Equal(t, tc.status, check.Status)
+			assert.Contains(t, check.Message, tc.message)
+		})
+	}
+}
+
+func TestCheckRows(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		options   models.DumpOptions
+		restored  int64
+		expected  int64
+		tolerance float64
+		slack     int64
+		status    string
+	}{
+		{"exact", models.DumpOptions{}, 1000, 1000, 0, 0, models.CheckPassed},
+		{"differs without tolerance", models.DumpOptions{}, 1000, 999, 0, 0, models.CheckFailed},
+		{"within tolerance", models.DumpOptions{}, 1000, 950, 0.1, 0, models.CheckPassed},
+		{"beyond tolerance", models.DumpOptions{}, 1000, 850, 0.1, 0, models.CheckFailed},
+		{"small table within slack", models.DumpOptions{}, 140, 100, rowEstimateTolerance, rowEstimateSlack, models.CheckPassed},
+		{"empty table beyond slack", models.DumpOptions{}, 0, 60, rowEstimateTolerance, rowEstimateSlack, models.CheckFailed},
+		{"no estimate", models.DumpOptions{}, 1000, -1, rowEstimateTolerance, rowEstimateSlack, models.CheckPassed},
+		{"schema only", models.DumpOptions{SchemaOnly: true}, 0, 1000, 0, 0, models.CheckSkipped},
+		{"excluded data", models.DumpOptions{ExcludeTableData: []string{"public.logs"}}, 0, 1000, 0, 0, models.CheckSkipped},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			check := checkRows(tc.options, map[string]int64{"public.users": tc.restored}, map[string]int64{"public.users": tc.expected}, "at backup", tc.tolerance, tc.slack)
+			assert.Equal(t, tc.status, check.Status, check.Message)
+		})
+	}
+}
+
+func TestCheckRowsMessage(t *testing.T) {
+	check := checkRows(models.DumpOptions{}, map[string]int64{"public.users": 10, "public.orders": 7}, map[string]int64{"public.users": 12, "public.orders": 7}, "in the source", 0, 0)
+	assert.Equal(t, models.CheckFailed, check.Status)
+	assert.Equal(t, "row counts differ in 1 tables: public.users (10 restored, 12 in the source)", check.Message)
+}
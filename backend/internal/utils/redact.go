@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redactionMask replaces secrets in log entries
+const redactionMask = "[REDACTED]"
+
+// RedactHook is a logrus hook masking known secrets in log messages and
+// string fields, as a last line of defence against logging credentials
+type RedactHook struct {
+	mu       sync.RWMutex
+	replacer *strings.Replacer
+}
+
+// NewRedactHook creates a hook masking the given secrets
+func NewRedactHook(secrets []string) *RedactHook {
+	h := &RedactHook{}
+	h.SetSecrets(secrets)
+	return h
+}
+
+// SetSecrets replaces the secrets masked by the hook
+func (h *RedactHook) SetSecrets(secrets []string) {
+	// Longer secrets go first, so that a secret containing another is
+	// masked as a whole
+	sorted := append([]string(nil), secrets...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	var pairs []string
+	for _, secret := range sorted {
+		if secret != "" {
+			pairs = append(pairs, secret, redactionMask)
+		}
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	h.mu.Lock()
+	h.replacer = replacer
+	h.mu.Unlock()
+}
+
+// Levels implements logrus.Hook
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (h *RedactHook) Fire(entry *logrus.Entry) error {
+	h.mu.RLock()
+	replacer := h.replacer
+	h.mu.RUnlock()
+
+	entry.Message = replacer.Replace(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = replacer.Replace(value)
+		case error:
+			entry.Data[key] = replacer.Replace(value.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactHook(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetLevel(logrus.DebugLevel)
+	hook := NewRedactHook([]string{"s3cret", "s3cret-longer", ""})
+	logger.AddHook(hook)
+
+	// Commands are logged at debug, formatted into the message
+	logger.Debugf("Built dump command: %s", "PGPASSWORD='s3cret-longer' pg_dump -d 'dbname=hr'")
+	logger.WithField("password", "s3cret").WithError(errors.New("auth with s3cret failed")).Info("Failed")
+
+	assert.NotContains(t, out.String(), "s3cret")
+	assert.Contains(t, out.String(), "PGPASSWORD='[REDACTED]' pg_dump")
+	assert.Contains(t, out.String(), "password=\"[REDACTED]\"")
+	assert.Contains(t, out.String(), "auth with [REDACTED] failed")
+
+	// Replaced secrets are masked from then on
+	out.Reset()
+	hook.SetSecrets([]string{"rotated"})
+	logger.Debugf("Command: psql -c 'ALTER ROLE app PASSWORD %s'", "rotated")
+	assert.Contains(t, out.String(), "PASSWORD [REDACTED]")
+}
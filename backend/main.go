@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,36 +18,109 @@ import (
 	"backend/internal/utils"
 )
 
+// configPath is the configuration file, reloaded when it changes
+const configPath = "configs/config.yaml"
+
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using system environment variables")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-secret" {
+		if err := encryptSecret(); err != nil {
+			log.Fatalf("Failed to encrypt secret: %v", err)
+		}
+		return
+	}
+
 	// Initialize logger
 	logger := utils.NewLogger()
 
 	// Load configuration
-	cfg, err := config.LoadConfig("configs/config.yaml")
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		logger.Fatalf("Failed to load config: %v", err)
 	}
+	configWatcher := config.NewWatcher(configPath, cfg, logger)
+	redactHook := utils.NewRedactHook(cfg.Secrets())
+	logger.AddHook(redactHook)
+	// Servers added through the API bring credentials of their own
+	cfg.ServerStore().OnChange(func() { redactHook.SetSecrets(configWatcher.Current().Secrets()) })
 
 	// Initialize services
 	dockerService := services.NewDockerService(logger)
-	sshService := services.NewSSHService(logger)
+	hostKeyStore, err := services.NewHostKeyStore(cfg.SSH, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize host key store: %v", err)
+	}
+	sshService := services.NewSSHService(hostKeyStore, logger)
 	postgresService := services.NewPostgresService(logger)
+	encryptionService, err := services.NewEncryptionService(cfg.Encryption, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize encryption: %v", err)
+	}
+	catalog, err := services.NewBackupCatalog(cfg.Backups, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize backup catalog: %v", err)
+	}
+	jobService, err := services.NewJobService(cfg.Jobs, postgresService, sshService, encryptionService, catalog, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize job service: %v", err)
+	}
+	jobService.Start()
+	storageService, err := services.NewStorageService(cfg.Backups, configWatcher, sshService, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize backup storage: %v", err)
+	}
+	authService, err := services.NewAuthService(cfg.Auth, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize authentication: %v", err)
+	}
+	auditLog, err := services.NewAuditLog(cfg.Audit, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize audit log: %v", err)
+	}
+	defer auditLog.Close()
+	scheduler, err := services.NewScheduler(configWatcher, storageService, catalog, dockerService, postgresService, sshService, jobService, encryptionService, auditLog, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize backup scheduler: %v", err)
+	}
+	scheduler.Start()
+	defer scheduler.Stop()
+	verificationService := services.NewVerificationService(configWatcher, dockerService, postgresService, sshService, storageService, encryptionService, catalog, logger)
+
+	// Apply configuration changes without a restart. The redaction hook
+	// goes first, so that new secrets are masked before they are used.
+	configWatcher.OnReload(func(next *config.Config) error {
+		redactHook.SetSecrets(next.Secrets())
+		return nil
+	})
+	configWatcher.OnReload(func(next *config.Config) error {
+		return authService.Reload(next.Auth)
+	})
+	configWatcher.OnReload(func(next *config.Config) error {
+		return encryptionService.Reload(next.Encryption)
+	})
+	// Schedules are checked against the keys applied above
+	configWatcher.OnReload(scheduler.Reload)
+	go configWatcher.Watch(context.Background())
 
 	// Initialize handlers
-	handler := handlers.NewHandler(cfg, dockerService, sshService, postgresService, logger)
+	handler := handlers.NewHandler(configWatcher, dockerService, sshService, postgresService, jobService, scheduler, storageService, catalog, verificationService, encryptionService, authService, auditLog, logger)
 
     r := gin.Default()
+    // The client address is recorded in the audit log and limits logins, so
+    // forwarded addresses are only believed from configured proxies
+    if err := r.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+        logger.Fatalf("Invalid http.trusted_proxies: %v", err)
+    }
 
     // Add CORS middleware if needed
     r.Use(func(c *gin.Context) {
         c.Header("Access-Control-Allow-Origin", "*")
         c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Dump-Passphrase, X-Dump-Identity")
         if c.Request.Method == "OPTIONS" {
             c.AbortWithStatus(204)
             return
@@ -62,13 +139,44 @@ func main() {
 
     // API routes
     api := r.Group("/api/v1")
+    api.POST("/auth/login", handler.Login)
+
+    // Everything registered after this requires authentication, the login
+    // route above keeps its own handler chain
+    api.Use(handler.RequireAuth)
     {
+        api.POST("/auth/logout", handler.Logout)
+        api.GET("/auth/me", handler.GetCurrentUser)
         api.GET("/servers", handler.GetServers)
+        api.POST("/servers", handler.CreateServer)
+        api.PUT("/servers/:serverID", handler.UpdateServer)
+        api.DELETE("/servers/:serverID", handler.DeleteServer)
+        api.GET("/discovery", handler.DiscoverServers)
+        api.GET("/servers/:serverID/host-key", handler.GetHostKey)
+        api.POST("/servers/:serverID/host-key/rotate", handler.RotateHostKey)
         api.GET("/servers/:serverID/containers", handler.GetContainers)
         api.GET("/servers/:serverID/containers/:containerID/databases", handler.GetDatabases)
         api.GET("/servers/:serverID/containers/:containerID/databases/:dbName/dump", handler.DownloadDump)
         api.GET("/servers/:serverID/host/databases", handler.GetHostDatabases)
         api.GET("/servers/:serverID/host/databases/:dbName/dump", handler.DownloadHostDump)
+        api.POST("/servers/:serverID/containers/:containerID/databases/:dbName/restore", handler.RestoreDump)
+        api.POST("/servers/:serverID/host/databases/:dbName/restore", handler.RestoreHostDump)
+        api.POST("/clone", handler.CloneDatabase)
+        api.POST("/jobs", handler.CreateJob)
+        api.GET("/jobs", handler.ListJobs)
+        api.GET("/jobs/:jobID", handler.GetJob)
+        api.DELETE("/jobs/:jobID", handler.CancelJob)
+        api.GET("/jobs/:jobID/artifact", handler.DownloadJobArtifact)
+        api.GET("/schedules", handler.ListSchedules)
+        api.POST("/schedules", handler.CreateSchedule)
+        api.PUT("/schedules/:name", handler.UpdateSchedule)
+        api.DELETE("/schedules/:name", handler.DeleteSchedule)
+        api.POST("/schedules/:name/run", handler.RunSchedule)
+        api.GET("/backups", handler.ListBackups)
+        api.GET("/backups/:backupID/download", handler.DownloadBackup)
+        api.POST("/backups/:backupID/verify", handler.VerifyBackup)
+        api.GET("/backups/:backupID/verification", handler.GetBackupVerification)
+        api.GET("/audit", handler.GetAuditLog)
     }
 
     // Start server
@@ -82,3 +190,24 @@ func main() {
         logger.Fatalf("Failed to start server: %v", err)
     }
 }
+
+// encryptSecret reads a secret from stdin and prints it encrypted with the
+// master key, for use as an "enc:" value in config.yaml
+func encryptSecret() error {
+	key, err := config.LoadMasterKey()
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	value, err := config.EncryptSecret(key, strings.TrimRight(string(data), "\r\n"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}